@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -15,6 +16,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"github.com/vyPal/CaffeineC/lib/cache"
 	"github.com/vyPal/CaffeineC/lib/compiler"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
 	"github.com/vyPal/CaffeineC/lib/parser"
 	"github.com/vyPal/CaffeineC/lib/project"
 )
@@ -88,6 +90,11 @@ func init() {
 				Aliases: []string{"n"},
 				Usage:   "Disables caching",
 			},
+			&cli.StringFlag{
+				Name:  "diagnostics-format",
+				Usage: "The format to report errors in: text, json or sarif. ",
+				Value: diagnostics.FormatText,
+			},
 		},
 		Action: build,
 	},
@@ -151,6 +158,11 @@ func init() {
 					Aliases: []string{"n"},
 					Usage:   "Disables caching",
 				},
+				&cli.StringFlag{
+					Name:  "diagnostics-format",
+					Usage: "The format to report errors in: text, json or sarif. ",
+					Value: diagnostics.FormatText,
+				},
 			},
 			Action: run,
 		},
@@ -169,7 +181,15 @@ var precompiledCache map[string]string
 var cwd string
 var builtFiles []cache.BuiltFile
 
+// Where diagnostics in the structured formats are written. The output of run
+// is the one of the program, so they go to stderr there.
+var diagnosticsOut io.Writer = os.Stdout
+
 func build(c *cli.Context) error {
+	format := c.String("diagnostics-format")
+	if !diagnostics.ValidFormat(format) {
+		return cli.Exit(color.RedString("Unknown diagnostics format: %s", format), 1)
+	}
 	outpath = c.String("output")
 	tmpDir, err = os.MkdirTemp("", "caffeinec")
 	defer os.RemoveAll(tmpDir)
@@ -245,7 +265,10 @@ func build(c *cli.Context) error {
 
 	llFiles, imports, err := processIncludes(append([]string{f}, c.StringSlice("include")...))
 	if err != nil {
-		return err
+		return reportDiagnostics(err, format)
+	}
+	if format != diagnostics.FormatText {
+		writeDiagnostics(diagnostics.List{}, format)
 	}
 
 	if header {
@@ -384,6 +407,7 @@ func build(c *cli.Context) error {
 }
 
 func run(c *cli.Context) error {
+	diagnosticsOut = os.Stderr
 	err := build(c)
 	if err != nil {
 		return err
@@ -406,7 +430,10 @@ func run(c *cli.Context) error {
 }
 
 func parseAndCompile(path string, wg *sync.WaitGroup, errs chan<- error, files *[]string, llfiles *[]string) (string, error) {
-	ast := parser.ParseFile(path)
+	ast, parseErr := parser.ParseFile(path)
+	if ast == nil {
+		return "", parseErr
+	}
 	if debug {
 		relativePath, err := filepath.Rel(cwd, path)
 		if err != nil || filepath.IsAbs(relativePath) {
@@ -441,6 +468,14 @@ func parseAndCompile(path string, wg *sync.WaitGroup, errs chan<- error, files *
 		return "", err
 	}
 	comp.Init(ast, wDir)
+	if parseErr != nil {
+		// A file with syntax errors is not compiled, but the statements that
+		// parsed are checked so their errors are reported at once
+		if err := comp.FindImports(); err != nil {
+			return "", withSyntaxErrors(parseErr, err)
+		}
+		return "", withSyntaxErrors(parseErr, comp.CheckPartial())
+	}
 	if precompiledCache[path] == "" {
 		err = comp.FindImports()
 		if err != nil {
@@ -458,7 +493,7 @@ func parseAndCompile(path string, wg *sync.WaitGroup, errs chan<- error, files *
 		}
 		err = comp.Compile()
 		if err != nil {
-			return "", cli.Exit(color.RedString("Error compiling: %s", err), 1)
+			return "", err
 		}
 
 		err := os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, strings.TrimSuffix(path, ".cffc")+".ll")), 0755)
@@ -508,6 +543,46 @@ func parseAndCompile(path string, wg *sync.WaitGroup, errs chan<- error, files *
 	return "", nil
 }
 
+// writeDiagnostics writes diags to diagnosticsOut in a structured format.
+// Files are given relative to the working directory, the root of the project.
+func writeDiagnostics(diags diagnostics.List, format string) {
+	root, err := os.Getwd()
+	if err != nil {
+		root = ""
+	}
+	diags.WriteFormat(diagnosticsOut, format, root)
+}
+
+// withSyntaxErrors returns the syntax errors of a file together with the
+// errors found checking the statements of it that parsed.
+func withSyntaxErrors(parseErr error, err error) error {
+	var diags diagnostics.List
+	diags.Report(parseErr)
+	diags.Report(err)
+	return diags.Err()
+}
+
+// reportDiagnostics prints the diagnostics carried by err in format and
+// returns the error to exit with. Text is meant for people and goes to
+// stderr, the structured formats go to diagnosticsOut for tools to read.
+func reportDiagnostics(err error, format string) error {
+	var diags diagnostics.List
+	diags.Report(err)
+	diags.Sort()
+	if format != diagnostics.FormatText {
+		writeDiagnostics(diags, format)
+		return cli.Exit("", 1)
+	}
+	diags.Write(os.Stderr)
+	errors := 0
+	for _, d := range diags {
+		if d.Severity == diagnostics.Error {
+			errors++
+		}
+	}
+	return cli.Exit(color.RedString("Compilation failed with %d error(s)", errors), 1)
+}
+
 func processIncludes(includes []string) ([]string, []string, error) {
 	var files []string
 	var llfiles []string
@@ -527,7 +602,12 @@ func processIncludes(includes []string) ([]string, []string, error) {
 		close(errs)
 	}()
 
-	if err, ok := <-errs; ok {
+	// Wait for every file, so the errors of all of them are reported
+	var diags diagnostics.List
+	for err := range errs {
+		diags.Report(err)
+	}
+	if err := diags.Err(); err != nil {
 		return nil, nil, err
 	}
 
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+	"github.com/vyPal/CaffeineC/lib/cache"
+	"github.com/vyPal/CaffeineC/lib/compiler"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+	"github.com/vyPal/CaffeineC/lib/parser"
+	"github.com/vyPal/CaffeineC/lib/project"
+)
+
+func init() {
+	commands = append(commands, &cli.Command{
+		Name:      "check",
+		Usage:     "Check a CaffeineC file and the files it imports for errors, without building it",
+		ArgsUsage: "[file]",
+		Category:  "compile",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "The path to the config file. ",
+				Aliases: []string{"c"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "include",
+				Aliases: []string{"i"},
+				Usage:   "Add a directory or file to the include path",
+			},
+			&cli.StringFlag{
+				Name:  "diagnostics-format",
+				Usage: "The format to report errors in: text, json or sarif. ",
+				Value: diagnostics.FormatText,
+			},
+		},
+		Action: check,
+	})
+}
+
+// check compiles a file and every file it imports in memory, reporting the
+// errors of all of them. Nothing is written to disk and no external tools are
+// run, so it is fast enough for editors and pre-commit hooks.
+func check(c *cli.Context) error {
+	format := c.String("diagnostics-format")
+	if !diagnostics.ValidFormat(format) {
+		return cli.Exit(color.RedString("Unknown diagnostics format: %s", format), 1)
+	}
+
+	f := c.Args().First()
+	if f == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return cli.Exit(color.RedString("Error getting current working directory: %s", err), 1)
+		}
+		confPath := wd + string(filepath.Separator) + "cfconf.yaml"
+		if c.String("config") != "" {
+			confPath = c.String("config")
+		}
+		confPath = strings.TrimSuffix(confPath, "cfconf.yaml")
+
+		conf, err := project.GetCfConf(confPath)
+		if err != nil {
+			return err
+		}
+		f = filepath.Join(confPath, conf.Main)
+	}
+
+	pc := cache.PackageCache{}
+	pc.Init()
+	pc.CacheScan(false)
+
+	queue, err := checkedFiles(append([]string{f}, c.StringSlice("include")...))
+	if err != nil {
+		return err
+	}
+
+	// Files are checked once, however many files import them
+	checked := make(map[string]bool)
+	var diags diagnostics.List
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		if checked[path] {
+			continue
+		}
+		checked[path] = true
+
+		imports, err := checkFile(path, pc)
+		diags.Report(err)
+		for _, imported := range imports {
+			if filepath.Ext(imported) == ".cffc" {
+				queue = append(queue, imported)
+			}
+		}
+	}
+
+	if err := diags.Err(); err != nil {
+		return reportDiagnostics(err, format)
+	}
+	if format != diagnostics.FormatText {
+		writeDiagnostics(diagnostics.List{}, format)
+	}
+	return nil
+}
+
+// checkedFiles returns the CaffeineC files among paths, and in the
+// directories among them.
+func checkedFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, cli.Exit(color.RedString("Unable to check %s: %s", path, err), 1)
+		}
+		if !info.IsDir() {
+			if filepath.Ext(path) == ".cffc" {
+				files = append(files, path)
+			}
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		var nested []string
+		for _, entry := range entries {
+			nested = append(nested, filepath.Join(path, entry.Name()))
+		}
+		dirFiles, err := checkedFiles(nested)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+// checkFile parses and compiles the file at path, stopping short of writing
+// out the module. A file with syntax errors is only checked. It returns the
+// files the file imports, which are checked on their own.
+func checkFile(path string, pc cache.PackageCache) ([]string, error) {
+	ast, parseErr := parser.ParseFile(path)
+	if ast == nil {
+		return nil, parseErr
+	}
+
+	comp := compiler.NewCompiler()
+	comp.PackageCache = pc
+	wDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	comp.Init(ast, wDir)
+	if err := comp.FindImports(); err != nil {
+		return comp.RequiredImports, withSyntaxErrors(parseErr, err)
+	}
+	if parseErr != nil {
+		// Only the statements that parsed are checked, they can not be
+		// compiled
+		return comp.RequiredImports, withSyntaxErrors(parseErr, comp.CheckPartial())
+	}
+	return comp.RequiredImports, comp.Compile()
+}
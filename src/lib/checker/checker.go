@@ -0,0 +1,708 @@
+// Package checker type checks CaffeineC programs before they are compiled.
+// It resolves the names and types used by a program and reports the errors it
+// finds, so they are reported together instead of one at a time by code
+// generation. Code generation compiles expressions with the types and implicit
+// conversions found by the checker.
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"go/constant"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// Importer returns the program imported as path by an import statement.
+type Importer func(path string) (*parser.Program, error)
+
+// Info holds the results of checking a program. The compiler reads Types,
+// Conversions, Literals and Defs, the other maps describe the program for
+// tests and tools.
+type Info struct {
+	// Types of the expressions, before implicit conversions are applied
+	Types map[*parser.Expression]Type
+	// Types expressions are implicitly converted to where they are used, like
+	// a pointer to a class converted to an interface or one of its parents
+	Conversions map[*parser.Expression]Type
+	// Types of number, string, null and array literals, which depend on where
+	// they are used
+	Literals map[*parser.Value]Type
+	// Variables and constants defined by variable definitions
+	Defs map[*parser.VariableDefinition]*Object
+	// Objects identifiers refer to
+	Uses map[*parser.Identifier]*Object
+	// Types written in the program
+	TypeNames map[*parser.Type]Type
+}
+
+// function is the function whose body is being checked.
+type function struct {
+	name   string
+	result Type
+	// Class of methods, nil for functions
+	class *Class
+}
+
+type Checker struct {
+	Info     *Info
+	importer Importer
+	// Imported files, by the path they are imported as
+	pkgs  map[string]*pkg
+	pkg   *pkg
+	diags diagnostics.List
+	// An import failed or statements did not parse, so names the program
+	// uses may be missing
+	incomplete bool
+	// Statements did not parse, so functions may be missing their return
+	partial bool
+
+	fn *function
+	// Loops, and loops and switches, the checked statement is in
+	loops  int
+	breaks int
+}
+
+// Check checks program, whose imports are read with importer. The returned
+// error holds the diagnostics of every problem found.
+func Check(program *parser.Program, importer Importer) (*Info, error) {
+	return check(program, importer, false)
+}
+
+// CheckPartial checks program, the statements recovered from a file with
+// syntax errors. Names that are not defined and functions that do not return
+// are not reported, the statements defining or returning them may be the ones
+// that failed to parse.
+func CheckPartial(program *parser.Program, importer Importer) (*Info, error) {
+	return check(program, importer, true)
+}
+
+func check(program *parser.Program, importer Importer, partial bool) (*Info, error) {
+	c := &Checker{
+		Info: &Info{
+			Types:       make(map[*parser.Expression]Type),
+			Conversions: make(map[*parser.Expression]Type),
+			Literals:    make(map[*parser.Value]Type),
+			Defs:        make(map[*parser.VariableDefinition]*Object),
+			Uses:        make(map[*parser.Identifier]*Object),
+			TypeNames:   make(map[*parser.Type]Type),
+		},
+		importer:   importer,
+		pkgs:       make(map[string]*pkg),
+		incomplete: partial,
+		partial:    partial,
+	}
+	c.pkg = &pkg{name: program.Package, exports: make(map[string]*Object)}
+	c.pkg.scope = &Scope{objects: make(map[string]*Object), pkg: c.pkg}
+
+	// Imports are visible from the whole file, wherever they are written
+	for _, s := range program.Statements {
+		if isImport(s) {
+			c.stmtRecover(s, c.pkg.scope)
+		}
+	}
+	for _, s := range program.Statements {
+		if !isImport(s) {
+			c.stmtRecover(s, c.pkg.scope)
+		}
+	}
+	return c.Info, c.diags.Err()
+}
+
+func isImport(s *parser.Statement) bool {
+	return s.Import != nil || s.FromImport != nil || s.FromImportMultiple != nil
+}
+
+// stmtRecover checks s, turning a panic of the checker into an error at the
+// position of s.
+func (c *Checker) stmtRecover(s *parser.Statement, scope *Scope) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.diags.Report(diagnostics.Errorf(diagnostics.CodeInternal, diagnostics.At(s.Pos), "Internal checker error: %v", r))
+		}
+	}()
+	c.stmt(s, scope)
+}
+
+// errorf reports an error of kind code at pos. Errors in imported files are
+// reported when the files themselves are compiled, so they are dropped here.
+func (c *Checker) errorf(code string, pos lexer.Position, format string, args ...interface{}) *diagnostics.Diagnostic {
+	d := diagnostics.Errorf(code, diagnostics.At(pos), format, args...)
+	if !c.pkg.imported {
+		c.diags = append(c.diags, d)
+	}
+	return d
+}
+
+func (c *Checker) typeError(pos lexer.Position, format string, args ...interface{}) *diagnostics.Diagnostic {
+	return c.errorf(diagnostics.CodeType, pos, format, args...)
+}
+
+// undefined reports the use of a name that is not defined. Names are not
+// reported once an import failed, as they may well be defined by the file
+// that could not be imported.
+func (c *Checker) undefined(pos lexer.Position, format string, args ...interface{}) *diagnostics.Diagnostic {
+	d := diagnostics.Errorf(diagnostics.CodeUndefined, diagnostics.At(pos), format, args...)
+	if !c.pkg.imported && !c.incomplete {
+		c.diags = append(c.diags, d)
+	}
+	return d
+}
+
+// declare adds obj to scope, and to the exports of the package if it is
+// exported.
+func (c *Checker) declare(scope *Scope, obj *Object, exported bool) {
+	scope.insert(obj)
+	if exported {
+		c.pkg.exports[obj.Name] = obj
+	}
+}
+
+// importStmt declares the names imported by s in scope.
+func (c *Checker) importStmt(s *parser.Statement, scope *Scope) {
+	var path string
+	switch {
+	case s.Import != nil:
+		path = s.Import.Package
+	case s.FromImport != nil:
+		path = s.FromImport.Package
+	default:
+		path = s.FromImportMultiple.Package
+	}
+	p, err := c.importPackage(strings.Trim(path, "\""))
+	if err != nil {
+		if !c.pkg.imported {
+			c.incomplete = true
+			c.diags.Report(importError(s.Pos, err))
+		}
+		return
+	}
+
+	switch {
+	case s.Import != nil && s.Import.Alias != "":
+		c.declare(scope, &Object{Kind: ModuleObject, Name: s.Import.Alias, Pos: s.Pos, module: &Scope{objects: p.exports, pkg: p}}, false)
+	case s.Import != nil:
+		for _, obj := range p.exports {
+			scope.insert(obj)
+		}
+	case s.FromImport != nil:
+		c.importSymbol(s.Pos, p, path, strings.Trim(s.FromImport.Symbol, "\""), s.FromImport.Alias, scope)
+	default:
+		for _, symbol := range s.FromImportMultiple.Symbols {
+			c.importSymbol(s.Pos, p, path, strings.Trim(symbol.Name, "\""), symbol.Alias, scope)
+		}
+	}
+}
+
+func (c *Checker) importSymbol(pos lexer.Position, p *pkg, path string, name string, alias string, scope *Scope) {
+	obj, ok := p.exports[name]
+	if !ok {
+		var names []string
+		for name := range p.exports {
+			names = append(names, name)
+		}
+		c.errorf(diagnostics.CodeImport, pos, "%s does not export %s", path, name).Suggest(name, names)
+		return
+	}
+	if alias == "" || alias == name {
+		scope.insert(obj)
+		return
+	}
+	renamed := *obj
+	renamed.Name = strings.Trim(alias, "\"")
+	scope.insert(&renamed)
+}
+
+// importError places err at the import statement at pos, unless it is a
+// diagnostic that already points into the imported file.
+func importError(pos lexer.Position, err error) error {
+	var list diagnostics.List
+	if errors.As(err, &list) {
+		return err
+	}
+	var d *diagnostics.Diagnostic
+	if errors.As(err, &d) {
+		if d.Span.IsZero() {
+			d.Span = diagnostics.At(pos)
+		}
+		return d
+	}
+	return diagnostics.Errorf(diagnostics.CodeImport, diagnostics.At(pos), "%s", err)
+}
+
+// importPackage declares the file imported as path. Only its declarations and
+// templates are checked, the file itself is checked when it is compiled.
+// Templates are compiled with the file importing them, which needs their
+// types.
+func (c *Checker) importPackage(path string) (*pkg, error) {
+	if p, ok := c.pkgs[path]; ok {
+		return p, nil
+	}
+	program, err := c.importer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pkg{name: program.Package, exports: make(map[string]*Object), imported: true}
+	p.scope = &Scope{objects: make(map[string]*Object), pkg: p}
+	c.pkgs[path] = p
+
+	outer := c.pkg
+	c.pkg = p
+	defer func() { c.pkg = outer }()
+	for _, s := range program.Statements {
+		if isImport(s) {
+			c.importStmt(s, p.scope)
+		}
+	}
+	for _, s := range program.Statements {
+		exported := false
+		if s.Export != nil {
+			s, exported = s.Export, true
+		}
+		c.declareStmt(s, p.scope, exported)
+	}
+	for _, s := range program.Statements {
+		if s.Export != nil {
+			s = s.Export
+		}
+		switch {
+		case s.FunctionDefinition != nil && len(s.FunctionDefinition.TypeParams) > 0:
+			f := s.FunctionDefinition
+			obj := p.scope.objects[strings.Trim(f.Name.Name, "\"")]
+			c.body(f, obj.Type.(*Signature), nil, typeParamScope(p.scope, f.TypeParams, nil))
+		case s.ClassDefinition != nil && len(s.ClassDefinition.TypeParams) > 0:
+			c.classBody(s.ClassDefinition, p.scope.objects[s.ClassDefinition.Name].Type.(*Class), p.scope)
+		}
+	}
+	return p, nil
+}
+
+// declareStmt declares the names defined by the top level statement s of an
+// imported file, without checking its bodies.
+func (c *Checker) declareStmt(s *parser.Statement, scope *Scope, exported bool) {
+	switch {
+	case s.FunctionDefinition != nil:
+		c.declare(scope, c.funcObject(s.FunctionDefinition, scope, false), exported)
+	case s.External != nil:
+		c.declare(scope, c.externObject(s.External, scope, false), exported)
+	case s.ClassDefinition != nil:
+		c.declare(scope, c.classObject(s.ClassDefinition, scope), exported)
+	case s.Interface != nil:
+		c.declare(scope, c.interfaceObject(s.Interface, scope), exported)
+	case s.Enum != nil:
+		c.declare(scope, c.enumObject(s.Enum, scope), exported)
+	case s.VariableDefinition != nil:
+		v := s.VariableDefinition
+		obj := &Object{Kind: VarObject, Name: v.Name, Type: Invalid, Pos: v.Pos}
+		if v.Constant == "const" {
+			obj.Kind = ConstObject
+		}
+		var hint Type
+		if v.Type != nil {
+			obj.Type = c.resolveType(v.Type, scope, false)
+			hint = obj.Type
+		}
+		// The initializer is needed to know the type of the variable, and
+		// the value of constants
+		if v.Assignment != nil && (v.Type == nil || obj.Kind == ConstObject) {
+			x := c.exprHint(v.Assignment, scope, hint)
+			if v.Type == nil {
+				obj.Type = c.infer(v, &x)
+				obj.Inferred = true
+			} else if !c.assign(&x, v.Assignment, obj.Type, "the definition of "+v.Name) {
+				x.val = nil
+			}
+			if obj.Kind == ConstObject {
+				obj.Value = x.val
+			}
+		}
+		c.Info.Defs[v] = obj
+		c.declare(scope, obj, exported)
+	}
+}
+
+func (c *Checker) funcObject(f *parser.FunctionDefinition, scope *Scope, report bool) *Object {
+	obj := &Object{Kind: FuncObject, Name: strings.Trim(f.Name.Name, "\""), Pos: f.Pos}
+	if len(f.TypeParams) > 0 {
+		obj.generic = f
+		obj.scope = scope
+		obj.Type = c.signature(f.Parameters, f.Variadic != "", f.ReturnType, typeParamScope(scope, f.TypeParams, nil), report)
+		return obj
+	}
+	obj.Type = c.signature(f.Parameters, f.Variadic != "", f.ReturnType, scope, report)
+	return obj
+}
+
+func (c *Checker) externObject(f *parser.ExternalFunctionDefinition, scope *Scope, report bool) *Object {
+	sig := c.signature(f.Parameters, false, f.ReturnType, scope, report)
+	sig.Variadic = f.Variadic
+	return &Object{Kind: FuncObject, Name: strings.Trim(f.Name, "\""), Type: sig, Pos: f.Pos}
+}
+
+func (c *Checker) classObject(def *parser.ClassDefinition, scope *Scope) *Object {
+	decl := &classDecl{name: def.Name, def: def, scope: scope, instances: make(map[string]*classMembers)}
+	return &Object{Kind: TypeObject, Name: def.Name, Type: &Class{decl: decl}, Pos: def.Pos}
+}
+
+func (c *Checker) interfaceObject(def *parser.InterfaceDefinition, scope *Scope) *Object {
+	decl := &interfaceDecl{name: def.Name, def: def, scope: scope}
+	return &Object{Kind: TypeObject, Name: def.Name, Type: &Interface{decl: decl}, Pos: def.Pos}
+}
+
+func (c *Checker) enumObject(def *parser.EnumDefinition, scope *Scope) *Object {
+	decl := &enumDecl{name: def.Name, def: def, scope: scope}
+	return &Object{Kind: TypeObject, Name: def.Name, Type: &Enum{decl: decl}, Pos: def.Pos}
+}
+
+// signature resolves the type of a function or method. The extra arguments of
+// variadic functions are not part of its parameters.
+func (c *Checker) signature(params []*parser.ArgumentDefinition, variadic bool, results []*parser.Type, scope *Scope, report bool) *Signature {
+	sig := &Signature{Variadic: variadic}
+	for _, p := range params {
+		sig.Params = append(sig.Params, c.resolveType(p.Type, scope, report))
+	}
+	sig.Result = c.resultType(results, scope, report)
+	return sig
+}
+
+func (c *Checker) resultType(results []*parser.Type, scope *Scope, report bool) Type {
+	switch len(results) {
+	case 0:
+		return VoidT
+	case 1:
+		return c.resolveType(results[0], scope, report)
+	}
+	tuple := &Tuple{}
+	for _, t := range results {
+		tuple.Types = append(tuple.Types, c.resolveType(t, scope, report))
+	}
+	return tuple
+}
+
+// typeParamScope returns a scope in which the type parameters params refer to
+// args, or stand for any type if args is nil.
+func typeParamScope(scope *Scope, params []string, args []Type) *Scope {
+	s := newScope(scope)
+	for i, param := range params {
+		var typ Type = &TypeParam{Name: param}
+		if i < len(args) {
+			typ = args[i]
+		}
+		s.insert(&Object{Kind: TypeObject, Name: param, Type: typ})
+	}
+	return s
+}
+
+// lookupQualified looks up a name that may be qualified with the alias of an
+// imported module, like io.File.
+func lookupQualified(name string, scope *Scope) *Object {
+	parts := strings.Split(name, ".")
+	obj := scope.lookup(parts[0])
+	for _, part := range parts[1:] {
+		if obj == nil || obj.Kind != ModuleObject {
+			return nil
+		}
+		obj = obj.module.objects[part]
+	}
+	return obj
+}
+
+// resolveType resolves the type t written in scope. Types that can not be
+// resolved are reported if report is set and are invalid.
+func (c *Checker) resolveType(t *parser.Type, scope *Scope, report bool) Type {
+	typ := c.resolveNamedType(t, scope, report)
+	for i := 0; i < strings.Count(t.Ptr, "*"); i++ {
+		typ = &Pointer{Elem: typ}
+	}
+
+	if t.Array != nil {
+		n, ok := c.constantInt(t.Array, scope)
+		if !ok || n < 0 {
+			if report {
+				c.errorf(diagnostics.CodeCompile, t.Array.Pos, "Array size is not a constant integer")
+			}
+			n = -1
+		}
+		typ = &Array{Len: n, Elem: typ}
+	}
+	if report {
+		c.Info.TypeNames[t] = typ
+	}
+	return typ
+}
+
+func (c *Checker) resolveNamedType(t *parser.Type, scope *Scope, report bool) Type {
+	if t.Inner != nil {
+		return c.resolveType(t.Inner, scope, report)
+	}
+
+	obj := lookupQualified(t.Name, scope)
+	if obj == nil || obj.Kind != TypeObject {
+		if typ, ok := basicType(t.Name); ok && len(t.TypeArgs) == 0 {
+			return typ
+		}
+		if report {
+			c.errorf(diagnostics.CodeUnknownType, t.Pos, "Unknown type: %s", t.Name).Suggest(t.Name, c.typeNames(scope))
+		}
+		return Invalid
+	}
+
+	class, ok := obj.Type.(*Class)
+	params := 0
+	if ok && class.Args == nil {
+		params = len(class.decl.def.TypeParams)
+	}
+	if params == 0 {
+		if len(t.TypeArgs) > 0 {
+			if report {
+				c.typeError(t.Pos, "Class %s is not generic", t.Name)
+			}
+			return Invalid
+		}
+		return obj.Type
+	}
+	if len(t.TypeArgs) != params {
+		if report {
+			c.typeError(t.Pos, "Class %s expects %d type arguments, got %d", t.Name, params, len(t.TypeArgs))
+		}
+		return Invalid
+	}
+	args := make([]Type, len(t.TypeArgs))
+	for i, arg := range t.TypeArgs {
+		args[i] = c.resolveType(arg, scope, report)
+	}
+	return &Class{decl: class.decl, Args: args}
+}
+
+// typeNames returns the names of the types visible from scope.
+func (c *Checker) typeNames(scope *Scope) []string {
+	return append(scope.names(TypeObject), "i8", "i16", "i32", "i64", "u8", "u16", "u32", "u64", "f32", "f64", "void")
+}
+
+// constantInt evaluates an integer constant expression, like the size of an
+// array. Only literals, enum variants and constants are supported.
+func (c *Checker) constantInt(e *parser.Expression, scope *Scope) (int64, bool) {
+	f := e.SingleFactor()
+	if f == nil || f.Unpack {
+		return 0, false
+	}
+	if f.Value != nil && f.Value.Int != nil {
+		return *f.Value.Int, true
+	}
+	if f.Identifier == nil || f.Identifier.GEP != nil || f.Identifier.Ref != "" || f.Identifier.Deref != "" {
+		return 0, false
+	}
+
+	obj := scope.lookup(f.Identifier.Name)
+	ident := f.Identifier
+	for obj != nil && obj.Kind == ModuleObject && ident.Sub != nil {
+		ident = ident.Sub
+		obj = obj.module.objects[ident.Name]
+	}
+	if obj == nil {
+		return 0, false
+	}
+	if enum, ok := obj.Type.(*Enum); ok && obj.Kind == TypeObject && ident.Sub != nil && ident.Sub.Sub == nil && !enum.Tagged() {
+		index := enum.variant(ident.Sub.Name)
+		return int64(index), index >= 0
+	}
+	if obj.Kind != ConstObject || obj.Value == nil || ident.Sub != nil {
+		return 0, false
+	}
+	return constant.Int64Val(constant.ToInt(obj.Value))
+}
+
+// classScope returns the scope the members of class are resolved in, where
+// its type parameters refer to its type arguments.
+func classScope(class *Class) *Scope {
+	if len(class.decl.def.TypeParams) == 0 {
+		return class.decl.scope
+	}
+	return typeParamScope(class.decl.scope, class.decl.def.TypeParams, class.Args)
+}
+
+// methodName returns the name of the member implementing a method, like
+// methodSuffix of the compiler does.
+func methodName(name parser.FuncName) string {
+	trimmed := strings.Trim(name.Name, "\"")
+	if name.Op {
+		return "op." + trimmed
+	} else if name.Get {
+		return "get." + trimmed
+	} else if name.Set {
+		return "set." + trimmed
+	}
+	return name.Name
+}
+
+// members returns the fields and methods of class, including the ones it
+// inherits. They are resolved on first use.
+func (c *Checker) members(class *Class) *classMembers {
+	key := class.String()
+	if m, ok := class.decl.instances[key]; ok {
+		return m
+	}
+	m := &classMembers{members: make(map[string]*member)}
+	// Registered before the parent is resolved, so a class extending itself
+	// does not resolve forever
+	class.decl.instances[key] = m
+
+	def := class.decl.def
+	scope := classScope(class)
+	if def.Extends != "" {
+		if obj := lookupQualified(def.Extends, class.decl.scope); obj != nil && obj.Kind == TypeObject {
+			if parent, ok := obj.Type.(*Class); ok && len(parent.decl.def.TypeParams) == 0 {
+				m.parent = parent
+				inherited := c.members(parent)
+				for name, mem := range inherited.members {
+					m.members[name] = mem
+				}
+				m.fields = append(m.fields, inherited.fields...)
+			}
+		}
+	}
+
+	// Members defined twice are reported by classDef, the first definition
+	// is used
+	own := make(map[string]bool)
+	for _, s := range def.Body {
+		if f := s.FieldDefinition; f != nil {
+			if own[f.Name] {
+				continue
+			}
+			own[f.Name] = true
+			m.members[f.Name] = &member{name: f.Name, typ: c.resolveType(f.Type, scope, false), private: f.Private, static: f.Static, owner: class, pos: f.Pos}
+			if !f.Static {
+				m.fields = append(m.fields, f.Name)
+			}
+		} else if f := s.FunctionDefinition; f != nil {
+			sig := c.signature(f.Parameters, f.Variadic != "", f.ReturnType, scope, false)
+			name := methodName(f.Name)
+			if own[name] {
+				continue
+			}
+			own[name] = true
+			m.members[name] = &member{name: name, typ: sig, method: true, private: f.Private, static: f.Static, owner: class, pos: f.Pos}
+		}
+	}
+	return m
+}
+
+// lookupMember returns the member name of the class typ is, or points to.
+func (c *Checker) lookupMember(typ Type, name string) (*member, bool) {
+	class, ok := classOf(typ)
+	if !ok {
+		return nil, false
+	}
+	mem, ok := c.members(class).members[name]
+	return mem, ok
+}
+
+// methodNames returns the names of the methods of class, to suggest when an
+// unknown method is called.
+func (c *Checker) methodNames(class *Class) []string {
+	var names []string
+	for name, mem := range c.members(class).members {
+		if mem.method && !strings.Contains(name, ".") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// fieldNames returns the names of the fields and properties of class, to
+// suggest when an unknown field is used.
+func (c *Checker) fieldNames(class *Class) []string {
+	var names []string
+	for name, mem := range c.members(class).members {
+		if !mem.method && !mem.static {
+			names = append(names, name)
+		} else if prop := strings.TrimPrefix(strings.TrimPrefix(name, "get."), "set."); mem.method && prop != name {
+			names = append(names, prop)
+		}
+	}
+	return names
+}
+
+// isSubclass reports whether class is base or inherits from it.
+func (c *Checker) isSubclass(class *Class, base *Class) bool {
+	for seen := 0; class != nil && seen < 64; seen++ {
+		if Identical(class, base) {
+			return true
+		}
+		class = c.members(class).parent
+	}
+	return false
+}
+
+// interfaceMethods returns the signatures of the methods of iface.
+func (c *Checker) interfaceMethods(iface *Interface) map[string]*Signature {
+	decl := iface.decl
+	if decl.methods == nil {
+		decl.methods = make(map[string]*Signature)
+		for _, m := range decl.def.Methods {
+			if _, ok := decl.methods[m.Name]; ok {
+				// Reported where the interface is declared
+				continue
+			}
+			decl.methods[m.Name] = c.signature(m.Parameters, false, m.ReturnType, decl.scope, false)
+		}
+	}
+	return decl.methods
+}
+
+// missingMethod returns why class does not implement iface, like "method
+// area is missing", or an empty string if it does.
+func (c *Checker) missingMethod(class *Class, iface *Interface) string {
+	methods := c.interfaceMethods(iface)
+	for _, m := range iface.decl.def.Methods {
+		mem, ok := c.lookupMember(class, m.Name)
+		if !ok || !mem.method || mem.static {
+			return fmt.Sprintf("method %s is missing", m.Name)
+		}
+		if mem.private {
+			return fmt.Sprintf("method %s is private", m.Name)
+		}
+		if !Identical(mem.typ, methods[m.Name]) {
+			return fmt.Sprintf("method %s has the wrong signature", m.Name)
+		}
+	}
+	return ""
+}
+
+// variant returns the index of the variant name of enum, or -1.
+func (t *Enum) variant(name string) int {
+	for i, v := range t.decl.def.Variants {
+		if v.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Enum) variantNames() []string {
+	var names []string
+	for _, v := range t.decl.def.Variants {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// payload returns the types of the values carried by variant index of enum.
+func (c *Checker) payload(enum *Enum, index int) []Type {
+	decl := enum.decl
+	if decl.payloads == nil {
+		for _, v := range decl.def.Variants {
+			var payload []Type
+			for _, t := range v.Payload {
+				payload = append(payload, c.resolveType(t, decl.scope, false))
+			}
+			decl.payloads = append(decl.payloads, payload)
+		}
+	}
+	return decl.payloads[index]
+}
@@ -0,0 +1,282 @@
+package checker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// checkSource parses and checks src, whose imports all fail.
+func checkSource(t *testing.T, src string) (*Info, diagnostics.List) {
+	t.Helper()
+	program, err := parser.ParseString(src)
+	if err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+	info, err := Check(program, func(path string) (*parser.Program, error) {
+		return nil, errors.New("no such file: " + path)
+	})
+	var list diagnostics.List
+	if err != nil && !errors.As(err, &list) {
+		t.Fatalf("error is not a list of diagnostics: %s", err)
+	}
+	return info, list
+}
+
+const declarations = `package main;
+enum Color { Red, Green }
+class T {
+  private n: i64;
+  func get celsius(): i64 { return this.n; }
+}
+class W {
+  func set only(v: i64) { }
+}
+func f(a: i64): i64 { return a; }
+`
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// Code and message of the error expected, or empty if the
+		// body is valid
+		code    string
+		message string
+	}{
+		{"valid", "var x: i64 = f(1); x++;", "", ""},
+		{"mismatched definition", "var x: i64 = true;", diagnostics.CodeType, "Cannot use a value of type i1 as i64 in the definition of x"},
+		{"undefined variable", "var y = z;", diagnostics.CodeUndefined, "Variable z not found"},
+		{"integer as enum", "var c: Color = 1;", diagnostics.CodeType, "Cannot use untyped int as Color in the definition of c"},
+		{"assignment to constant", "const k: i64 = 1; k = 2;", diagnostics.CodeCompile, "Cannot assign to constant k"},
+		{"increment of constant", "const k: i64 = 1; k++;", diagnostics.CodeCompile, "Cannot increment constant k"},
+		{"empty array", "var e: [0]i64 = [];", "", ""},
+		{"untyped empty array", "var e = [];", diagnostics.CodeType, "Unable to infer the type of an empty array literal"},
+		{"array length", "var s: [2]i64 = [1, 2, 3];", diagnostics.CodeType, "Array literal has 3 elements, but 2 were expected"},
+		{"argument count", "f(1, 2);", diagnostics.CodeType, "Function f expects 1 arguments, got 2"},
+		{"argument type", `var q: i64 = f("s");`, diagnostics.CodeType, "Cannot use a value of type *i8 as i64 in argument 1 of function f"},
+		{"break outside of loop", "break;", diagnostics.CodeCompile, "break can only be used inside of loops and switch statements"},
+		{"increment without getter", "var w: W = new W(); w.only++;", diagnostics.CodeType, "Property only can not be read, it has no getter"},
+		{"increment of variant", "Color.Red++;", diagnostics.CodeType, "Operator ++ can not be used on an enum variant"},
+		{"assignment without setter", "var t: T = new T(); t.celsius = 4;", diagnostics.CodeCompile, "Property celsius of class T is read-only"},
+		{"condition", "if (1) {}", diagnostics.CodeType, "if condition must be a boolean, got untyped int"},
+		{"import failure", `from "./missing.cffc" import g; g();`, diagnostics.CodeImport, "missing.cffc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, diags := checkSource(t, declarations+"func main(): i32 {\n"+tt.body+"\nreturn 0;\n}\n")
+			if tt.code == "" {
+				if len(diags) != 0 {
+					t.Fatalf("unexpected errors: %s", diags)
+				}
+				return
+			}
+			// Every problem is reported once
+			if len(diags) != 1 {
+				t.Fatalf("expected 1 error, got %d: %s", len(diags), diags)
+			}
+			if diags[0].Code != tt.code || !strings.Contains(diags[0].Message, tt.message) {
+				t.Errorf("expected error[%s] %q, got error[%s] %q", tt.code, tt.message, diags[0].Code, diags[0].Message)
+			}
+		})
+	}
+}
+
+func TestCheckErrorPositions(t *testing.T) {
+	_, diags := checkSource(t, "package main;\nfunc main(): i32 {\n  var x: i64 = y;\n  return;\n}\n")
+	expected := []string{"3:16", "4:9"}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d errors, got %d: %s", len(expected), len(diags), diags)
+	}
+	for i, pos := range expected {
+		if got := diags[i].Span.String(); !strings.HasSuffix(got, pos) {
+			t.Errorf("error %d is at %s, expected %s", i+1, got, pos)
+		}
+	}
+}
+
+func TestCheckInfo(t *testing.T) {
+	info, diags := checkSource(t, `package main;
+class A { }
+class B extends A { }
+func main(): i32 {
+  var a: *A = new B();
+  var n: i8 = 3;
+  return 0;
+}
+`)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected errors: %s", diags)
+	}
+
+	conversions := map[string]string{}
+	for e, typ := range info.Conversions {
+		conversions[info.Types[e].String()] = typ.String()
+	}
+	if conversions["*B"] != "*A" {
+		t.Errorf("expected the conversion of *B to *A to be recorded, got %v", conversions)
+	}
+
+	literals := map[string]bool{}
+	for _, typ := range info.Literals {
+		literals[typ.String()] = true
+	}
+	if !literals["i8"] {
+		t.Errorf("expected a literal of type i8, got %v", literals)
+	}
+	if len(info.Defs) != 2 {
+		t.Errorf("expected 2 definitions, got %d", len(info.Defs))
+	}
+}
+
+func TestCheckPartial(t *testing.T) {
+	program, err := parser.ParseString("package main;\nfunc f(a: i64 { return a; }\nfunc main(): i32 {\n  var y: i64 = f(1);\n  var z: i64 = true;\n  return (;\n}\n")
+	if err == nil {
+		t.Fatal("expected a syntax error")
+	}
+	_, err = CheckPartial(program, nil)
+	var diags diagnostics.List
+	if !errors.As(err, &diags) {
+		t.Fatalf("expected a list of diagnostics, got %v", err)
+	}
+	// f and the return failed to parse, so only the type error of z is
+	// reported
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "definition of z") {
+		t.Errorf("expected only the error of z, got %s", diags)
+	}
+}
+
+func TestCheckDuplicateMembers(t *testing.T) {
+	_, diags := checkSource(t, `package main;
+class V {
+  x: i64;
+  func op "-"(o: *V): *V { return o; }
+  func op "-"(): *V { return this; }
+  func x(): i64 { return 1; }
+}
+func main(): i32 {
+  var a: *V = new V();
+  var b: *V = a - a;
+  return 0;
+}
+`)
+	expected := []string{"Class V already defines operator -", "Class V already defines method x"}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d errors, got %d: %s", len(expected), len(diags), diags)
+	}
+	for i, message := range expected {
+		if diags[i].Message != message {
+			t.Errorf("expected error %d to be %q, got %q", i+1, message, diags[i].Message)
+		}
+		if len(diags[i].Notes) != 1 {
+			t.Errorf("expected error %d to point to the first definition", i+1)
+		}
+	}
+}
+
+func TestCheckDeclarationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		message string
+	}{
+		{"non-exhaustive match", "enum E { A, B, C }\nfunc f(e: E) { match (e) { case A: return; } }", "Match on enum E is not exhaustive, missing B, C"},
+		{"variant matched twice", "enum E { A, B }\nfunc f(e: E) { match (e) { case A: return; case A, B: return; } }", "Variant A is matched more than once"},
+		{"duplicate variant", "enum E { A, A }", "Variant A is already defined in enum E"},
+		{"duplicate interface method", "interface I { func f(): i64; func f(): i64; }", "Method f is already defined in interface I"},
+		{"override mismatch", "class A { func f(x: i64) {} }\nclass B extends A { func f(x: f64) {} }", "Method f of class B does not match the signature of the method it overrides"},
+		{"private override", "class A { func f() {} }\nclass B extends A { private func f() {} }", "Private method f of class B can not override a method of its parent"},
+		{"inherited field", "class A { x: i64; }\nclass B extends A { x: i64; }", "Field x is already defined in class B"},
+		{"operator arity", `class A { func op "!"(o: *A): i1 { return true; } }`, "Operator ! of class A can not take 1 arguments"},
+		{"static operator", `class A { static func op "+"(o: *A): *A { return o; } }`, "Operators, accessors and constructors can not be static"},
+		{"static member of generic class", "class Box<T> { static n: i64; }", "Generic class Box can not have static members"},
+		{"missing interface method", "interface I { func f(): i64; }\nclass A implements I { }", "Class A does not implement interface I, method f is missing"},
+		{"wrong interface signature", "interface I { func f(): i64; }\nclass A implements I { func f(): i32 { return 0; } }", "Class A does not implement interface I, method f has the wrong signature"},
+		{"private interface method", "interface I { func f(): i64; }\nclass A implements I { private func f(): i64 { return 0; } }", "Class A does not implement interface I, method f is private"},
+		{"conversion to interface", "interface I { func f(): i64; }\nclass A { }\nfunc g() { var i: I = new A(); }", "Class A does not implement interface I, method f is missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, diags := checkSource(t, "package main;\n"+tt.src+"\n")
+			if len(diags) != 1 {
+				t.Fatalf("expected 1 error, got %d: %s", len(diags), diags)
+			}
+			if diags[0].Message != tt.message {
+				t.Errorf("expected %q, got %q", tt.message, diags[0].Message)
+			}
+		})
+	}
+}
+
+func TestCheckLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// Column the error points at, the help and the note it has, if any
+		column int
+		help   string
+		note   string
+	}{
+		{"assignment", "var b: i64 = 1; b = true;", 21, "", "variable b declared here"},
+		{"inferred variable", "var b = 1; b = true;", 16, "", "b is inferred to be of type i64 from its initializer"},
+		{"missing field", "var t: T = new T(); var n: i64 = t.nn;", 36, "did you mean `n`?", ""},
+		{"missing property", "var t: T = new T(); var n: i64 = t.celsuis;", 36, "did you mean `celsius`?", ""},
+		{"missing method", "var t: T = new T(); t.celsius2();", 23, "", ""},
+		{"missing static method", "T.make();", 3, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, diags := checkSource(t, declarations+"func main(): i32 {\n"+tt.body+"\nreturn 0;\n}\n")
+			if len(diags) != 1 {
+				t.Fatalf("expected 1 error, got %d: %s", len(diags), diags)
+			}
+			d := diags[0]
+			if d.Span.Column != tt.column {
+				t.Errorf("expected the error at column %d, got %d", tt.column, d.Span.Column)
+			}
+			if help := strings.Join(d.Help, "; "); help != tt.help {
+				t.Errorf("expected help %q, got %q", tt.help, help)
+			}
+			var notes []string
+			for _, note := range d.Notes {
+				notes = append(notes, note.Message)
+			}
+			if note := strings.Join(notes, "; "); note != tt.note {
+				t.Errorf("expected note %q, got %q", tt.note, note)
+			}
+		})
+	}
+}
+
+func TestCheckTemplateInfo(t *testing.T) {
+	info, diags := checkSource(t, `package main;
+interface Named { func name(): i64; }
+func get<T>(x: T): i64 {
+  var n: Named = x;
+  var y: T = 1;
+  return n.name();
+}
+`)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected errors: %s", diags)
+	}
+
+	conversions := map[string]string{}
+	for e, typ := range info.Conversions {
+		conversions[info.Types[e].String()] = typ.String()
+	}
+	if conversions["T"] != "Named" {
+		t.Errorf("expected the conversion of T to Named to be recorded, got %v", conversions)
+	}
+
+	literals := map[string]bool{}
+	for _, typ := range info.Literals {
+		literals[typ.String()] = true
+	}
+	if !literals["T"] {
+		t.Errorf("expected a literal of type T, got %v", literals)
+	}
+}
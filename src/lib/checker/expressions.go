@@ -0,0 +1,1403 @@
+package checker
+
+import (
+	"go/constant"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// An operand is the result of checking an expression.
+type operand struct {
+	typ Type
+	// The checked expression, if the operand is a whole expression
+	expr *parser.Expression
+	// Literals whose type is decided by converting the operand, as long as
+	// it is untyped
+	lits []*parser.Value
+	// Value of constant operands
+	val constant.Value
+}
+
+var invalidOperand = operand{typ: Invalid}
+
+// expr checks e, leaving literals whose type depends on where the value is
+// used untyped.
+func (c *Checker) expr(e *parser.Expression, scope *Scope) operand {
+	return c.exprHint(e, scope, nil)
+}
+
+// exprHint checks e where a value of type hint is expected. The hint gives
+// array literals their element type, it is not required to match.
+func (c *Checker) exprHint(e *parser.Expression, scope *Scope, hint Type) operand {
+	x := c.ternary(e, scope, hint)
+	x.expr = e
+	c.Info.Types[e] = x.typ
+	return x
+}
+
+func (c *Checker) ternary(e *parser.Expression, scope *Scope, hint Type) operand {
+	if e.True == nil || e.False == nil {
+		return c.logicalOr(e.Condition, scope, hint)
+	}
+
+	cond := c.logicalOr(e.Condition, scope, nil)
+	if !isInvalid(cond.typ) && !isBool(cond.typ) {
+		c.typeError(e.Condition.Pos, "condition in ternary expression must be a boolean, got %s", cond.typ)
+	}
+	x := c.exprHint(e.True, scope, hint)
+	y := c.exprHint(e.False, scope, hint)
+	if !c.matchOperands(&x, &y) || !isInvalid(x.typ) && !isInvalid(y.typ) && !Identical(underlying(x.typ), underlying(y.typ)) && !c.assignable(y.typ, x.typ) {
+		c.typeError(e.Pos, "true and false expressions in ternary expression must be the same type (%s != %s)", x.typ, y.typ)
+		return invalidOperand
+	}
+	z := operand{typ: x.typ, lits: append(x.lits, y.lits...)}
+	if cond.val != nil && x.val != nil && y.val != nil {
+		z.val = y.val
+		if constant.BoolVal(cond.val) {
+			z.val = x.val
+		}
+	}
+	return z
+}
+
+// binary checks the operation x op y. Operators used on class instances call
+// the method overloading them.
+func (c *Checker) binary(pos lexer.Position, op string, x operand, y operand) operand {
+	if class, ok := classOf(x.typ); ok && !isInvalid(x.typ) {
+		if _, overloaded := c.lookupMember(class, "op."+op); !overloaded && (op == "==" || op == "!=") {
+			// Instances can always be compared by their address
+			if !c.convertUntyped(&y, x.typ) || !c.assignable(y.typ, x.typ) && !c.assignable(x.typ, y.typ) {
+				c.typeError(pos, "operands must be the same type (%s != %s)", x.typ, y.typ)
+			}
+			return operand{typ: Bool}
+		}
+		return operand{typ: c.operator(pos, class, op, &y)}
+	}
+
+	if !c.matchOperands(&x, &y) {
+		c.typeError(pos, "operands must be the same type (%s != %s)", x.typ, y.typ)
+		return invalidOperand
+	}
+	if isInvalid(x.typ) || isInvalid(y.typ) {
+		return invalidOperand
+	}
+	// The right operand is loaded if it points to a value of the type of
+	// the left one
+	if p, ok := y.typ.(*Pointer); ok && Identical(p.Elem, x.typ) {
+		y.typ = x.typ
+	}
+
+	var result Type = x.typ
+	switch op {
+	case "||", "or", "&&", "and":
+		name := "and"
+		if op == "||" || op == "or" {
+			name = "or"
+		}
+		if !isBool(x.typ) || !isBool(y.typ) {
+			c.typeError(pos, "logical %s operator requires boolean operands", name)
+			return invalidOperand
+		}
+	case "|", "^", "&":
+		name := map[string]string{"|": "or", "^": "xor", "&": "and"}[op]
+		if !isInteger(x.typ) || !isInteger(y.typ) {
+			c.typeError(pos, "bitwise %s operator requires integer operands", name)
+			return invalidOperand
+		}
+	case "<<", ">>", ">>>":
+		if !isInteger(x.typ) || !isInteger(y.typ) {
+			c.typeError(pos, "shift operator requires integer operands")
+			return invalidOperand
+		}
+	case "==", "!=":
+		result = Bool
+	case "<", "<=", ">", ">=":
+		if !isNumeric(x.typ) || !isNumeric(y.typ) {
+			c.typeError(pos, "relational operator requires numeric operands")
+			return invalidOperand
+		}
+		result = Bool
+	case "+", "-":
+		if !isNumeric(x.typ) || !isNumeric(y.typ) {
+			c.typeError(pos, "additive operator requires numeric operands")
+			return invalidOperand
+		}
+	default:
+		if !isNumeric(x.typ) || !isNumeric(y.typ) {
+			c.typeError(pos, "multiplicative operator requires numeric operands")
+			return invalidOperand
+		}
+	}
+	if !isUntyped(x.typ) && !Identical(underlying(x.typ), underlying(y.typ)) {
+		c.typeError(pos, "operands must be the same type (%s != %s)", x.typ, y.typ)
+		return invalidOperand
+	}
+
+	z := operand{typ: result, val: fold(op, x.val, y.val)}
+	if isUntyped(result) {
+		z.lits = append(x.lits, y.lits...)
+	}
+	return z
+}
+
+// fold computes the value of the constant operation x op y, or returns nil if
+// it can not be computed at compile time.
+func fold(op string, x, y constant.Value) constant.Value {
+	if x == nil || y == nil || x.Kind() == constant.Unknown || y.Kind() == constant.Unknown {
+		return nil
+	}
+	if x.Kind() == constant.String || y.Kind() == constant.String {
+		return nil
+	}
+	if x.Kind() == constant.Bool || y.Kind() == constant.Bool {
+		if x.Kind() != y.Kind() {
+			return nil
+		}
+		switch op {
+		case "||", "or":
+			return constant.BinaryOp(x, token.LOR, y)
+		case "&&", "and":
+			return constant.BinaryOp(x, token.LAND, y)
+		case "==":
+			return constant.MakeBool(constant.Compare(x, token.EQL, y))
+		case "!=":
+			return constant.MakeBool(constant.Compare(x, token.NEQ, y))
+		}
+		return nil
+	}
+
+	isInt := x.Kind() == constant.Int && y.Kind() == constant.Int
+	if !isInt {
+		x, y = constant.ToFloat(x), constant.ToFloat(y)
+	}
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		tok := map[string]token.Token{"==": token.EQL, "!=": token.NEQ, "<": token.LSS, "<=": token.LEQ, ">": token.GTR, ">=": token.GEQ}[op]
+		return constant.MakeBool(constant.Compare(x, tok, y))
+	case "+":
+		return constant.BinaryOp(x, token.ADD, y)
+	case "-":
+		return constant.BinaryOp(x, token.SUB, y)
+	case "*":
+		return constant.BinaryOp(x, token.MUL, y)
+	case "/":
+		if constant.Sign(y) == 0 {
+			return nil
+		}
+		if isInt {
+			return constant.BinaryOp(x, token.QUO_ASSIGN, y)
+		}
+		return constant.BinaryOp(x, token.QUO, y)
+	}
+	if !isInt {
+		return nil
+	}
+	switch op {
+	case "%":
+		if constant.Sign(y) == 0 {
+			return nil
+		}
+		return constant.BinaryOp(x, token.REM, y)
+	case "&":
+		return constant.BinaryOp(x, token.AND, y)
+	case "|":
+		return constant.BinaryOp(x, token.OR, y)
+	case "^":
+		return constant.BinaryOp(x, token.XOR, y)
+	case "<<", ">>":
+		s, ok := constant.Uint64Val(y)
+		if !ok || s > 1024 {
+			return nil
+		}
+		if op == "<<" {
+			return constant.Shift(x, token.SHL, uint(s))
+		}
+		return constant.Shift(x, token.SHR, uint(s))
+	}
+	return nil
+}
+
+func (c *Checker) logicalOr(l *parser.LogicalOr, scope *Scope, hint Type) operand {
+	if len(l.Right) == 0 {
+		return c.logicalAnd(l.Left, scope, hint)
+	}
+	x := c.logicalAnd(l.Left, scope, nil)
+	return c.binary(l.Right[0].Pos, l.Op, x, c.logicalOr(l.Right[0], scope, nil))
+}
+
+func (c *Checker) logicalAnd(l *parser.LogicalAnd, scope *Scope, hint Type) operand {
+	if len(l.Right) == 0 {
+		return c.bitwiseOr(l.Left, scope, hint)
+	}
+	x := c.bitwiseOr(l.Left, scope, nil)
+	return c.binary(l.Right[0].Pos, l.Op, x, c.logicalAnd(l.Right[0], scope, nil))
+}
+
+func (c *Checker) bitwiseOr(b *parser.BitwiseOr, scope *Scope, hint Type) operand {
+	if len(b.Right) == 0 {
+		return c.bitwiseXor(b.Left, scope, hint)
+	}
+	x := c.bitwiseXor(b.Left, scope, nil)
+	return c.binary(b.Right[0].Pos, b.Op, x, c.bitwiseOr(b.Right[0], scope, nil))
+}
+
+func (c *Checker) bitwiseXor(b *parser.BitwiseXor, scope *Scope, hint Type) operand {
+	if len(b.Right) == 0 {
+		return c.bitwiseAnd(b.Left, scope, hint)
+	}
+	x := c.bitwiseAnd(b.Left, scope, nil)
+	return c.binary(b.Right[0].Pos, b.Op, x, c.bitwiseXor(b.Right[0], scope, nil))
+}
+
+func (c *Checker) bitwiseAnd(b *parser.BitwiseAnd, scope *Scope, hint Type) operand {
+	if len(b.Right) == 0 {
+		return c.equality(b.Left, scope, hint)
+	}
+	x := c.equality(b.Left, scope, nil)
+	return c.binary(b.Right[0].Pos, b.Op, x, c.bitwiseAnd(b.Right[0], scope, nil))
+}
+
+func (c *Checker) equality(e *parser.Equality, scope *Scope, hint Type) operand {
+	if len(e.Right) == 0 {
+		return c.relational(e.Left, scope, hint)
+	}
+	x := c.relational(e.Left, scope, nil)
+	return c.binary(e.Right[0].Pos, e.Op, x, c.equality(e.Right[0], scope, nil))
+}
+
+func (c *Checker) relational(r *parser.Relational, scope *Scope, hint Type) operand {
+	if len(r.Right) == 0 {
+		return c.shift(r.Left, scope, hint)
+	}
+	x := c.shift(r.Left, scope, nil)
+	return c.binary(r.Right[0].Pos, r.Op, x, c.relational(r.Right[0], scope, nil))
+}
+
+func (c *Checker) shift(s *parser.Shift, scope *Scope, hint Type) operand {
+	if len(s.Right) == 0 {
+		return c.additive(s.Left, scope, hint)
+	}
+	x := c.additive(s.Left, scope, nil)
+	return c.binary(s.Right[0].Pos, s.Op, x, c.shift(s.Right[0], scope, nil))
+}
+
+func (c *Checker) additive(a *parser.Additive, scope *Scope, hint Type) operand {
+	if len(a.Right) == 0 {
+		return c.multiplicative(a.Left, scope, hint)
+	}
+	x := c.multiplicative(a.Left, scope, nil)
+	return c.binary(a.Right[0].Pos, a.Op, x, c.additive(a.Right[0], scope, nil))
+}
+
+func (c *Checker) multiplicative(m *parser.Multiplicative, scope *Scope, hint Type) operand {
+	if len(m.Right) == 0 {
+		return c.logicalNot(m.Left, scope, hint)
+	}
+	x := c.logicalNot(m.Left, scope, nil)
+	return c.binary(m.Right[0].Pos, m.Op, x, c.multiplicative(m.Right[0], scope, nil))
+}
+
+func (c *Checker) logicalNot(l *parser.LogicalNot, scope *Scope, hint Type) operand {
+	x := c.bitwiseNot(l.Right, scope, hint)
+	if l.Op == "" {
+		return x
+	}
+	if class, ok := classOf(x.typ); ok && !isInvalid(x.typ) {
+		return operand{typ: c.operator(l.Pos, class, l.Op)}
+	}
+	if isInvalid(x.typ) {
+		return invalidOperand
+	}
+	if !isBool(x.typ) {
+		c.typeError(l.Right.Pos, "logical not operator requires a boolean operand, got %s", x.typ)
+		return invalidOperand
+	}
+	if x.val != nil {
+		x.val = constant.UnaryOp(token.NOT, x.val, 0)
+	}
+	return x
+}
+
+func (c *Checker) bitwiseNot(b *parser.BitwiseNot, scope *Scope, hint Type) operand {
+	x := c.prefixAdditive(b.Right, scope, hint)
+	if b.Op == "" {
+		return x
+	}
+	if class, ok := classOf(x.typ); ok && !isInvalid(x.typ) {
+		return operand{typ: c.operator(b.Pos, class, b.Op)}
+	}
+	if isInvalid(x.typ) {
+		return invalidOperand
+	}
+	if !isInteger(x.typ) {
+		c.typeError(b.Right.Pos, "bitwise not operator requires an integer operand, got %s", x.typ)
+		return invalidOperand
+	}
+	if x.val != nil && isUntyped(x.typ) {
+		x.val = constant.UnaryOp(token.XOR, x.val, 0)
+	} else {
+		x.val = nil
+	}
+	return x
+}
+
+func (c *Checker) prefixAdditive(p *parser.PrefixAdditive, scope *Scope, hint Type) operand {
+	if p.Op != "" && p.Right.Op == "" {
+		return c.increment(p.Pos, p.Op, c.incrementTarget(p.Op, p.Right.Left, scope, hint))
+	}
+	x := c.postfixAdditive(p.Right, scope, hint)
+	if p.Op == "" {
+		return x
+	}
+	return c.increment(p.Pos, p.Op, x)
+}
+
+func (c *Checker) postfixAdditive(p *parser.PostfixAdditive, scope *Scope, hint Type) operand {
+	if p.Op == "" {
+		return c.factor(p.Left, scope, hint)
+	}
+	return c.increment(p.Pos, p.Op, c.incrementTarget(p.Op, p.Left, scope, hint))
+}
+
+// incrementTarget checks the operand f of op, ++ or --, which the new value
+// is stored back to. Properties are read through their getter and assigned
+// through their setter.
+func (c *Checker) incrementTarget(op string, f *parser.Factor, scope *Scope, hint Type) operand {
+	if f.Identifier == nil {
+		return c.factor(f, scope, hint)
+	}
+	if x, ok := c.enumConstant(f.Identifier, scope); ok {
+		if !isInvalid(x.typ) {
+			c.typeError(f.Pos, "Operator %s can not be used on an enum variant", op)
+		}
+		return invalidOperand
+	}
+	if op == "++" {
+		c.checkMutable(f.Identifier, scope, "increment")
+	} else {
+		c.checkMutable(f.Identifier, scope, "decrement")
+	}
+
+	typ, prop := c.target(f.Identifier, scope)
+	if prop != nil {
+		if prop.getter == nil {
+			c.typeError(f.Pos, "Property %s can not be read, it has no getter", prop.name)
+		}
+		return operand{typ: prop.typ}
+	}
+	return operand{typ: typ}
+}
+
+// increment checks the use of ++ or -- on x.
+func (c *Checker) increment(pos lexer.Position, op string, x operand) operand {
+	if class, ok := classOf(x.typ); ok && !isInvalid(x.typ) {
+		return operand{typ: c.operator(pos, class, op)}
+	}
+	if isInvalid(x.typ) {
+		return invalidOperand
+	}
+	c.convertUntyped(&x, Default(x.typ))
+	if !isNumeric(x.typ) {
+		c.typeError(pos, "Operator %s requires a numeric operand, got %s", op, x.typ)
+		return invalidOperand
+	}
+	x.val = nil
+	return x
+}
+
+// operator checks the use of op on an instance of class, which calls the
+// method overloading it with the operands args.
+func (c *Checker) operator(pos lexer.Position, class *Class, op string, args ...*operand) Type {
+	mem, ok := c.lookupMember(class, "op."+op)
+	if !ok || !mem.method {
+		c.errorf(diagnostics.CodeCompile, pos, "Class %s does not overload the %s operator", class, op)
+		return Invalid
+	}
+	c.checkAccess(pos, mem)
+	sig := mem.typ.(*Signature)
+	if len(sig.Params) != len(args) {
+		// Operators that can not be used at all are reported where they are
+		// declared
+		if validOperands(op, len(sig.Params)) {
+			c.errorf(diagnostics.CodeCompile, pos, "Operator %s of class %s must take %d arguments", op, class, len(args))
+		}
+		return sig.Result
+	}
+	for i, arg := range args {
+		if !c.convertUntyped(arg, sig.Params[i]) || !c.assignable(arg.typ, sig.Params[i]) {
+			c.typeError(pos, "Operand of operator %s must be of type %s, got %s", op, sig.Params[i], arg.typ)
+		}
+	}
+	return sig.Result
+}
+
+func (c *Checker) factor(f *parser.Factor, scope *Scope, hint Type) operand {
+	switch {
+	case f.Value != nil:
+		return c.value(f.Value, scope, hint)
+	case f.Identifier != nil:
+		if x, ok := c.enumConstant(f.Identifier, scope); ok {
+			return x
+		}
+		x := operand{typ: c.identifier(f.Identifier, scope)}
+		// Constants keep their value, so expressions of them are constant too
+		if i, obj := c.resolveModule(f.Identifier, scope); obj != nil && obj.Kind == ConstObject && i.Sub == nil && i.GEP == nil && i.Ref == "" && i.Deref == "" {
+			x.val = obj.Value
+		}
+		return x
+	case f.BitCast != nil:
+		return c.cast(f.BitCast, scope, hint)
+	case f.ClassMethod != nil:
+		return operand{typ: c.methodCall(f.ClassMethod, scope)}
+	case f.FunctionCall != nil:
+		return operand{typ: c.call(f.FunctionCall, scope)}
+	case f.ClassInitializer != nil:
+		return operand{typ: c.newInstance(f.ClassInitializer, scope)}
+	}
+	return invalidOperand
+}
+
+func (c *Checker) value(v *parser.Value, scope *Scope, hint Type) operand {
+	switch {
+	case v.Float != nil:
+		return operand{typ: UntypedFloat, lits: []*parser.Value{v}, val: constant.MakeFloat64(*v.Float)}
+	case v.Int != nil:
+		return operand{typ: UntypedInt, lits: []*parser.Value{v}, val: constant.MakeInt64(*v.Int)}
+	case v.Bool != nil:
+		return operand{typ: Bool, val: constant.MakeBool(bool(*v.Bool))}
+	case v.String != nil:
+		str, err := strconv.Unquote(*v.String)
+		if err != nil {
+			c.errorf(diagnostics.CodeCompile, v.Pos, "Error parsing string: %s", err)
+			return invalidOperand
+		}
+		c.Info.Literals[v] = String
+		return operand{typ: String, val: constant.MakeString(str)}
+	case v.Null:
+		return operand{typ: UntypedNull, lits: []*parser.Value{v}}
+	case v.IsArray:
+		return c.array(v, scope, hint)
+	}
+	return invalidOperand
+}
+
+// array checks an array literal. Its element type is the one of the array or
+// pointer expected, or the type of its first element.
+func (c *Checker) array(v *parser.Value, scope *Scope, hint Type) operand {
+	var elem Type
+	mismatch := false
+	switch t := hint.(type) {
+	case *Array:
+		if t.Len >= 0 && t.Len != int64(len(v.Array)) {
+			c.typeError(v.Pos, "Array literal has %d elements, but %d were expected", len(v.Array), t.Len)
+			mismatch = true
+		}
+		elem = t.Elem
+	case *Pointer:
+		elem = t.Elem
+	}
+
+	for i, e := range v.Array {
+		x := c.exprHint(e, scope, elem)
+		if elem == nil {
+			c.convertUntyped(&x, Default(x.typ))
+			elem = x.typ
+			continue
+		}
+		c.assign(&x, e, elem, "array element "+strconv.Itoa(i+1))
+	}
+	if elem == nil {
+		c.typeError(v.Pos, "Unable to infer the type of an empty array literal")
+		return invalidOperand
+	}
+	if mismatch {
+		// The length was already reported, the assignment must not be
+		return invalidOperand
+	}
+	typ := &Array{Len: int64(len(v.Array)), Elem: elem}
+	c.Info.Literals[v] = typ
+	return operand{typ: typ}
+}
+
+// cast checks an expression in parentheses, which is converted to a type if
+// one is given. Conversions are checked when they are compiled.
+func (c *Checker) cast(bc *parser.BitCast, scope *Scope, hint Type) operand {
+	if bc.Type == nil {
+		x := c.exprHint(bc.Expr, scope, hint)
+		x.expr = nil
+		return x
+	}
+	x := c.expr(bc.Expr, scope)
+	c.convertUntyped(&x, Default(x.typ))
+	target := c.resolveType(bc.Type, scope, true)
+	if _, ok := target.(*Void); ok {
+		c.typeError(bc.Type.Pos, "Cannot convert %s to void", x.typ)
+		return invalidOperand
+	}
+	if _, ok := x.typ.(*Void); ok {
+		c.typeError(bc.Expr.Pos, "Cannot convert a value of type void to %s", target)
+		return invalidOperand
+	}
+	if _, ok := x.typ.(*Tuple); ok {
+		c.typeError(bc.Expr.Pos, "Cannot convert a value of type %s to %s", x.typ, target)
+		return invalidOperand
+	}
+	return operand{typ: target}
+}
+
+// enumConstant checks an identifier naming a variant of an enum, like
+// Color.Red, which is a constant unless the variant carries values.
+func (c *Checker) enumConstant(ident *parser.Identifier, scope *Scope) (operand, bool) {
+	i, obj := c.resolveModule(ident, scope)
+	if i.Sub == nil || i.Sub.Sub != nil || i.GEP != nil || i.Sub.GEP != nil || i.Ref != "" || i.Deref != "" {
+		return operand{}, false
+	}
+	if obj == nil || obj.Kind != TypeObject {
+		return operand{}, false
+	}
+	enum, ok := obj.Type.(*Enum)
+	if !ok {
+		return operand{}, false
+	}
+	c.Info.Uses[ident] = obj
+
+	index := enum.variant(i.Sub.Name)
+	if index < 0 {
+		c.undefined(i.Sub.Pos, "Variant %s not found in enum %s", i.Sub.Name, enum.Name()).Suggest(i.Sub.Name, enum.variantNames())
+		return invalidOperand, true
+	}
+	if !enum.Tagged() {
+		return operand{typ: enum, val: constant.MakeInt64(int64(index))}, true
+	}
+	if payload := c.payload(enum, index); len(payload) > 0 {
+		c.errorf(diagnostics.CodeCompile, i.Pos, "Variant %s of enum %s expects %d values", i.Sub.Name, enum.Name(), len(payload))
+	}
+	return operand{typ: enum}, true
+}
+
+// resolveModule resolves the module alias i may start with. It returns the
+// identifier without the alias and the object its name refers to.
+func (c *Checker) resolveModule(i *parser.Identifier, scope *Scope) (*parser.Identifier, *Object) {
+	obj := scope.lookup(i.Name)
+	if obj == nil || obj.Kind != ModuleObject || i.Sub == nil || i.GEP != nil {
+		return i, obj
+	}
+	resolved := *i.Sub
+	resolved.Name = i.Name + "." + i.Sub.Name
+	resolved.Ref = i.Ref
+	resolved.Deref = i.Deref
+	return &resolved, obj.module.objects[i.Sub.Name]
+}
+
+// variable resolves the variable an identifier starts with, which may be a
+// static field of a class. It returns the identifier following the variable
+// and the type of the variable.
+func (c *Checker) variable(ident *parser.Identifier, scope *Scope) (*parser.Identifier, Type, bool) {
+	i, obj := c.resolveModule(ident, scope)
+	if obj != nil && obj.Kind == TypeObject && i.Sub != nil && i.GEP == nil {
+		if class, ok := obj.Type.(*Class); ok {
+			mem, ok := c.lookupMember(class, i.Sub.Name)
+			if !ok || mem.method || !mem.static {
+				c.undefined(i.Sub.Pos, "Static field %s not found in class %s", i.Sub.Name, class).Suggest(i.Sub.Name, c.staticFieldNames(class))
+				return nil, Invalid, false
+			}
+			c.Info.Uses[ident] = obj
+			c.checkAccess(i.Sub.Pos, mem)
+			rest := *i.Sub
+			rest.Ref = i.Ref
+			rest.Deref = i.Deref
+			return &rest, mem.typ, true
+		}
+	}
+	if obj == nil || (obj.Kind != VarObject && obj.Kind != ConstObject) {
+		c.undefined(i.Pos, "Variable %s not found", i.Name).Suggest(i.Name, scope.names(VarObject, ConstObject))
+		return nil, Invalid, false
+	}
+	c.Info.Uses[ident] = obj
+	return i, obj.Type, true
+}
+
+func (c *Checker) staticFieldNames(class *Class) []string {
+	var names []string
+	for name, mem := range c.members(class).members {
+		if mem.static && !mem.method {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// identifier checks an identifier used as a value, like a variable, one of its
+// fields or an element of an array.
+func (c *Checker) identifier(ident *parser.Identifier, scope *Scope) Type {
+	i, typ, ok := c.variable(ident, scope)
+	if !ok {
+		return Invalid
+	}
+	if i.GEP != nil {
+		typ = c.index(typ, i.GEP, scope)
+	}
+	prev := i.Name
+	for sub := i.Sub; sub != nil; sub = sub.Sub {
+		typ = c.field(typ, sub, prev)
+		if sub.GEP != nil {
+			typ = c.index(typ, sub.GEP, scope)
+		}
+		prev = sub.Name
+	}
+	return c.reference(i.Pos, typ, i.Ref, i.Deref)
+}
+
+// reference applies the & and * operators an identifier is written with.
+func (c *Checker) reference(pos lexer.Position, typ Type, ref, deref string) Type {
+	for range ref {
+		typ = &Pointer{Elem: typ}
+	}
+	for range deref {
+		if isInvalid(typ) {
+			return Invalid
+		}
+		p, ok := typ.(*Pointer)
+		if !ok {
+			c.typeError(pos, "Cannot dereference a value of type %s", typ)
+			return Invalid
+		}
+		typ = p.Elem
+	}
+	return typ
+}
+
+// field returns the type of the field or property sub of the instance of type
+// typ, reached through the field or variable prev.
+func (c *Checker) field(typ Type, sub *parser.Identifier, prev string) Type {
+	if isInvalid(typ) {
+		return Invalid
+	}
+	class, ok := classOf(typ)
+	if !ok {
+		c.typeError(sub.Pos, "Cannot access field %s of non-struct type %s", sub.Name, typ)
+		return Invalid
+	}
+	members := c.members(class)
+	if mem, ok := members.members[sub.Name]; ok && mem.method {
+		c.typeError(sub.Pos, "Cannot call method %s on %s", sub.Name, class)
+		return Invalid
+	}
+	// Methods of the class reach the field behind a property through this
+	if getter, ok := members.members["get."+sub.Name]; ok && getter.method && prev != "this" {
+		c.checkAccess(sub.Pos, getter)
+		sig := getter.typ.(*Signature)
+		if len(sig.Params) != 0 {
+			c.errorf(diagnostics.CodeCompile, sub.Pos, "Getter of property %s must not take any arguments", sub.Name)
+		}
+		return sig.Result
+	}
+	mem, ok := members.members[sub.Name]
+	if !ok || mem.static {
+		c.undefined(sub.Pos, "Field %s not found in struct %s", sub.Name, class).Suggest(sub.Name, c.fieldNames(class))
+		return Invalid
+	}
+	c.checkAccess(sub.Pos, mem)
+	return mem.typ
+}
+
+// index returns the type of the elements of the array or pointer of type typ
+// indexed with e.
+func (c *Checker) index(typ Type, e *parser.Expression, scope *Scope) Type {
+	x := c.exprHint(e, scope, I32)
+	c.convertUntyped(&x, I32)
+	if !isInvalid(x.typ) && !isInteger(x.typ) {
+		c.typeError(e.Pos, "Index must be an integer, got %s", x.typ)
+	}
+	if isInvalid(typ) {
+		return Invalid
+	}
+	switch t := typ.(type) {
+	case *Array:
+		if x.val != nil && x.val.Kind() == constant.Int && t.Len >= 0 {
+			if n, ok := constant.Int64Val(x.val); !ok || n < 0 || n >= t.Len {
+				c.errorf(diagnostics.CodeCompile, e.Pos, "Index %s is out of bounds for an array of length %d", x.val, t.Len)
+			}
+		}
+		return t.Elem
+	case *Pointer:
+		return t.Elem
+	}
+	c.typeError(e.Pos, "Cannot index a value of type %s", typ)
+	return Invalid
+}
+
+// property is a property of a class assigned through its setter.
+type property struct {
+	name   string
+	typ    Type
+	getter *member
+}
+
+// target checks the target of an assignment. It returns the type of the value
+// stored, or the property assigned through a setter.
+func (c *Checker) target(ident *parser.Identifier, scope *Scope) (Type, *property) {
+	i, _ := c.resolveModule(ident, scope)
+	if i.Sub == nil || i.Ref != "" || i.Deref != "" || (i.Name == "this" && i.Sub.Sub == nil) {
+		return c.identifier(ident, scope), nil
+	}
+
+	// Split the identifier into the instance and the name assigned to
+	instance := *ident
+	current := &instance
+	for current.Sub.Sub != nil {
+		sub := *current.Sub
+		current.Sub = &sub
+		current = current.Sub
+	}
+	last := current.Sub
+	current.Sub = nil
+	if last.GEP != nil {
+		return c.identifier(ident, scope), nil
+	}
+
+	// A static field is a variable of its own
+	if obj := scope.lookup(instance.Name); obj != nil && obj.Kind == TypeObject && instance.Sub == nil && instance.GEP == nil {
+		if _, ok := obj.Type.(*Class); ok {
+			return c.identifier(ident, scope), nil
+		}
+	}
+
+	typ := c.identifier(&instance, scope)
+	c.Info.Uses[ident] = c.Info.Uses[&instance]
+	if isInvalid(typ) {
+		return Invalid, nil
+	}
+	if class, ok := classOf(typ); ok {
+		members := c.members(class)
+		setter, hasSetter := members.members["set."+last.Name]
+		getter, hasGetter := members.members["get."+last.Name]
+		if hasSetter {
+			c.checkAccess(last.Pos, setter)
+			sig := setter.typ.(*Signature)
+			p := &property{name: last.Name, typ: Invalid}
+			if len(sig.Params) != 1 {
+				c.errorf(diagnostics.CodeCompile, last.Pos, "Setter of property %s must take a single argument", last.Name)
+			} else {
+				p.typ = sig.Params[0]
+			}
+			if hasGetter {
+				p.getter = getter
+			}
+			return nil, p
+		} else if hasGetter {
+			c.errorf(diagnostics.CodeCompile, last.Pos, "Property %s of class %s is read-only", last.Name, class)
+			return Invalid, nil
+		}
+		if mem, ok := members.members[last.Name]; ok && mem.method {
+			c.typeError(last.Pos, "Cannot assign to method %s", last.Name)
+			return Invalid, nil
+		}
+	}
+	return c.field(typ, last, current.Name), nil
+}
+
+// checkAccess makes sure mem is accessible from the function being checked.
+// Private members can only be accessed by the methods of the class declaring
+// them.
+func (c *Checker) checkAccess(pos lexer.Position, mem *member) {
+	if !mem.private {
+		return
+	}
+	if c.fn != nil && c.fn.class != nil && c.fn.class.decl == mem.owner.decl {
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(mem.name, "get."), "set.")
+	c.errorf(diagnostics.CodeCompile, pos, "Cannot access private member %s of class %s", name, mem.owner)
+}
+
+// call checks a call of a function.
+func (c *Checker) call(fc *parser.FunctionCall, scope *Scope) Type {
+	name := strings.Trim(fc.FunctionName, "\"")
+	obj := scope.lookupKind(name, FuncObject)
+	if obj == nil {
+		c.undefined(fc.Pos, "Function %s not found", name).Suggest(name, scope.names(FuncObject))
+		c.arguments(fc.Args.Arguments, scope)
+		return Invalid
+	}
+	return c.callObject(fc.Pos, name, obj, fc.TypeArgs, &fc.Args, scope)
+}
+
+// callObject checks a call of the function obj, which is called name.
+func (c *Checker) callObject(pos lexer.Position, name string, obj *Object, typeArgs []*parser.Type, args *parser.ArgumentList, scope *Scope) Type {
+	if obj.generic != nil {
+		return c.genericCall(pos, name, obj, typeArgs, args, scope)
+	}
+	if len(typeArgs) > 0 {
+		c.errorf(diagnostics.CodeCompile, pos, "Function %s is not generic", name)
+	}
+	sig := obj.Type.(*Signature)
+	c.callArgs(pos, "Function "+name, sig, args, scope)
+	return sig.Result
+}
+
+// genericCall checks a call of a generic function, inferring its type
+// arguments from the arguments of the call if they are not given.
+func (c *Checker) genericCall(pos lexer.Position, name string, obj *Object, typeArgs []*parser.Type, args *parser.ArgumentList, scope *Scope) Type {
+	tmpl := obj.generic
+	var targs []Type
+	if len(typeArgs) > 0 {
+		if len(typeArgs) != len(tmpl.TypeParams) {
+			c.errorf(diagnostics.CodeCompile, pos, "Function %s expects %d type arguments, got %d", name, len(tmpl.TypeParams), len(typeArgs))
+			c.arguments(args.Arguments, scope)
+			return Invalid
+		}
+		for _, t := range typeArgs {
+			targs = append(targs, c.resolveType(t, scope, true))
+		}
+	} else {
+		// Arguments are typed on their own, like they are when compiled
+		operands := make([]operand, len(args.Arguments))
+		for i, arg := range args.Arguments {
+			operands[i] = c.expr(arg, scope)
+			c.convertUntyped(&operands[i], Default(operands[i].typ))
+		}
+		bound := make(map[string]Type)
+		for i, param := range tmpl.Parameters {
+			if i < len(operands) {
+				c.bindTypeParams(param.Type, operands[i].typ, tmpl.TypeParams, bound)
+			}
+		}
+		for _, param := range tmpl.TypeParams {
+			typ, ok := bound[param]
+			if !ok {
+				c.typeError(pos, "Unable to infer type parameter %s of function %s", param, name)
+				return Invalid
+			}
+			targs = append(targs, typ)
+		}
+		sig := c.signature(tmpl.Parameters, tmpl.Variadic != "", tmpl.ReturnType, typeParamScope(obj.scope, tmpl.TypeParams, targs), false)
+		if c.argCount(pos, "Function "+name, sig, len(operands)) {
+			for i := range sig.Params {
+				c.assign(&operands[i], args.Arguments[i], sig.Params[i], "argument "+strconv.Itoa(i+1)+" of "+name)
+			}
+		}
+		return sig.Result
+	}
+
+	sig := c.signature(tmpl.Parameters, tmpl.Variadic != "", tmpl.ReturnType, typeParamScope(obj.scope, tmpl.TypeParams, targs), false)
+	c.callArgs(pos, "Function "+name, sig, args, scope)
+	return sig.Result
+}
+
+// bindTypeParams matches the parameter type t against typ, recording the type
+// bound to every type parameter t refers to.
+func (c *Checker) bindTypeParams(t *parser.Type, typ Type, params []string, bound map[string]Type) {
+	if isInvalid(typ) {
+		return
+	}
+	if t.Array != nil {
+		a, ok := typ.(*Array)
+		if !ok {
+			return
+		}
+		typ = a.Elem
+	}
+	for i := 0; i < strings.Count(t.Ptr, "*"); i++ {
+		p, ok := typ.(*Pointer)
+		if !ok {
+			return
+		}
+		typ = p.Elem
+	}
+	if t.Inner != nil {
+		c.bindTypeParams(t.Inner, typ, params, bound)
+		return
+	}
+	if len(t.TypeArgs) > 0 {
+		class, ok := typ.(*Class)
+		if !ok || class.decl.name != t.Name {
+			return
+		}
+		for i, arg := range t.TypeArgs {
+			if i < len(class.Args) {
+				c.bindTypeParams(arg, class.Args[i], params, bound)
+			}
+		}
+		return
+	}
+	for _, param := range params {
+		if param == t.Name {
+			if _, ok := bound[param]; !ok {
+				bound[param] = typ
+			}
+			return
+		}
+	}
+}
+
+// arguments checks expressions passed to something that could not be
+// resolved, so names they use are still resolved.
+func (c *Checker) arguments(args []*parser.Expression, scope *Scope) {
+	for _, arg := range args {
+		c.expr(arg, scope)
+	}
+}
+
+// argCount checks the number of arguments passed to what.
+func (c *Checker) argCount(pos lexer.Position, what string, sig *Signature, n int) bool {
+	if sig.Variadic && n < len(sig.Params) {
+		c.typeError(pos, "%s expects at least %d arguments, got %d", what, len(sig.Params), n)
+		return false
+	}
+	if !sig.Variadic && n != len(sig.Params) {
+		c.typeError(pos, "%s expects %d arguments, got %d", what, len(sig.Params), n)
+		return false
+	}
+	return true
+}
+
+// callArgs checks the arguments of a call of what, whose signature is sig.
+// Extra arguments of variadic functions can be of any type.
+func (c *Checker) callArgs(pos lexer.Position, what string, sig *Signature, args *parser.ArgumentList, scope *Scope) {
+	ok := c.argCount(pos, what, sig, len(args.Arguments))
+	for i, arg := range args.Arguments {
+		if i >= len(sig.Params) {
+			x := c.expr(arg, scope)
+			c.convertUntyped(&x, Default(x.typ))
+			continue
+		}
+		x := c.exprHint(arg, scope, sig.Params[i])
+		if ok {
+			c.assign(&x, arg, sig.Params[i], "argument "+strconv.Itoa(i+1)+" of "+strings.ToLower(what[:1])+what[1:])
+		}
+	}
+}
+
+// methodCall checks a call of a method, or of a function qualified with the
+// alias of the module it is imported from.
+func (c *Checker) methodCall(cm *parser.ClassMethod, scope *Scope) Type {
+	ident, obj := c.resolveModule(cm.Identifier, scope)
+	if ident.Sub == nil {
+		if obj != nil && (obj.Kind == VarObject || obj.Kind == ConstObject) {
+			c.typeError(cm.Pos, "%s is not a function", ident.Name)
+			c.arguments(cm.Args.Arguments, scope)
+			return Invalid
+		}
+		if obj == nil || obj.Kind != FuncObject {
+			c.undefined(cm.Pos, "Function %s not found", ident.Name).Suggest(ident.Name, scope.names(FuncObject))
+			c.arguments(cm.Args.Arguments, scope)
+			return Invalid
+		}
+		c.Info.Uses[cm.Identifier] = obj
+		return c.callObject(cm.Pos, ident.Name, obj, cm.TypeArgs, cm.Args, scope)
+	}
+
+	// Split the identifier into the instance and the method name
+	instance := *ident
+	current := &instance
+	for current.Sub.Sub != nil {
+		sub := *current.Sub
+		current.Sub = &sub
+		current = current.Sub
+	}
+	method := current.Sub.Name
+	current.Sub = nil
+	if len(cm.TypeArgs) > 0 {
+		c.errorf(diagnostics.CodeCompile, cm.Pos, "Method %s is not generic", method)
+	}
+
+	if instance.Sub == nil && instance.GEP == nil {
+		if instance.Name == "super" && obj == nil {
+			return c.superCall(cm, method, scope)
+		}
+		if obj != nil && obj.Kind == TypeObject {
+			c.Info.Uses[cm.Identifier] = obj
+			switch t := obj.Type.(type) {
+			case *Enum:
+				return c.variantCall(cm, t, method, scope)
+			case *Class:
+				return c.staticCall(cm, t, method, scope)
+			}
+		}
+	}
+
+	typ := c.identifier(&instance, scope)
+	c.Info.Uses[cm.Identifier] = c.Info.Uses[&instance]
+	if isInvalid(typ) {
+		c.arguments(cm.Args.Arguments, scope)
+		return Invalid
+	}
+
+	iface, ok := typ.(*Interface)
+	if p, isPtr := typ.(*Pointer); isPtr {
+		iface, ok = p.Elem.(*Interface)
+	}
+	if ok {
+		sig, found := c.interfaceMethods(iface)[method]
+		if !found {
+			var names []string
+			for _, m := range iface.decl.def.Methods {
+				names = append(names, m.Name)
+			}
+			c.undefined(methodPos(cm), "Method %s not found on interface %s", method, iface).Suggest(method, names)
+			c.arguments(cm.Args.Arguments, scope)
+			return Invalid
+		}
+		c.callArgs(cm.Pos, "Method "+method+" of interface "+iface.Name(), sig, cm.Args, scope)
+		return sig.Result
+	}
+
+	class, ok := classOf(typ)
+	if !ok {
+		c.typeError(methodPos(cm), "Cannot call method %s on a value of type %s", method, typ)
+		c.arguments(cm.Args.Arguments, scope)
+		return Invalid
+	}
+	mem, ok := c.lookupMember(class, method)
+	if !ok || !mem.method {
+		c.undefined(methodPos(cm), "Method %s not found on type %s", method, class).Suggest(method, c.methodNames(class))
+		c.arguments(cm.Args.Arguments, scope)
+		return Invalid
+	}
+	c.checkAccess(methodPos(cm), mem)
+	if mem.static {
+		c.errorf(diagnostics.CodeCompile, methodPos(cm), "Static method %s must be called on class %s, not on an instance", method, class)
+	}
+	sig := mem.typ.(*Signature)
+	c.callArgs(cm.Pos, "Method "+method+" of class "+class.String(), sig, cm.Args, scope)
+	return sig.Result
+}
+
+// methodPos returns the position of the name of the method cm calls, which
+// errors about the method point at.
+func methodPos(cm *parser.ClassMethod) lexer.Position {
+	i := cm.Identifier
+	for i.Sub != nil {
+		i = i.Sub
+	}
+	return i.Pos
+}
+
+// superCall checks a call of the implementation of a method in the parent
+// class of the class whose method is being checked.
+func (c *Checker) superCall(cm *parser.ClassMethod, method string, scope *Scope) Type {
+	if c.fn == nil || c.fn.class == nil {
+		c.errorf(diagnostics.CodeCompile, cm.Pos, "super can only be used inside of methods")
+		c.arguments(cm.Args.Arguments, scope)
+		return Invalid
+	}
+	parent := c.members(c.fn.class).parent
+	if parent == nil {
+		c.errorf(diagnostics.CodeCompile, cm.Pos, "Class %s does not have a parent class", c.fn.class)
+		c.arguments(cm.Args.Arguments, scope)
+		return Invalid
+	}
+	mem, ok := c.lookupMember(parent, method)
+	if !ok || !mem.method {
+		c.undefined(methodPos(cm), "Method %s not found on type %s", method, parent).Suggest(method, c.methodNames(parent))
+		c.arguments(cm.Args.Arguments, scope)
+		return Invalid
+	}
+	c.checkAccess(methodPos(cm), mem)
+	sig := mem.typ.(*Signature)
+	c.callArgs(cm.Pos, "Method "+method+" of class "+parent.String(), sig, cm.Args, scope)
+	return sig.Result
+}
+
+// staticCall checks a call of a static method of class.
+func (c *Checker) staticCall(cm *parser.ClassMethod, class *Class, method string, scope *Scope) Type {
+	mem, ok := c.lookupMember(class, method)
+	if !ok || !mem.method {
+		c.undefined(methodPos(cm), "Method %s not found on class %s", method, class).Suggest(method, c.methodNames(class))
+		c.arguments(cm.Args.Arguments, scope)
+		return Invalid
+	}
+	if !mem.static {
+		c.errorf(diagnostics.CodeCompile, methodPos(cm), "Method %s of class %s is not static, it must be called on an instance", method, class)
+	}
+	c.checkAccess(methodPos(cm), mem)
+	sig := mem.typ.(*Signature)
+	c.callArgs(cm.Pos, "Method "+method+" of class "+class.String(), sig, cm.Args, scope)
+	return sig.Result
+}
+
+// variantCall checks the creation of a value of a variant carrying values.
+func (c *Checker) variantCall(cm *parser.ClassMethod, enum *Enum, variant string, scope *Scope) Type {
+	index := enum.variant(variant)
+	if index < 0 {
+		c.undefined(methodPos(cm), "Variant %s not found in enum %s", variant, enum.Name()).Suggest(variant, enum.variantNames())
+		c.arguments(cm.Args.Arguments, scope)
+		return Invalid
+	}
+	payload := c.payload(enum, index)
+	if len(payload) != len(cm.Args.Arguments) {
+		c.errorf(diagnostics.CodeCompile, cm.Pos, "Variant %s of enum %s expects %d values, got %d", variant, enum.Name(), len(payload), len(cm.Args.Arguments))
+		c.arguments(cm.Args.Arguments, scope)
+		return enum
+	}
+	for i, arg := range cm.Args.Arguments {
+		x := c.exprHint(arg, scope, payload[i])
+		c.assign(&x, arg, payload[i], "value "+strconv.Itoa(i+1)+" of variant "+variant)
+	}
+	return enum
+}
+
+// newInstance checks the creation of an instance of a class with new, which
+// results in a pointer to the instance.
+func (c *Checker) newInstance(ci *parser.ClassInitializer, scope *Scope) Type {
+	obj := lookupQualified(ci.ClassName, scope)
+	class, ok := (*Class)(nil), false
+	if obj != nil && obj.Kind == TypeObject {
+		class, ok = obj.Type.(*Class)
+	}
+	if !ok {
+		c.undefined(ci.Pos, "Class %s not found", ci.ClassName).Suggest(ci.ClassName, c.classNames(scope))
+		c.arguments(ci.Args.Arguments, scope)
+		return Invalid
+	}
+
+	params := len(class.decl.def.TypeParams)
+	if class.Args != nil {
+		// A type parameter of a generic class
+		params = 0
+	}
+	switch {
+	case params == 0 && len(ci.TypeArgs) > 0:
+		c.typeError(ci.Pos, "Class %s is not generic", ci.ClassName)
+		c.arguments(ci.Args.Arguments, scope)
+		return Invalid
+	case len(ci.TypeArgs) != params:
+		c.typeError(ci.Pos, "Class %s expects %d type arguments, got %d", ci.ClassName, params, len(ci.TypeArgs))
+		c.arguments(ci.Args.Arguments, scope)
+		return Invalid
+	case params > 0:
+		args := make([]Type, len(ci.TypeArgs))
+		for i, arg := range ci.TypeArgs {
+			args[i] = c.resolveType(arg, scope, true)
+		}
+		class = &Class{decl: class.decl, Args: args}
+	}
+
+	constructor, ok := c.lookupMember(class, "constructor")
+	if !ok || !constructor.method {
+		if len(ci.Args.Arguments) > 0 {
+			c.typeError(ci.Pos, "Class %s does not have a constructor, it can not be created with arguments", class)
+			c.arguments(ci.Args.Arguments, scope)
+		}
+		return &Pointer{Elem: class}
+	}
+	c.checkAccess(ci.Pos, constructor)
+	sig := constructor.typ.(*Signature)
+	if len(ci.Args.Arguments) != len(sig.Params) && !sig.Variadic {
+		c.errorf(diagnostics.CodeCompile, ci.Pos, "Invalid number of arguments for class constructor, %s expects %d, got %d", class, len(sig.Params), len(ci.Args.Arguments))
+		c.arguments(ci.Args.Arguments, scope)
+		return &Pointer{Elem: class}
+	}
+	c.callArgs(ci.Pos, "Constructor of class "+class.String(), sig, &ci.Args, scope)
+	return &Pointer{Elem: class}
+}
+
+// classNames returns the names of the classes visible from scope.
+func (c *Checker) classNames(scope *Scope) []string {
+	var names []string
+	for _, name := range scope.names(TypeObject) {
+		if _, ok := scope.lookup(name).Type.(*Class); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// assign checks that x can be used as a value of type target, converting it
+// if it is untyped. Implicit conversions of typed values are recorded.
+func (c *Checker) assign(x *operand, e *parser.Expression, target Type, context string) bool {
+	if isUntyped(x.typ) {
+		if !c.convertUntyped(x, target) {
+			c.typeError(e.Pos, "Cannot use %s as %s in %s", x.typ, target, context)
+			return false
+		}
+		return true
+	}
+	if !c.assignable(x.typ, target) {
+		class, isClass := classOf(x.typ)
+		if iface, ok := target.(*Interface); ok && isClass {
+			c.typeError(e.Pos, "Class %s does not implement interface %s, %s", class, iface, c.missingMethod(class, iface))
+			return false
+		}
+		c.typeError(e.Pos, "Cannot use a value of type %s as %s in %s", x.typ, target, context)
+		return false
+	}
+	// Conversions to and from type parameters are recorded too, they are
+	// applied once the template is compiled for its type arguments
+	if !isUnchecked(x.typ) && !isUnchecked(target) && !Identical(x.typ, target) {
+		c.Info.Conversions[e] = target
+	}
+	return true
+}
+
+// assignable reports whether a value of type from can be used as a value of
+// type to, possibly after an implicit conversion.
+func (c *Checker) assignable(from, to Type) bool {
+	if isInvalid(from) || isInvalid(to) || Identical(from, to) {
+		return true
+	}
+	// Plain enums are integers, but integers are not enums
+	if _, ok := to.(*Enum); !ok && Identical(underlying(from), underlying(to)) {
+		return true
+	}
+
+	// Instances of classes are used through pointers, so variables holding
+	// an instance and pointers to one are interchangeable
+	if class, ok := classOf(from); ok {
+		switch t := to.(type) {
+		case *Class:
+			return Identical(class, t)
+		case *Pointer:
+			base, ok := t.Elem.(*Class)
+			return ok && c.isSubclass(class, base)
+		case *Interface:
+			return c.missingMethod(class, t) == ""
+		}
+		return false
+	}
+	if i, ok := from.(*Interface); ok {
+		p, ok := to.(*Pointer)
+		return ok && Identical(i, p.Elem)
+	}
+	if p, ok := from.(*Pointer); ok {
+		if i, ok := p.Elem.(*Interface); ok {
+			return Identical(i, to)
+		}
+		if e, ok := p.Elem.(*Enum); ok && e.Tagged() {
+			return Identical(e, to)
+		}
+	}
+
+	// Arrays decay to a pointer to their first element
+	if a, ok := from.(*Array); ok {
+		p, ok := to.(*Pointer)
+		return ok && Identical(a.Elem, p.Elem)
+	}
+	return false
+}
+
+// convertUntyped gives the untyped operand x the type target. It reports
+// whether x can be represented as a value of type target, which it always can
+// if x is already typed.
+func (c *Checker) convertUntyped(x *operand, target Type) bool {
+	u, ok := x.typ.(*Untyped)
+	if !ok {
+		return true
+	}
+	if isUnchecked(target) {
+		return true
+	}
+
+	switch t := target.(type) {
+	case *Untyped:
+		if t.kind == u.kind {
+			return true
+		}
+		if u.kind == untypedNull || t.kind == untypedNull {
+			return false
+		}
+		// A mix of integers and floats is a float
+		if t.kind == untypedFloat {
+			x.typ = target
+			if x.val != nil {
+				x.val = constant.ToFloat(x.val)
+			}
+		}
+		return true
+	case *Int:
+		switch u.kind {
+		case untypedInt:
+			if x.val != nil && !fits(x.val, t) {
+				c.typeError(x.pos(), "Constant %s overflows %s", x.val, t)
+			}
+		case untypedFloat:
+			if t.Unsigned || (t.Bits != 1 && t.Bits != 32 && t.Bits != 64) {
+				return false
+			}
+			if x.val != nil {
+				if i := constant.ToInt(x.val); i.Kind() == constant.Int {
+					x.val = i
+				} else {
+					c.typeError(x.pos(), "Constant %s is truncated to %s", x.val, t)
+					x.val = nil
+				}
+			}
+		default:
+			return false
+		}
+	case *Float:
+		if u.kind == untypedNull {
+			return false
+		}
+		if x.val != nil {
+			x.val = constant.ToFloat(x.val)
+		}
+	case *Enum:
+		// Integers are not enums, only the variants of an enum are
+		return false
+	case *TypeParam:
+		// Literals get the type the template is compiled for
+	case *Pointer:
+		if u.kind != untypedNull {
+			return false
+		}
+	default:
+		return false
+	}
+
+	x.typ = target
+	for _, lit := range x.lits {
+		c.Info.Literals[lit] = target
+	}
+	x.lits = nil
+	if x.expr != nil {
+		c.Info.Types[x.expr] = target
+	}
+	return true
+}
+
+// pos returns the position of the operand in the source code, for errors.
+func (x *operand) pos() lexer.Position {
+	if x.expr != nil {
+		return x.expr.Pos
+	}
+	if len(x.lits) > 0 {
+		return x.lits[0].Pos
+	}
+	return lexer.Position{}
+}
+
+// fits reports whether the integer constant v fits into an integer of type
+// t, either as a signed or as an unsigned integer.
+func fits(v constant.Value, t *Int) bool {
+	if v.Kind() != constant.Int {
+		return true
+	}
+	one := constant.MakeInt64(1)
+	max := constant.BinaryOp(constant.Shift(one, token.SHL, uint(t.Bits)), token.SUB, one)
+	min := constant.UnaryOp(token.SUB, constant.Shift(one, token.SHL, uint(t.Bits-1)), 0)
+	return constant.Compare(v, token.GEQ, min) && constant.Compare(v, token.LEQ, max)
+}
+
+// matchOperands converts an untyped operand of a binary operation to the type
+// of the other operand. It reports whether the operands can be matched.
+func (c *Checker) matchOperands(x, y *operand) bool {
+	switch {
+	case isUntyped(x.typ) && isUntyped(y.typ):
+		if !c.convertUntyped(x, y.typ) {
+			return false
+		}
+		return c.convertUntyped(y, x.typ)
+	case isUntyped(x.typ):
+		return c.convertUntyped(x, y.typ)
+	case isUntyped(y.typ):
+		return c.convertUntyped(y, x.typ)
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package checker
+
+import (
+	"go/constant"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+type ObjectKind int
+
+const (
+	// Variables, parameters and globals
+	VarObject ObjectKind = iota
+	ConstObject
+	FuncObject
+	// Classes, interfaces, enums and type parameters
+	TypeObject
+	// Modules imported with import "path" as alias
+	ModuleObject
+)
+
+// An Object is something a name refers to.
+type Object struct {
+	Kind ObjectKind
+	Name string
+	Type Type
+	// Where the object was declared, if it was declared in source code
+	Pos lexer.Position
+	// Value of constants whose initializer is known at compile time
+	Value constant.Value
+	// The type of the variable was inferred from its initializer
+	Inferred bool
+
+	// Template of generic functions, with the scope it was declared in
+	generic *parser.FunctionDefinition
+	scope   *Scope
+	// Exports of a module
+	module *Scope
+}
+
+// String describes the object the way it is declared, like var x: i64, for
+// tools showing what a name refers to.
+func (obj *Object) String() string {
+	switch obj.Kind {
+	case VarObject:
+		return "var " + obj.Name + ": " + obj.Type.String()
+	case ConstObject:
+		if obj.Value != nil {
+			return "const " + obj.Name + ": " + obj.Type.String() + " = " + obj.Value.String()
+		}
+		return "const " + obj.Name + ": " + obj.Type.String()
+	case FuncObject:
+		return "func " + obj.Name + strings.TrimPrefix(obj.Type.String(), "func")
+	case ModuleObject:
+		return "import " + obj.Name
+	}
+	switch obj.Type.(type) {
+	case *Class:
+		return "class " + obj.Name
+	case *Interface:
+		return "interface " + obj.Name
+	case *Enum:
+		return "enum " + obj.Name
+	}
+	return obj.Name
+}
+
+// Scope maps names to the objects they refer to. Lookups that fail in a scope
+// continue in its parent.
+type Scope struct {
+	parent  *Scope
+	objects map[string]*Object
+	pkg     *pkg
+}
+
+func newScope(parent *Scope) *Scope {
+	s := &Scope{parent: parent, objects: make(map[string]*Object)}
+	if parent != nil {
+		s.pkg = parent.pkg
+	}
+	return s
+}
+
+func (s *Scope) insert(obj *Object) {
+	s.objects[obj.Name] = obj
+}
+
+func (s *Scope) lookup(name string) *Object {
+	for ; s != nil; s = s.parent {
+		if obj, ok := s.objects[name]; ok {
+			return obj
+		}
+	}
+	return nil
+}
+
+// lookupKind looks up the object of kind called name. Unlike lookup, it skips
+// objects of other kinds, as variables do not hide functions of the same name.
+func (s *Scope) lookupKind(name string, kind ObjectKind) *Object {
+	for ; s != nil; s = s.parent {
+		if obj, ok := s.objects[name]; ok && obj.Kind == kind {
+			return obj
+		}
+	}
+	return nil
+}
+
+// names returns the names of the objects of kind visible from s, to suggest
+// when an unknown name is used.
+func (s *Scope) names(kinds ...ObjectKind) []string {
+	var names []string
+	for ; s != nil; s = s.parent {
+		for name, obj := range s.objects {
+			for _, kind := range kinds {
+				if obj.Kind == kind {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pkg is a parsed file, either the one being checked or one it imports.
+type pkg struct {
+	name  string
+	scope *Scope
+	// Exported objects, by the name they are declared as
+	exports map[string]*Object
+	// Imported files are only declared, errors are reported for the checked file
+	imported bool
+}
+
+type classDecl struct {
+	name  string
+	def   *parser.ClassDefinition
+	scope *Scope
+	// Members of the class, and of every instance of a generic class
+	instances map[string]*classMembers
+}
+
+type interfaceDecl struct {
+	name  string
+	def   *parser.InterfaceDefinition
+	scope *Scope
+	// Signatures of the methods, resolved on first use
+	methods map[string]*Signature
+}
+
+type enumDecl struct {
+	name  string
+	def   *parser.EnumDefinition
+	scope *Scope
+	// Types of the values carried by each variant, resolved on first use
+	payloads [][]Type
+}
+
+// A member is a field or method of a class. Methods are named like the
+// functions implementing them: name, get.name, set.name or op.+.
+type member struct {
+	name    string
+	typ     Type
+	method  bool
+	private bool
+	static  bool
+	owner   *Class
+	pos     lexer.Position
+}
+
+type classMembers struct {
+	parent *Class
+	// Members of the class and the classes it extends
+	members map[string]*member
+	// Names of the instance fields, in the order they are laid out
+	fields []string
+}
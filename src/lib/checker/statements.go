@@ -0,0 +1,729 @@
+package checker
+
+import (
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// stmt checks s, declaring the names it defines in scope. Statements are
+// checked in order, so names can only be used after they are defined.
+func (c *Checker) stmt(s *parser.Statement, scope *Scope) {
+	c.stmtExported(s, scope, false)
+}
+
+func (c *Checker) stmtExported(s *parser.Statement, scope *Scope, exported bool) {
+	switch {
+	case s.VariableDefinition != nil:
+		c.varDef(s.VariableDefinition, scope, exported)
+	case s.Assignment != nil:
+		c.assignment(s.Assignment, scope)
+	case s.FunctionDefinition != nil:
+		c.funcDef(s.FunctionDefinition, scope, exported)
+	case s.ClassDefinition != nil:
+		c.classDef(s.ClassDefinition, scope, exported)
+	case s.Interface != nil:
+		// Types and functions are global, wherever they are defined
+		c.declare(c.pkg.scope, c.interfaceObject(s.Interface, scope), exported)
+		defined := make(map[string]bool)
+		for _, m := range s.Interface.Methods {
+			if defined[m.Name] {
+				c.errorf(diagnostics.CodeCompile, m.Pos, "Method %s is already defined in interface %s", m.Name, s.Interface.Name)
+			}
+			defined[m.Name] = true
+			c.signature(m.Parameters, false, m.ReturnType, scope, true)
+		}
+	case s.Enum != nil:
+		c.declare(c.pkg.scope, c.enumObject(s.Enum, scope), exported)
+		defined := make(map[string]bool)
+		for _, v := range s.Enum.Variants {
+			if defined[v.Name] {
+				c.errorf(diagnostics.CodeCompile, v.Pos, "Variant %s is already defined in enum %s", v.Name, s.Enum.Name)
+			}
+			defined[v.Name] = true
+			for _, t := range v.Payload {
+				c.resolveType(t, scope, true)
+			}
+		}
+	case s.Match != nil:
+		c.match(s.Match, scope)
+	case s.If != nil:
+		c.ifStmt(s.If, scope)
+	case s.For != nil:
+		c.forStmt(s.For, scope)
+	case s.While != nil:
+		c.loop(s.While.Condition, s.While.Body, "while", scope)
+	case s.Until != nil:
+		c.loop(s.Until.Condition, s.Until.Body, "until", scope)
+	case s.Switch != nil:
+		c.switchStmt(s.Switch, scope)
+	case s.Return != nil:
+		c.returnStmt(s.Return, scope)
+	case s.TryCatch != nil:
+		c.block(s.TryCatch.Try, newScope(scope))
+		catch := newScope(scope)
+		catch.insert(&Object{Kind: VarObject, Name: s.TryCatch.Catch.Name, Type: String, Pos: s.TryCatch.Catch.Pos})
+		c.block(s.TryCatch.Catch.Body, catch)
+		c.block(s.TryCatch.Final, newScope(scope))
+	case s.Throw != nil:
+		// Exceptions are thrown as an *i8, integers are converted to one
+		x := c.expr(s.Throw, scope)
+		if isInteger(x.typ) {
+			c.convertUntyped(&x, I8)
+		} else {
+			c.convertUntyped(&x, String)
+		}
+		switch x.typ.(type) {
+		case *Pointer, *Int, *invalidType, *TypeParam:
+		default:
+			c.typeError(s.Throw.Pos, "Cannot throw a value of type %s", x.typ)
+		}
+	case s.Break != nil:
+		if c.breaks == 0 {
+			c.errorf(diagnostics.CodeCompile, s.Pos, "break can only be used inside of loops and switch statements")
+		}
+	case s.Continue != nil:
+		if c.loops == 0 {
+			c.errorf(diagnostics.CodeCompile, s.Pos, "continue can only be used inside of loops")
+		}
+	case s.Expression != nil:
+		c.expr(s.Expression, scope)
+	case s.FieldDefinition != nil:
+		c.errorf(diagnostics.CodeCompile, s.FieldDefinition.Pos, "Field definitions are not allowed outside of classes")
+	case s.External != nil:
+		// The function may already be declared by an import
+		name := strings.Trim(s.External.Name, "\"")
+		if scope.lookupKind(name, FuncObject) == nil {
+			c.declare(c.pkg.scope, c.externObject(s.External, scope, true), exported)
+		} else {
+			c.signature(s.External.Parameters, false, s.External.ReturnType, scope, true)
+		}
+	case isImport(s):
+		c.importStmt(s, scope)
+	case s.Export != nil:
+		c.stmtExported(s.Export, scope, true)
+	}
+}
+
+// block checks the statements of a block in scope.
+func (c *Checker) block(stmts []*parser.Statement, scope *Scope) {
+	for _, s := range stmts {
+		c.stmt(s, scope)
+	}
+}
+
+// varDef checks the definition of a variable or constant and declares it.
+// Variables defined without a type get the type of their initializer.
+func (c *Checker) varDef(v *parser.VariableDefinition, scope *Scope, exported bool) {
+	obj := &Object{Kind: VarObject, Name: v.Name, Pos: v.Pos}
+	if v.Constant == "const" {
+		obj.Kind = ConstObject
+		if v.Assignment == nil {
+			c.errorf(diagnostics.CodeCompile, v.Pos, "Constant definition must have assignment")
+		}
+	}
+
+	if v.Type != nil {
+		obj.Type = c.resolveType(v.Type, scope, true)
+		if _, ok := obj.Type.(*Void); ok {
+			c.typeError(v.Type.Pos, "Variable %s can not be of type void", v.Name)
+		}
+	} else if v.Assignment == nil {
+		c.typeError(v.Pos, "Variable %s needs a type or an initial value", v.Name)
+		obj.Type = Invalid
+	}
+
+	if v.Assignment != nil {
+		x := c.exprHint(v.Assignment, scope, obj.Type)
+		ok := true
+		if v.Type == nil {
+			obj.Type = c.infer(v, &x)
+			obj.Inferred = true
+		} else {
+			ok = c.assign(&x, v.Assignment, obj.Type, "the definition of "+v.Name)
+		}
+		if ok && obj.Kind == ConstObject {
+			obj.Value = x.val
+		}
+	}
+
+	// Declared after the initializer, which can not refer to the variable
+	c.Info.Defs[v] = obj
+	c.declare(scope, obj, exported)
+}
+
+// infer returns the type of the variable v defined without a type, which is
+// the type of its initializer x. Untyped literals get their default type, i64
+// for integers, f64 for floats and *i8 for null.
+func (c *Checker) infer(v *parser.VariableDefinition, x *operand) Type {
+	c.convertUntyped(x, Default(x.typ))
+	switch t := x.typ.(type) {
+	case *Void:
+		c.typeError(v.Assignment.Pos, "Cannot infer the type of %s, its initializer has no value", v.Name)
+		return Invalid
+	case *Tuple:
+		c.typeError(v.Assignment.Pos, "Cannot infer the type of %s from %d values", v.Name, len(t.Types))
+		return Invalid
+	}
+	return x.typ
+}
+
+// declaredHere points the last error at the definition of the variable
+// ident refers to. Inferred types are pointed out, as they may not be the
+// ones the programmer had in mind.
+func (c *Checker) declaredHere(ident *parser.Identifier) {
+	obj := c.Info.Uses[ident]
+	if obj == nil || obj.Kind != VarObject || obj.Pos.Line == 0 || ident.Sub != nil || c.pkg.imported || len(c.diags) == 0 {
+		return
+	}
+	if obj.Inferred {
+		c.diags[len(c.diags)-1].WithNote(diagnostics.At(obj.Pos), "%s is inferred to be of type %s from its initializer", obj.Name, obj.Type)
+		return
+	}
+	c.diags[len(c.diags)-1].WithNote(diagnostics.At(obj.Pos), "variable %s declared here", obj.Name)
+}
+
+// checkMutable reports the modification of a constant through ident.
+// action describes the modification, as in "Cannot assign to".
+func (c *Checker) checkMutable(ident *parser.Identifier, scope *Scope, action string) {
+	i, obj := c.resolveModule(ident, scope)
+	if obj == nil || obj.Kind != ConstObject || i.Sub != nil || i.Deref != "" {
+		return
+	}
+	c.errorf(diagnostics.CodeCompile, ident.Pos, "Cannot %s constant %s", action, i.Name).WithNote(diagnostics.At(obj.Pos), "constant %s defined here", obj.Name)
+}
+
+// assignment checks an assignment to one or more targets.
+func (c *Checker) assignment(a *parser.Assignment, scope *Scope) {
+	targets := make([]Type, len(a.Idents))
+	for i, ident := range a.Idents {
+		c.checkMutable(ident, scope, "assign to")
+		typ, prop := c.target(ident, scope)
+		if prop != nil {
+			if len(a.Idents) != 1 {
+				c.typeError(a.Pos, "Properties can not be assigned together with other values")
+				return
+			}
+			if a.Op != "=" && prop.getter == nil {
+				c.typeError(a.Pos, "Property %s can not be read, it has no getter", prop.name)
+			}
+			typ = prop.typ
+		}
+		targets[i] = typ
+	}
+
+	if len(targets) > 1 {
+		x := c.expr(a.Right, scope)
+		if a.Op != "=" {
+			c.typeError(a.Pos, "Compound assignment only supports a single target")
+			return
+		}
+		tuple, ok := x.typ.(*Tuple)
+		if !ok {
+			if !isInvalid(x.typ) {
+				c.typeError(a.Right.Pos, "Cannot assign a value of type %s to multiple variables", x.typ)
+			}
+			return
+		}
+		if len(tuple.Types) != len(targets) {
+			c.typeError(a.Right.Pos, "Unable to unpack %d values into %d variables", len(tuple.Types), len(targets))
+			return
+		}
+		for i, t := range targets {
+			if !c.assignable(tuple.Types[i], t) {
+				c.typeError(a.Idents[i].Pos, "Cannot assign a value of type %s to %s of type %s", tuple.Types[i], a.Idents[i].Name, t)
+				c.declaredHere(a.Idents[i])
+			}
+		}
+		return
+	}
+
+	target := targets[0]
+	name := identName(a.Idents[0])
+	if a.Op == "=" {
+		x := c.exprHint(a.Right, scope, target)
+		if !c.assign(&x, a.Right, target, "the assignment to "+name) {
+			c.declaredHere(a.Idents[0])
+		}
+		return
+	}
+
+	op := strings.TrimSuffix(a.Op, "=")
+	if class, ok := classOf(target); ok {
+		x := c.expr(a.Right, scope)
+		c.operator(a.Pos, class, op, &x)
+		return
+	}
+	x := c.expr(a.Right, scope)
+	if isInvalid(target) {
+		c.convertUntyped(&x, target)
+		return
+	}
+	switch op {
+	case "??":
+		if _, ok := target.(*Pointer); !ok {
+			c.typeError(a.Idents[0].Pos, "Operator %s requires a pointer, %s is of type %s", a.Op, name, target)
+			c.declaredHere(a.Idents[0])
+			return
+		}
+		if !c.assign(&x, a.Right, target, "the assignment to "+name) {
+			c.declaredHere(a.Idents[0])
+		}
+		return
+	case "&", "|", "^", "<<", ">>", ">>>":
+		if !isInteger(target) {
+			c.typeError(a.Idents[0].Pos, "Operator %s requires an integer, %s is of type %s", a.Op, name, target)
+			c.declaredHere(a.Idents[0])
+			return
+		}
+	case "%":
+		if _, ok := target.(*Float); ok {
+			c.typeError(a.Pos, "Modulus operator not allowed on float")
+			return
+		}
+		fallthrough
+	default:
+		if !isNumeric(target) {
+			c.typeError(a.Idents[0].Pos, "Numeric operator used on non-numeric identifier %s", name)
+			c.declaredHere(a.Idents[0])
+			return
+		}
+	}
+	if !c.assign(&x, a.Right, target, "the assignment to "+name) {
+		c.declaredHere(a.Idents[0])
+	}
+}
+
+// identName returns the name of an identifier as it is written.
+func identName(i *parser.Identifier) string {
+	name := i.Name
+	for sub := i.Sub; sub != nil; sub = sub.Sub {
+		name += "." + sub.Name
+	}
+	return name
+}
+
+// funcDef checks the definition of a function and declares it. Functions are
+// declared before their body is checked, so they can call themselves.
+func (c *Checker) funcDef(f *parser.FunctionDefinition, scope *Scope, exported bool) {
+	if len(f.TypeParams) > 0 && f.Extern {
+		c.errorf(diagnostics.CodeCompile, f.Pos, "Extern functions can not have type parameters")
+		return
+	}
+	obj := c.funcObject(f, scope, true)
+	c.declare(c.pkg.scope, obj, exported)
+
+	// Function bodies only see globals, not the variables of the function
+	// they may be defined in
+	body := c.pkg.scope
+	if len(f.TypeParams) > 0 {
+		body = typeParamScope(body, f.TypeParams, nil)
+	}
+	c.body(f, obj.Type.(*Signature), nil, body)
+}
+
+// body checks the body of function or method f with signature sig.
+func (c *Checker) body(f *parser.FunctionDefinition, sig *Signature, class *Class, outer *Scope) {
+	scope := newScope(outer)
+	if class != nil && !f.Static {
+		scope.insert(&Object{Kind: VarObject, Name: "this", Type: &Pointer{Elem: class}, Pos: f.Pos})
+	}
+	for i, p := range f.Parameters {
+		scope.insert(&Object{Kind: VarObject, Name: p.Name, Type: sig.Params[i], Pos: p.Pos})
+	}
+	if f.Variadic != "" {
+		scope.insert(&Object{Kind: VarObject, Name: f.Variadic, Type: String, Pos: f.Pos})
+	}
+
+	fn, loops, breaks := c.fn, c.loops, c.breaks
+	c.fn = &function{name: strings.Trim(f.Name.Name, "\""), result: sig.Result, class: class}
+	c.loops, c.breaks = 0, 0
+	defer func() { c.fn, c.loops, c.breaks = fn, loops, breaks }()
+
+	c.block(f.Body, scope)
+	if _, void := sig.Result.(*Void); !void && !c.partial && !terminates(f.Body) {
+		if class != nil {
+			c.errorf(diagnostics.CodeCompile, f.Pos, "Method `%s` of class `%s` does not return a value", f.Name.Name, class.Name())
+		} else {
+			c.errorf(diagnostics.CodeCompile, f.Pos, "Function `%s` does not return a value", f.Name.Name)
+		}
+	}
+}
+
+// terminates reports whether a block never completes normally, because it
+// returns or throws on every way through it. Statements following such a
+// statement are never run.
+func terminates(stmts []*parser.Statement) bool {
+	for _, s := range stmts {
+		switch {
+		case s.Return != nil, s.Throw != nil:
+			return true
+		case s.If != nil:
+			if s.If.Else == nil || !terminates(s.If.Body) || !terminates(s.If.Else) {
+				continue
+			}
+			all := true
+			for _, branch := range s.If.ElseIf {
+				all = all && terminates(branch.Body)
+			}
+			if all {
+				return true
+			}
+		case s.TryCatch != nil:
+			if terminates(s.TryCatch.Final) || terminates(s.TryCatch.Try) && terminates(s.TryCatch.Catch.Body) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// classDef checks the definition of a class and declares it. The bodies of
+// generic classes are checked once for any type arguments.
+func (c *Checker) classDef(def *parser.ClassDefinition, scope *Scope, exported bool) {
+	obj := c.classObject(def, scope)
+	c.declare(c.pkg.scope, obj, exported)
+	c.classBody(def, obj.Type.(*Class), scope)
+}
+
+// classBody checks the members of class, which is defined by def.
+func (c *Checker) classBody(def *parser.ClassDefinition, class *Class, scope *Scope) {
+	if len(def.TypeParams) > 0 {
+		args := make([]Type, len(def.TypeParams))
+		for i, param := range def.TypeParams {
+			args[i] = &TypeParam{Name: param}
+		}
+		class = &Class{decl: class.decl, Args: args}
+	}
+
+	if def.Extends != "" {
+		parent := lookupQualified(def.Extends, scope)
+		if parent == nil || parent.Kind != TypeObject {
+			c.undefined(def.Pos, "Class %s extends unknown class %s", def.Name, def.Extends).Suggest(def.Extends, scope.names(TypeObject))
+		} else if p, ok := parent.Type.(*Class); !ok || len(p.decl.def.TypeParams) > 0 {
+			c.typeError(def.Pos, "Class %s can only extend a class that is not generic, %s is not one", def.Name, def.Extends)
+		} else if c.isSubclass(p, class) {
+			c.typeError(def.Pos, "Class %s can not extend itself", def.Name)
+		}
+	}
+	for _, name := range def.Implements {
+		iface := lookupQualified(name, scope)
+		if iface == nil || iface.Kind != TypeObject {
+			c.undefined(def.Pos, "Class %s implements unknown interface %s", def.Name, name).Suggest(name, scope.names(TypeObject))
+		} else if i, ok := iface.Type.(*Interface); !ok {
+			c.typeError(def.Pos, "Class %s can only implement interfaces, %s is not one", def.Name, name)
+		} else if why := c.missingMethod(class, i); why != "" && len(def.TypeParams) == 0 {
+			// Instances of generic classes are checked when they are
+			// converted to the interface
+			c.typeError(def.Pos, "Class %s does not implement interface %s, %s", def.Name, name, why)
+		}
+	}
+
+	var inherited map[string]*member
+	if parent := c.members(class).parent; parent != nil {
+		inherited = c.members(parent).members
+	}
+	members := classScope(class)
+	defined := make(map[string]lexer.Position)
+	for _, s := range def.Body {
+		// Fields and methods share one namespace, operators, getters and
+		// setters are told apart by their name, see methodName
+		name, what, pos := "", "", lexer.Position{}
+		if f := s.FieldDefinition; f != nil {
+			name, what, pos = f.Name, "field "+f.Name, f.Pos
+		} else if f := s.FunctionDefinition; f != nil {
+			name, what, pos = methodName(f.Name), memberDescription(f.Name), f.Pos
+		}
+		if prev, ok := defined[name]; ok && name != "" {
+			c.errorf(diagnostics.CodeCompile, pos, "Class %s already defines %s", def.Name, what).WithNote(diagnostics.At(prev), "%s first defined here", what)
+			continue
+		}
+		defined[name] = pos
+
+		switch {
+		case s.FieldDefinition != nil:
+			f := s.FieldDefinition
+			if mem, ok := inherited[f.Name]; ok && !mem.method && !mem.static {
+				c.errorf(diagnostics.CodeCompile, f.Pos, "Field %s is already defined in class %s", f.Name, def.Name).WithNote(diagnostics.At(mem.pos), "field %s of class %s defined here", f.Name, mem.owner)
+			}
+			if f.Static && len(def.TypeParams) > 0 {
+				c.errorf(diagnostics.CodeCompile, f.Pos, "Generic class %s can not have static members", def.Name)
+			}
+			typ := c.resolveType(f.Type, members, true)
+			if f.Assignment == nil {
+				continue
+			}
+			if !f.Static {
+				c.errorf(diagnostics.CodeCompile, f.Assignment.Pos, "Only static fields can have an initial value")
+				continue
+			}
+			x := c.exprHint(f.Assignment, members, typ)
+			c.assign(&x, f.Assignment, typ, "the definition of "+f.Name)
+		case s.FunctionDefinition != nil:
+			f := s.FunctionDefinition
+			if len(f.TypeParams) > 0 {
+				c.errorf(diagnostics.CodeCompile, f.Pos, "Methods can not have type parameters")
+				continue
+			}
+			if f.Extern {
+				c.errorf(diagnostics.CodeCompile, f.Pos, "Methods can not be extern")
+				continue
+			}
+			special := f.Name.Op || f.Name.Get || f.Name.Set || f.Name.Name == "constructor"
+			if f.Static && special {
+				c.errorf(diagnostics.CodeCompile, f.Pos, "Operators, accessors and constructors can not be static")
+			} else if f.Static && len(def.TypeParams) > 0 {
+				c.errorf(diagnostics.CodeCompile, f.Pos, "Generic class %s can not have static members", def.Name)
+			}
+			sig := c.signature(f.Parameters, f.Variadic != "", f.ReturnType, members, true)
+			if op := strings.Trim(f.Name.Name, "\""); f.Name.Op && !validOperands(op, len(sig.Params)) {
+				c.errorf(diagnostics.CodeCompile, f.Pos, "Operator %s of class %s can not take %d arguments", op, def.Name, len(sig.Params))
+			}
+			if !special && !f.Static {
+				c.override(f, sig, def.Name, inherited[f.Name.Name])
+			}
+			c.body(f, sig, class, members)
+		}
+	}
+}
+
+// validOperands reports whether an operator method for op can take n
+// operands besides the instance it is called on. Unary operators take none
+// and binary operators one, - may be either.
+func validOperands(op string, n int) bool {
+	switch op {
+	case "!", "~", "++", "--":
+		return n == 0
+	case "-":
+		return n <= 1
+	}
+	return n == 1
+}
+
+// override checks method f of class name, with signature sig, which overrides
+// parent if that is a method of the class it extends. Overrides take the slot
+// of the parent in the vtable, so they must be callable like it.
+func (c *Checker) override(f *parser.FunctionDefinition, sig *Signature, name string, parent *member) {
+	if parent == nil || !parent.method || parent.static || parent.private {
+		return
+	}
+	if f.Private {
+		c.errorf(diagnostics.CodeCompile, f.Pos, "Private method %s of class %s can not override a method of its parent", f.Name.Name, name)
+	} else if !Identical(sig, parent.typ) {
+		c.typeError(f.Pos, "Method %s of class %s does not match the signature of the method it overrides", f.Name.Name, name).WithNote(diagnostics.At(parent.pos), "%s.%s is declared as %s here", parent.owner, f.Name.Name, parent.typ)
+	}
+}
+
+// memberDescription describes the method declared with name in errors.
+func memberDescription(name parser.FuncName) string {
+	trimmed := strings.Trim(name.Name, "\"")
+	if name.Op {
+		return "operator " + trimmed
+	} else if name.Get {
+		return "getter " + trimmed
+	} else if name.Set {
+		return "setter " + trimmed
+	}
+	return "method " + name.Name
+}
+
+func (c *Checker) condition(e *parser.Expression, what string, scope *Scope) {
+	x := c.expr(e, scope)
+	if !isInvalid(x.typ) && !isBool(x.typ) {
+		c.typeError(e.Pos, "%s condition must be a boolean, got %s", what, x.typ)
+	}
+}
+
+// ifStmt checks an if statement. Its bodies share the scope of the
+// statement, like they share the context they are compiled in.
+func (c *Checker) ifStmt(i *parser.If, scope *Scope) {
+	c.condition(i.Condition, "if", scope)
+	c.block(i.Body, scope)
+	for _, branch := range i.ElseIf {
+		c.condition(branch.Condition, "if", scope)
+		c.block(branch.Body, scope)
+	}
+	c.block(i.Else, scope)
+}
+
+// forStmt checks a for loop. Its initializer defines variables in the scope
+// of the loop, they are still visible after it.
+func (c *Checker) forStmt(f *parser.For, scope *Scope) {
+	c.stmt(f.Initializer, scope)
+	c.condition(f.Condition, "for", scope)
+
+	body := newScope(scope)
+	c.loops++
+	c.breaks++
+	c.block(f.Body, body)
+	c.stmt(f.Increment, body)
+	c.loops--
+	c.breaks--
+}
+
+func (c *Checker) loop(cond *parser.Expression, stmts []*parser.Statement, what string, scope *Scope) {
+	c.condition(cond, what, scope)
+	c.loops++
+	c.breaks++
+	c.block(stmts, newScope(scope))
+	c.loops--
+	c.breaks--
+}
+
+// caseBody checks the body of a switch case or match arm, which break leaves.
+func (c *Checker) caseBody(stmts []*parser.Statement, scope *Scope) {
+	c.breaks++
+	c.block(stmts, scope)
+	c.breaks--
+}
+
+func (c *Checker) switchStmt(s *parser.Switch, scope *Scope) {
+	x := c.expr(s.Condition, scope)
+	c.convertUntyped(&x, Default(x.typ))
+	cond := x.typ
+	switch cond.(type) {
+	case *Int, *Float, *Pointer, *Enum, *invalidType, *TypeParam:
+	default:
+		c.typeError(s.Condition.Pos, "Cannot switch on a value of type %s", cond)
+		cond = Invalid
+	}
+	if e, ok := cond.(*Enum); ok && e.Tagged() {
+		c.typeError(s.Condition.Pos, "Cannot switch on a value of type %s, use match instead", cond)
+		cond = Invalid
+	}
+
+	seen := make(map[string]bool)
+	for _, cs := range s.Cases {
+		for _, v := range cs.Values {
+			y := c.exprHint(v, scope, cond)
+			if !isString(cond) || !isString(y.typ) {
+				if !c.convertUntyped(&y, cond) {
+					c.typeError(v.Pos, "Case value must be the same type as the switch value (%s != %s)", y.typ, cond)
+					continue
+				}
+				if !isInvalid(cond) && !isInvalid(y.typ) && !Identical(y.typ, cond) {
+					c.typeError(v.Pos, "Case value must be the same type as the switch value (%s != %s)", y.typ, cond)
+					continue
+				}
+			}
+			if y.val != nil {
+				key := y.val.ExactString()
+				if seen[key] {
+					c.errorf(diagnostics.CodeCompile, v.Pos, "Duplicate case value %s in switch statement", key)
+				}
+				seen[key] = true
+			}
+		}
+		c.caseBody(cs.Body, newScope(scope))
+	}
+	c.caseBody(s.Default, newScope(scope))
+}
+
+func (c *Checker) match(m *parser.Match, scope *Scope) {
+	x := c.expr(m.Value, scope)
+	enum, ok := x.typ.(*Enum)
+	if p, isPtr := x.typ.(*Pointer); isPtr {
+		enum, ok = p.Elem.(*Enum)
+	}
+	if !ok && !isInvalid(x.typ) {
+		c.typeError(m.Value.Pos, "Cannot match on a value of type %s", x.typ)
+	}
+
+	matched := make(map[int]bool)
+	for _, arm := range m.Arms {
+		body := newScope(scope)
+		for _, p := range arm.Patterns {
+			if enum == nil {
+				continue
+			}
+			if p.Enum != "" && p.Enum != enum.Name() {
+				c.typeError(p.Pos, "Pattern of enum %s can not match a value of enum %s", p.Enum, enum.Name())
+				continue
+			}
+			index := enum.variant(p.Variant)
+			if index < 0 {
+				c.undefined(p.Pos, "Variant %s not found in enum %s", p.Variant, enum.Name()).Suggest(p.Variant, enum.variantNames())
+				continue
+			}
+			if matched[index] {
+				c.errorf(diagnostics.CodeCompile, p.Pos, "Variant %s is matched more than once", p.Variant)
+			}
+			matched[index] = true
+			if p.Bindings == nil {
+				continue
+			}
+			if len(arm.Patterns) > 1 {
+				c.errorf(diagnostics.CodeCompile, p.Pos, "Patterns binding values can not be combined with other patterns")
+				continue
+			}
+			payload := c.payload(enum, index)
+			if len(p.Bindings) != len(payload) {
+				c.typeError(p.Pos, "Variant %s of enum %s does not carry %d values", p.Variant, enum.Name(), len(p.Bindings))
+				continue
+			}
+			for i, binding := range p.Bindings {
+				if binding != "_" {
+					body.insert(&Object{Kind: VarObject, Name: binding, Type: payload[i], Pos: p.Pos})
+				}
+			}
+		}
+		c.caseBody(arm.Body, body)
+	}
+	c.caseBody(m.Default, newScope(scope))
+
+	if enum == nil || m.HasDefault {
+		return
+	}
+	var missing []string
+	for i, name := range enum.variantNames() {
+		if !matched[i] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		c.errorf(diagnostics.CodeCompile, m.Pos, "Match on enum %s is not exhaustive, missing %s", enum.Name(), strings.Join(missing, ", "))
+	}
+}
+
+func (c *Checker) returnStmt(r *parser.Return, scope *Scope) {
+	if c.fn == nil {
+		c.errorf(diagnostics.CodeCompile, r.Pos, "return can only be used inside of functions")
+		for _, e := range r.Expressions {
+			c.expr(e, scope)
+		}
+		return
+	}
+
+	result := c.fn.result
+	switch len(r.Expressions) {
+	case 0:
+		if _, void := result.(*Void); !void {
+			c.typeError(r.Pos, "Function %s must return a value of type %s", c.fn.name, result)
+		}
+		return
+	case 1:
+		e := r.Expressions[0]
+		x := c.exprHint(e, scope, result)
+		if _, void := result.(*Void); void {
+			c.typeError(e.Pos, "Function %s does not return a value", c.fn.name)
+			return
+		}
+		c.assign(&x, e, result, "the return value of "+c.fn.name)
+		return
+	}
+
+	tuple, ok := result.(*Tuple)
+	if !ok || len(tuple.Types) != len(r.Expressions) {
+		c.typeError(r.Pos, "Function %s must return %s, got %d values", c.fn.name, result, len(r.Expressions))
+		for _, e := range r.Expressions {
+			c.expr(e, scope)
+		}
+		return
+	}
+	for i, e := range r.Expressions {
+		x := c.exprHint(e, scope, tuple.Types[i])
+		c.assign(&x, e, tuple.Types[i], "the return value of "+c.fn.name)
+	}
+}
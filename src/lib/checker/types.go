@@ -0,0 +1,430 @@
+package checker
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// Type is the type of a value in CaffeineC source code, as opposed to the
+// LLVM type it is compiled to.
+type Type interface {
+	String() string
+}
+
+// Int is a signed or unsigned integer, i1 is the type of booleans.
+type Int struct {
+	Bits     uint64
+	Unsigned bool
+}
+
+func (t *Int) String() string {
+	if t.Unsigned {
+		return "u" + strconv.FormatUint(t.Bits, 10)
+	}
+	return "i" + strconv.FormatUint(t.Bits, 10)
+}
+
+type Float struct {
+	Bits uint64
+}
+
+func (t *Float) String() string {
+	return "f" + strconv.FormatUint(t.Bits, 10)
+}
+
+type Void struct{}
+
+func (t *Void) String() string {
+	return "void"
+}
+
+type Pointer struct {
+	Elem Type
+}
+
+func (t *Pointer) String() string {
+	return "*" + t.Elem.String()
+}
+
+// Array is a fixed-size array. Arrays whose size is not known to the checker
+// have a negative length.
+type Array struct {
+	Len  int64
+	Elem Type
+}
+
+func (t *Array) String() string {
+	if t.Len < 0 {
+		return "[]" + t.Elem.String()
+	}
+	return "[" + strconv.FormatInt(t.Len, 10) + "]" + t.Elem.String()
+}
+
+// Tuple holds the results of a function returning more than one value.
+type Tuple struct {
+	Types []Type
+}
+
+func (t *Tuple) String() string {
+	names := make([]string, len(t.Types))
+	for i, typ := range t.Types {
+		names[i] = typ.String()
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// Signature is the type of functions and methods. Methods do not list their
+// this parameter.
+type Signature struct {
+	Params   []Type
+	Result   Type
+	Variadic bool
+}
+
+func (t *Signature) String() string {
+	names := make([]string, len(t.Params))
+	for i, typ := range t.Params {
+		names[i] = typ.String()
+	}
+	if t.Variadic {
+		names = append(names, "...")
+	}
+	return "func(" + strings.Join(names, ", ") + "): " + t.Result.String()
+}
+
+// Class is a class, or an instance of a generic class for Args.
+type Class struct {
+	decl *classDecl
+	Args []Type
+}
+
+func (t *Class) Name() string {
+	return t.decl.name
+}
+
+// Def returns the definition of the class.
+func (t *Class) Def() *parser.ClassDefinition {
+	return t.decl.def
+}
+
+func (t *Class) String() string {
+	if len(t.Args) == 0 {
+		return t.decl.name
+	}
+	names := make([]string, len(t.Args))
+	for i, typ := range t.Args {
+		names[i] = typ.String()
+	}
+	return t.decl.name + "<" + strings.Join(names, ",") + ">"
+}
+
+type Interface struct {
+	decl *interfaceDecl
+}
+
+func (t *Interface) Name() string {
+	return t.decl.name
+}
+
+// Def returns the definition of the interface.
+func (t *Interface) Def() *parser.InterfaceDefinition {
+	return t.decl.def
+}
+
+func (t *Interface) String() string {
+	return t.decl.name
+}
+
+type Enum struct {
+	decl *enumDecl
+}
+
+func (t *Enum) Name() string {
+	return t.decl.name
+}
+
+// Def returns the definition of the enum.
+func (t *Enum) Def() *parser.EnumDefinition {
+	return t.decl.def
+}
+
+func (t *Enum) String() string {
+	return t.decl.name
+}
+
+// Tagged reports whether variants of the enum carry values.
+func (t *Enum) Tagged() bool {
+	for _, v := range t.decl.def.Variants {
+		if len(v.Payload) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TypeParam is a type parameter of a generic function or class, which stands
+// for any type until the template is instantiated.
+type TypeParam struct {
+	Name string
+}
+
+func (t *TypeParam) String() string {
+	return t.Name
+}
+
+type untypedKind int
+
+const (
+	untypedInt untypedKind = iota
+	untypedFloat
+	untypedNull
+)
+
+// Untyped is the type of literals whose type depends on where they are used.
+// Numbers default to i64 and f64, null to *i8.
+type Untyped struct {
+	kind untypedKind
+}
+
+func (t *Untyped) String() string {
+	switch t.kind {
+	case untypedFloat:
+		return "untyped float"
+	case untypedNull:
+		return "null"
+	default:
+		return "untyped int"
+	}
+}
+
+type invalidType struct{}
+
+func (t *invalidType) String() string {
+	return "invalid type"
+}
+
+var (
+	// Invalid is the type of expressions that could not be checked. Checks
+	// involving it always pass, so one error is not reported again by every
+	// expression using the value.
+	Invalid Type = &invalidType{}
+
+	Bool   = &Int{Bits: 1}
+	I8     = &Int{Bits: 8}
+	I32    = &Int{Bits: 32}
+	I64    = &Int{Bits: 64}
+	F64    = &Float{Bits: 64}
+	VoidT  = &Void{}
+	String = &Pointer{Elem: I8}
+
+	UntypedInt   = &Untyped{kind: untypedInt}
+	UntypedFloat = &Untyped{kind: untypedFloat}
+	UntypedNull  = &Untyped{kind: untypedNull}
+)
+
+// basicType parses the names of the built-in types, like i32, u8 or f64.
+func basicType(name string) (Type, bool) {
+	switch name {
+	case "void", "":
+		return VoidT, true
+	case "f16":
+		return &Float{Bits: 16}, true
+	case "f32":
+		return &Float{Bits: 32}, true
+	case "f64":
+		return F64, true
+	case "f128":
+		return &Float{Bits: 128}, true
+	}
+	if len(name) < 2 || (name[0] != 'i' && name[0] != 'u') {
+		return nil, false
+	}
+	size, err := strconv.ParseUint(name[1:], 10, 32)
+	if err != nil || size == 0 {
+		return nil, false
+	}
+	return &Int{Bits: size, Unsigned: name[0] == 'u'}, true
+}
+
+// isInvalid reports whether checks involving t have to be skipped, because
+// t is unknown until a template is instantiated or could not be checked.
+func isInvalid(t Type) bool {
+	switch t := t.(type) {
+	case *invalidType, *TypeParam:
+		return true
+	case *Pointer:
+		return isInvalid(t.Elem)
+	case *Array:
+		return isInvalid(t.Elem)
+	}
+	return false
+}
+
+// isTypeParam reports whether t is a type parameter, or a pointer to or an
+// array of one.
+func isTypeParam(t Type) bool {
+	switch t := t.(type) {
+	case *TypeParam:
+		return true
+	case *Pointer:
+		return isTypeParam(t.Elem)
+	case *Array:
+		return isTypeParam(t.Elem)
+	}
+	return false
+}
+
+// isUnchecked reports whether t is the type of an expression that could not
+// be checked, or a pointer to or an array of one.
+func isUnchecked(t Type) bool {
+	return isInvalid(t) && !isTypeParam(t)
+}
+
+func isUntyped(t Type) bool {
+	_, ok := t.(*Untyped)
+	return ok
+}
+
+func isBool(t Type) bool {
+	i, ok := t.(*Int)
+	return ok && i.Bits == 1
+}
+
+// isInteger reports whether t is an integer, which includes plain enums.
+func isInteger(t Type) bool {
+	switch t := t.(type) {
+	case *Int:
+		return true
+	case *Enum:
+		return !t.Tagged()
+	case *Untyped:
+		return t.kind == untypedInt
+	}
+	return false
+}
+
+func isNumeric(t Type) bool {
+	switch t := t.(type) {
+	case *Float:
+		return true
+	case *Untyped:
+		return t.kind != untypedNull
+	}
+	return isInteger(t)
+}
+
+// isString reports whether t is *i8, the type of string literals.
+func isString(t Type) bool {
+	p, ok := t.(*Pointer)
+	if !ok {
+		return false
+	}
+	i, ok := p.Elem.(*Int)
+	return ok && i.Bits == 8
+}
+
+// underlying returns the type values of type t are compiled to. Plain enums
+// are i32 integers.
+func underlying(t Type) Type {
+	if e, ok := t.(*Enum); ok && !e.Tagged() {
+		return I32
+	}
+	return t
+}
+
+// classOf returns the class t is, or points to.
+func classOf(t Type) (*Class, bool) {
+	if p, ok := t.(*Pointer); ok {
+		t = p.Elem
+	}
+	c, ok := t.(*Class)
+	return c, ok
+}
+
+// Identical reports whether a and b are the same type. Integers of the same
+// size are the same type to LLVM, so they are identical regardless of their
+// signedness, unless one of them is an enum.
+func Identical(a, b Type) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *Int:
+		b, ok := b.(*Int)
+		return ok && a.Bits == b.Bits
+	case *Float:
+		b, ok := b.(*Float)
+		return ok && a.Bits == b.Bits
+	case *Void:
+		_, ok := b.(*Void)
+		return ok
+	case *Pointer:
+		b, ok := b.(*Pointer)
+		return ok && Identical(a.Elem, b.Elem)
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && (a.Len == b.Len || a.Len < 0 || b.Len < 0) && Identical(a.Elem, b.Elem)
+	case *Tuple:
+		b, ok := b.(*Tuple)
+		if !ok || len(a.Types) != len(b.Types) {
+			return false
+		}
+		for i := range a.Types {
+			if !Identical(a.Types[i], b.Types[i]) {
+				return false
+			}
+		}
+		return true
+	case *Class:
+		b, ok := b.(*Class)
+		if !ok || a.decl != b.decl || len(a.Args) != len(b.Args) {
+			return false
+		}
+		for i := range a.Args {
+			if !Identical(a.Args[i], b.Args[i]) {
+				return false
+			}
+		}
+		return true
+	case *Signature:
+		b, ok := b.(*Signature)
+		if !ok || len(a.Params) != len(b.Params) || a.Variadic != b.Variadic || !Identical(a.Result, b.Result) {
+			return false
+		}
+		for i := range a.Params {
+			if !Identical(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return true
+	case *Interface:
+		b, ok := b.(*Interface)
+		return ok && a.decl == b.decl
+	case *Enum:
+		b, ok := b.(*Enum)
+		return ok && a.decl == b.decl
+	case *TypeParam:
+		b, ok := b.(*TypeParam)
+		return ok && a.Name == b.Name
+	}
+	return false
+}
+
+// Default returns the type an untyped literal of type t gets when nothing
+// asks for a specific type, or t itself if it is typed.
+func Default(t Type) Type {
+	u, ok := t.(*Untyped)
+	if !ok {
+		return t
+	}
+	switch u.kind {
+	case untypedFloat:
+		return F64
+	case untypedNull:
+		return String
+	default:
+		return I64
+	}
+}
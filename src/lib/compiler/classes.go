@@ -0,0 +1,310 @@
+package compiler
+
+import (
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// Every class starts with a pointer to its vtable, followed by the fields of
+// its parent classes and finally its own fields. A subclass therefore starts
+// with the layout of its parent, so a pointer to it can be used as a pointer
+// to the parent. The vtable holds the implementation of every overridable
+// method, inherited methods keep the slot they were given in the parent.
+
+type classInfo struct {
+	parent string
+	// Name and implementation of the method in each vtable slot
+	methods []string
+	impls   []*ir.Func
+	vtable  *ir.Global
+	// Prefix of the names of the methods and vtable in object files
+	symbol string
+	// Fields and methods declared by the class itself, and whether they are private
+	members map[string]bool
+	statics map[string]bool
+	// Template and type arguments of instances of generic classes
+	template string
+	typeArgs []types.Type
+}
+
+// declareClass declares the type, fields, methods and vtable of class c of
+// package pkg, which is known as name in this file. Method bodies are compiled
+// separately.
+func (ctx *Context) declareClass(c *parser.ClassDefinition, name string, pkg string) (*types.StructType, error) {
+	classType := types.NewStruct(types.I8Ptr)
+	classType.SetName(name)
+	ctx.Context.structNames[classType] = name
+	ctx.Module.NewTypeDef(name, classType)
+	if c.Name != name && ctx.typeParams != nil {
+		// Classes imported under another name still refer to themselves by
+		// their own name
+		ctx.typeParams[c.Name] = classType
+	}
+
+	info := &classInfo{parent: c.Extends, symbol: mangle(pkg, c.Name), members: make(map[string]bool), statics: make(map[string]bool)}
+	var fields []*parser.FieldDefinition
+	if c.Extends != "" {
+		parent, ok := ctx.classes[c.Extends]
+		if !ok {
+			return nil, posError(c.Pos, "Class %s extends unknown class %s", name, c.Extends)
+		}
+		parentType, _ := ctx.lookupClass(c.Extends)
+		classType.Fields = append(classType.Fields, parentType.(*types.StructType).Fields[1:]...)
+		fields = append(fields, ctx.StructFields[c.Extends]...)
+		info.methods = append(info.methods, parent.methods...)
+		info.impls = append(info.impls, parent.impls...)
+	}
+
+	for _, s := range c.Body {
+		if s.FieldDefinition != nil {
+			if s.FieldDefinition.Static {
+				// Static fields are globals, see staticFields
+				info.members[s.FieldDefinition.Name] = s.FieldDefinition.Private
+				info.statics[s.FieldDefinition.Name] = true
+				continue
+			}
+			classType.Fields = append(classType.Fields, ctx.CFTypeToLLType(s.FieldDefinition.Type))
+			fields = append(fields, s.FieldDefinition)
+			info.members[s.FieldDefinition.Name] = s.FieldDefinition.Private
+		} else if s.FunctionDefinition != nil {
+			f := s.FunctionDefinition
+			fn := ctx.declareClassMethod(f, info.symbol, name, classType)
+			info.members[strings.TrimPrefix(methodSuffix(f.Name), ".")] = f.Private
+			info.statics[f.Name.Name] = f.Static
+			if f.Name.Op || f.Name.Get || f.Name.Set || f.Static || f.Name.Name == "constructor" {
+				continue
+			}
+
+			// Private methods can only be called by the class itself, so they
+			// are never overridden and stay out of the vtable. The checker
+			// makes sure overrides are callable like the method they replace
+			slot := info.slot(f.Name.Name)
+			if f.Private {
+				continue
+			}
+			if slot < 0 {
+				info.methods = append(info.methods, f.Name.Name)
+				info.impls = append(info.impls, fn)
+				continue
+			}
+			info.impls[slot] = fn
+		}
+	}
+	ctx.StructFields[name] = fields
+	ctx.classes[name] = info
+
+	slots := make([]constant.Constant, len(info.impls))
+	for i, impl := range info.impls {
+		slots[i] = constant.NewBitCast(impl, types.I8Ptr)
+	}
+	info.vtable = ctx.Module.NewGlobalDef(info.symbol+".vtable", constant.NewArray(types.NewArray(uint64(len(slots)), types.I8Ptr), slots...))
+	info.vtable.Linkage = enum.LinkageLinkOnceODR
+	info.vtable.Immutable = true
+
+	return classType, nil
+}
+
+func (info *classInfo) slot(method string) int {
+	for i, m := range info.methods {
+		if m == method {
+			return i
+		}
+	}
+	return -1
+}
+
+// memberOwner returns the class declaring member, which is either class or
+// one of its parents.
+func (ctx *Context) memberOwner(class string, member string) (string, bool) {
+	for class != "" {
+		info, ok := ctx.classes[class]
+		if !ok {
+			break
+		}
+		if _, ok := info.members[member]; ok {
+			return class, true
+		}
+		class = info.parent
+	}
+	return "", false
+}
+
+// privateMember returns the class declaring member if it is private, or an
+// empty string otherwise.
+func (ctx *Context) privateMember(class string, member string) string {
+	owner, ok := ctx.memberOwner(class, member)
+	if !ok || !ctx.classes[owner].members[member] {
+		return ""
+	}
+	return owner
+}
+
+// staticMember reports whether member of class is a static field or method.
+func (ctx *Context) staticMember(class string, member string) bool {
+	owner, ok := ctx.memberOwner(class, member)
+	return ok && ctx.classes[owner].statics[member]
+}
+
+// staticFields returns the globals holding the static fields of class c. They
+// are named after the class and the field, e.g. Counter.count.
+func staticFields(c *parser.ClassDefinition) []*parser.VariableDefinition {
+	var globals []*parser.VariableDefinition
+	for _, s := range c.Body {
+		if s.FieldDefinition == nil || !s.FieldDefinition.Static {
+			continue
+		}
+		globals = append(globals, &parser.VariableDefinition{
+			Pos:        s.FieldDefinition.Pos,
+			Constant:   "var",
+			Name:       c.Name + "." + s.FieldDefinition.Name,
+			Type:       s.FieldDefinition.Type,
+			Assignment: s.FieldDefinition.Assignment,
+		})
+	}
+	return globals
+}
+
+// resolveStatic rewrites an identifier starting with a static field, like
+// Counter.count.value, so it starts with the global holding the field. Other
+// identifiers are returned unchanged.
+func (ctx *Context) resolveStatic(i *parser.Identifier) (*parser.Identifier, error) {
+	if i.Sub == nil || i.GEP != nil || ctx.lookupVariable(i.Name) != nil {
+		return i, nil
+	}
+	if _, ok := ctx.classes[i.Name]; !ok || !ctx.staticMember(i.Name, i.Sub.Name) {
+		return i, nil
+	}
+	owner, _ := ctx.memberOwner(i.Name, i.Sub.Name)
+	if ctx.lookupVariable(owner+"."+i.Sub.Name) == nil {
+		// A static method
+		return i, nil
+	}
+	if err := ctx.checkClassAccess(i.Sub.Pos, i.Name, i.Sub.Name); err != nil {
+		return nil, err
+	}
+	resolved := *i.Sub
+	resolved.Name = owner + "." + i.Sub.Name
+	resolved.Ref = i.Ref
+	resolved.Deref = i.Deref
+	return &resolved, nil
+}
+
+// checkAccess makes sure member of the class typ is, or points to, is
+// accessible from the current context. Private members can only be accessed
+// by the methods of the class declaring them.
+func (ctx *Context) checkAccess(pos lexer.Position, typ types.Type, member string) error {
+	class, ok := ctx.classOf(typ)
+	if !ok {
+		return nil
+	}
+	return ctx.checkClassAccess(pos, class, member)
+}
+
+func (ctx *Context) checkClassAccess(pos lexer.Position, class string, member string) error {
+	if owner := ctx.privateMember(class, member); owner != "" && ctx.class != owner {
+		return posError(pos, "Cannot access private member %s of class %s", member, owner)
+	}
+	return nil
+}
+
+// exportedClass returns class c as seen by the files importing it. Private
+// methods are left out, private fields are kept since they are part of the
+// layout of the class.
+func exportedClass(c *parser.ClassDefinition) *parser.ClassDefinition {
+	exported := *c
+	exported.Body = nil
+	for _, s := range c.Body {
+		if s.FunctionDefinition != nil && s.FunctionDefinition.Private {
+			continue
+		}
+		exported.Body = append(exported.Body, s)
+	}
+	return &exported
+}
+
+// classOf returns the name of the class typ is, or points to.
+func (ctx *Context) classOf(typ types.Type) (string, bool) {
+	if ptrType, ok := typ.(*types.PointerType); ok {
+		typ = ptrType.ElemType
+	}
+	structType, ok := typ.(*types.StructType)
+	if !ok {
+		return "", false
+	}
+	name, ok := ctx.Context.structNames[structType]
+	if !ok {
+		return "", false
+	}
+	_, ok = ctx.classes[name]
+	return name, ok
+}
+
+// vtablePointer returns the vtable pointer a new instance of class starts with.
+func (ctx *Context) vtablePointer(class string) constant.Constant {
+	return constant.NewBitCast(ctx.classes[class].vtable, types.I8Ptr)
+}
+
+// initInstance stores the vtable pointer of class in the instance at ptr.
+func (ctx *Context) initInstance(ptr value.Value, classType *types.StructType) {
+	class, ok := ctx.classOf(classType)
+	if !ok {
+		return
+	}
+	vptr := ctx.NewGetElementPtr(classType, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
+	ctx.NewStore(ctx.vtablePointer(class), vptr)
+}
+
+// newInstance allocates a zeroed instance of classType on the heap. Instances
+// created with new can be returned or stored anywhere, so they can not live in
+// the stack frame of the function creating them.
+func (ctx *Context) newInstance(classType *types.StructType) value.Value {
+	calloc, ok := ctx.lookupFunction("calloc")
+	if !ok {
+		calloc = ctx.Module.NewFunc("calloc", types.I8Ptr, ir.NewParam("count", types.I64), ir.NewParam("size", types.I64))
+	}
+	// The size of the class is the offset of the element after the first one
+	ptrType := types.NewPointer(classType)
+	size := constant.NewPtrToInt(constant.NewGetElementPtr(classType, constant.NewNull(ptrType), constant.NewInt(types.I32, 1)), types.I64)
+	mem := ctx.NewCall(calloc, constant.NewInt(types.I64, 1), size)
+	return ctx.NewBitCast(mem, ptrType)
+}
+
+// zeroInstance returns a constant instance of classType with all fields set
+// to zero, except for the vtable pointer.
+func (ctx *Context) zeroInstance(classType *types.StructType) constant.Constant {
+	class, ok := ctx.classOf(classType)
+	if !ok {
+		return constant.NewZeroInitializer(classType)
+	}
+	fields := []constant.Constant{ctx.vtablePointer(class)}
+	for _, field := range classType.Fields[1:] {
+		fields = append(fields, constant.NewZeroInitializer(field))
+	}
+	return constant.NewStruct(classType, fields...)
+}
+
+// virtualMethod loads the implementation of method from the vtable of the
+// instance, so calls through a pointer reach the override of its real class.
+func (ctx *Context) virtualMethod(instance value.Value, method *ir.Func, name string) value.Value {
+	class, ok := ctx.classOf(instance.Type())
+	if !ok {
+		return method
+	}
+	slot := ctx.classes[class].slot(name)
+	if slot < 0 {
+		return method
+	}
+
+	classType := instance.Type().(*types.PointerType).ElemType
+	vptr := ctx.NewLoad(types.I8Ptr, ctx.NewGetElementPtr(classType, instance, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0)))
+	vtable := ctx.NewBitCast(vptr, types.NewPointer(types.I8Ptr))
+	impl := ctx.NewLoad(types.I8Ptr, ctx.NewGetElementPtr(types.I8Ptr, vtable, constant.NewInt(types.I32, int64(slot))))
+	return ctx.NewBitCast(impl, types.NewPointer(method.Sig))
+}
@@ -0,0 +1,161 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// globalIR returns the IR of the global name of the module compiled by c.
+func globalIR(t *testing.T, c *Compiler, name string) string {
+	t.Helper()
+	for _, g := range c.Module.Globals {
+		if g.Name() == name {
+			return g.LLString()
+		}
+	}
+	t.Fatalf("global %s was not compiled", name)
+	return ""
+}
+
+const animals = `package main;
+class Animal {
+  legs: i64;
+  func sound(): i64 { return 0; }
+  func describe(): i64 { return this.sound() + this.legs; }
+}
+class Dog extends Animal {
+  func sound(): i64 { return 1; }
+}
+class Puppy extends Dog {
+  func sound(): i64 { return super.sound() + 1; }
+}
+func describe(a: *Animal): i64 { return a.describe(); }
+`
+
+func TestVirtualMethods(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": animals + `
+func main(): i32 {
+  var d: *Animal = new Dog();
+  var p: *Dog = new Puppy();
+  var n: i64 = describe(d) + p.sound();
+  return 0;
+}
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Overrides replace the slot of the parent, inherited methods keep it
+	for class, impls := range map[string][]string{
+		"Animal": {"@main.Animal.sound", "@main.Animal.describe"},
+		"Dog":    {"@main.Dog.sound", "@main.Animal.describe"},
+		"Puppy":  {"@main.Puppy.sound", "@main.Animal.describe"},
+	} {
+		vtable := globalIR(t, c, "main."+class+".vtable")
+		if strings.Index(vtable, impls[0]) < 0 || strings.Index(vtable, impls[0]) > strings.Index(vtable, impls[1]) {
+			t.Errorf("expected the vtable of %s to hold %v in order:\n%s", class, impls, vtable)
+		}
+	}
+
+	// Calls through a pointer load the method from the vtable, calls of
+	// super call the method of the parent directly
+	if ir := funcIR(t, c, "main.describe"); strings.Contains(ir, "@main.Animal.describe(") {
+		t.Errorf("expected describe to be called through the vtable:\n%s", ir)
+	}
+	if ir := funcIR(t, c, "main.Puppy.sound"); !strings.Contains(ir, "call i64 @main.Dog.sound(") {
+		t.Errorf("expected super.sound to call Dog.sound:\n%s", ir)
+	}
+}
+
+func TestOverrideMismatch(t *testing.T) {
+	diags := compileErrors(t, `package main;
+class Base {
+  func f(a: i64): i64 { return a; }
+}
+class Bad extends Base {
+  func f(a: f64): i64 { return 1; }
+}
+func main(): i32 {
+  var b: *Base = new Bad();
+  var n: i64 = b.f(1);
+  return 0;
+}
+`)
+	// The class is still usable, so only the override is reported
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "does not match the signature of the method it overrides") {
+		t.Fatalf("expected only the mismatched override to be reported, got %s", diags)
+	}
+	if diags[0].Span.Line != 6 {
+		t.Errorf("expected the error at the override, got %s", diags[0].Span)
+	}
+}
+
+const counters = `package main;
+class Counter {
+  static count: i64;
+  id: i64;
+  func constructor(id: i64) { this.id = id; Counter.count = Counter.count + 1; }
+  static func total(): i64 { return Counter.count; }
+}
+`
+
+func TestNewAllocatesOnHeap(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": counters + `
+func make(): *Counter { return new Counter(3); }
+func main(): i32 { return 0; }
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Instances outlive the function creating them
+	ir := funcIR(t, c, "main.make")
+	if !strings.Contains(ir, "call i8* @calloc(") || strings.Contains(ir, "alloca %Counter") {
+		t.Errorf("expected the instance to be allocated on the heap:\n%s", ir)
+	}
+	if !strings.Contains(ir, "call void @main.Counter.constructor(%Counter* %2, i64 3)") {
+		t.Errorf("expected the constructor to be called on the instance:\n%s", ir)
+	}
+}
+
+func TestStaticMembers(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": counters + `
+func main(): i32 {
+  var c: *Counter = new Counter(1);
+  var n: i64 = Counter.total() + Counter.count;
+  return 0;
+}
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Static fields are globals and static methods take no instance
+	if g := globalIR(t, c, "main.Counter.count"); !strings.Contains(g, "global i64") {
+		t.Errorf("expected count to be a global:\n%s", g)
+	}
+	if ir := funcIR(t, c, "main.Counter.total"); !strings.HasPrefix(ir, "define i64 @main.Counter.total()") {
+		t.Errorf("expected total to take no arguments:\n%s", ir)
+	}
+	if ir := funcIR(t, c, "main"); !strings.Contains(ir, "call i64 @main.Counter.total()") || !strings.Contains(ir, "load i64, i64* @main.Counter.count") {
+		t.Errorf("expected main to call total and read count directly:\n%s", ir)
+	}
+}
+
+func TestStaticMisuse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"static method on an instance", "var c: *Counter = new Counter(1); var n: i64 = c.total();", "Static method total must be called on class Counter, not on an instance"},
+		{"instance method on the class", "Counter.constructor(1);", "Method constructor of class Counter is not static, it must be called on an instance"},
+		{"missing static field", "var n: i64 = Counter.id;", "Static field id not found in class Counter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := compileErrors(t, counters+"func main(): i32 {\n"+tt.body+"\nreturn 0;\n}\n")
+			if len(diags) != 1 || diags[0].Message != tt.message {
+				t.Errorf("expected %q, got %s", tt.message, diags)
+			}
+		})
+	}
+}
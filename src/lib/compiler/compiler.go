@@ -1,40 +1,55 @@
 package compiler
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/fatih/color"
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
-	"github.com/urfave/cli/v2"
 	"github.com/vyPal/CaffeineC/lib/cache"
+	"github.com/vyPal/CaffeineC/lib/checker"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
 	"github.com/vyPal/CaffeineC/lib/parser"
 )
 
 type Context struct {
 	*ir.Block
 	*Compiler
-	parent        *Context
-	vars          map[string]*Variable
-	structNames   map[*types.StructType]string
-	fc            *FlowControl
-	RequestedType types.Type
-	DestPtr       value.Value
-	StoredInDest  bool
+	parent      *Context
+	vars        map[string]*Variable
+	structNames map[*types.StructType]string
+	typeParams  map[string]types.Type
+	// Package of the template being compiled, nil for the package being
+	// compiled
+	scope *packageScope
+	// Class whose methods are being compiled
+	class   string
+	fc      *FlowControl
+	cleanup *Cleanup
 }
 
 type Variable struct {
 	Name  string
 	Type  types.Type
 	Value value.Value
+	// Where the variable was declared, if it was declared in source code
+	Pos lexer.Position
+	// Whether the variable is a constant, which can not be assigned to
+	Constant bool
 }
 
 type FlowControl struct {
 	Leave    *ir.Block
 	Continue *ir.Block
+	// Cleanups that were active when Leave and Continue were set
+	LeaveCleanup    *Cleanup
+	ContinueCleanup *Cleanup
 }
 
 func NewContext(b *ir.Block, comp *Compiler) *Context {
@@ -51,10 +66,24 @@ func NewContext(b *ir.Block, comp *Compiler) *Context {
 func (c *Context) NewContext(b *ir.Block) *Context {
 	ctx := NewContext(b, c.Compiler)
 	ctx.parent = c
+	ctx.cleanup = c.cleanup
+	ctx.typeParams = c.typeParams
+	ctx.scope = c.scope
+	ctx.class = c.class
+	// Copy the flow control so nested blocks can still break out of
+	// loops without changing the targets of the parent
+	fc := *c.fc
+	ctx.fc = &fc
 	return ctx
 }
 
 func (c Context) lookupVariable(name string) *Variable {
+	for ctx := &c; ctx != nil; ctx = ctx.parent {
+		if v, ok := ctx.vars[name]; ok {
+			return v
+		}
+	}
+	// Parameters that are not stored on the stack, like this
 	if c.Block != nil && c.Block.Parent != nil {
 		for _, param := range c.Block.Parent.Params {
 			if param.Name() == name {
@@ -66,32 +95,112 @@ func (c Context) lookupVariable(name string) *Variable {
 			}
 		}
 	}
-	if v, ok := c.vars[name]; ok {
-		return v
-	} else if c.parent != nil {
-		v := c.parent.lookupVariable(name)
+	if v, ok := c.Compiler.Context.vars[name]; ok {
+		// Globals are visible from every function
 		return v
-	} else {
-		cli.Exit(color.RedString("Error: Unable to find a variable named: %s", name), 1)
 	}
 	return nil
 }
 
 func (c *Context) lookupFunction(name string) (*ir.Func, bool) {
+	if c.scope != nil {
+		if fn, ok := c.scopeFunction(name); ok {
+			return fn, true
+		}
+	}
 	fn, ok := c.Compiler.SymbolTable[name]
 	if ok {
 		return fn.(*ir.Func), true
-	} else {
-		for _, f := range c.Module.Funcs {
-			if f.Name() == name {
-				return f, true
-			}
+	}
+	return c.moduleFunc(name)
+}
+
+// moduleFunc returns the function of the module with the symbol name.
+func (c *Compiler) moduleFunc(name string) (*ir.Func, bool) {
+	for _, f := range c.Module.Funcs {
+		if f.Name() == name {
+			return f, true
 		}
 	}
+	return nil, false
+}
 
+// scopeFunction returns the function name defined at the top level of the
+// package of the template being compiled, declaring it on first use.
+func (c *Context) scopeFunction(name string) (*ir.Func, bool) {
+	pkg := c.scope.ast.Package
+	dctx := NewContext(nil, c.Compiler)
+	dctx.typeParams = c.scope.types
+	for _, s := range c.scope.ast.Statements {
+		if s.Export != nil {
+			s = s.Export
+		}
+		if f := s.FunctionDefinition; f != nil && len(f.TypeParams) == 0 && f.Name.Name == name {
+			if fn, ok := c.moduleFunc(functionSymbol(pkg, f)); ok {
+				return fn, true
+			}
+			var params []*ir.Param
+			for _, p := range f.Parameters {
+				params = append(params, ir.NewParam(p.Name, dctx.CFTypeToLLType(p.Type)))
+			}
+			if f.Variadic != "" {
+				params = append(params, ir.NewParam(f.Variadic, types.I8Ptr))
+			}
+			fn := c.Module.NewFunc(functionSymbol(pkg, f), dctx.CFMultiTypeToLLType(f.ReturnType), params...)
+			fn.Sig.Variadic = f.Variadic != ""
+			return fn, true
+		} else if f := s.External; f != nil && strings.Trim(f.Name, "\"") == name {
+			if fn, ok := c.moduleFunc(f.Name); ok {
+				return fn, true
+			}
+			var params []*ir.Param
+			for _, p := range f.Parameters {
+				params = append(params, ir.NewParam(p.Name, dctx.CFTypeToLLType(p.Type)))
+			}
+			fn := c.Module.NewFunc(f.Name, dctx.CFMultiTypeToLLType(f.ReturnType), params...)
+			fn.Sig.Variadic = f.Variadic
+			return fn, true
+		}
+	}
 	return nil, false
 }
 
+// pkg returns the name of the package of the code being compiled.
+func (c *Context) pkg() string {
+	if c.scope != nil {
+		return c.scope.ast.Package
+	}
+	return c.AST.Package
+}
+
+// declareName records that the class, interface or enum defined by def is
+// known as name in this file. Types imported twice keep their first name.
+func (c *Compiler) declareName(def interface{}, name string) {
+	if _, ok := c.declNames[def]; !ok {
+		c.declNames[def] = name
+	}
+}
+
+// declaredName returns the name the class, interface or enum defined by def
+// is known as in this file. Types that were not declared under any name, like
+// private classes used by an imported template, keep the name they are
+// defined with.
+func (c *Compiler) declaredName(def interface{}, name string) string {
+	if declared, ok := c.declNames[def]; ok {
+		return declared
+	}
+	return name
+}
+
+// lookupType returns the class or interface name, which templates may refer
+// to by the name their package gives it.
+func (c *Context) lookupType(name string) (types.Type, bool) {
+	if typ, ok := c.typeParams[name]; ok {
+		return typ, true
+	}
+	return c.lookupClass(name)
+}
+
 func (c Context) lookupClass(name string) (types.Type, bool) {
 	for _, s := range c.Module.TypeDefs {
 		if s.Name() == name {
@@ -101,6 +210,93 @@ func (c Context) lookupClass(name string) (types.Type, bool) {
 	return nil, false
 }
 
+// variableNames returns the names of the variables visible from c, to suggest
+// when an unknown variable is used.
+func (c Context) variableNames() []string {
+	var names []string
+	if c.Block != nil && c.Block.Parent != nil {
+		for _, param := range c.Block.Parent.Params {
+			names = append(names, param.Name())
+		}
+	}
+	for ctx := &c; ctx != nil; ctx = ctx.parent {
+		for name := range ctx.vars {
+			names = append(names, name)
+		}
+	}
+	for name := range c.Compiler.Context.vars {
+		names = append(names, name)
+	}
+	return names
+}
+
+// functionNames returns the names of the functions that can be called.
+func (c *Context) functionNames() []string {
+	var names []string
+	for name, fn := range c.SymbolTable {
+		if _, ok := fn.(*ir.Func); !ok {
+			continue
+		}
+		// Skip methods, which are named after their class
+		if i := strings.Index(name, "."); i >= 0 {
+			if _, ok := c.classes[name[:i]]; ok {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	for name := range c.genericFuncs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// classNames returns the names of the classes that can be instantiated.
+func (c *Context) classNames() []string {
+	var names []string
+	for name, info := range c.classes {
+		if info.template == "" {
+			names = append(names, name)
+		}
+	}
+	for name := range c.genericClasses {
+		names = append(names, name)
+	}
+	return names
+}
+
+// typeNames returns the names of the types that can be used.
+func (c *Context) typeNames() []string {
+	names := append(c.classNames(), "i8", "i16", "i32", "i64", "u8", "u16", "u32", "u64", "f32", "f64", "void")
+	for name := range c.interfaces {
+		names = append(names, name)
+	}
+	for name := range c.enums {
+		names = append(names, name)
+	}
+	for name := range c.typeParams {
+		names = append(names, name)
+	}
+	return names
+}
+
+// memberNames returns the names of the fields and methods of class and the
+// classes it extends.
+func (c *Context) memberNames(class string) []string {
+	var names []string
+	for class != "" {
+		info, ok := c.classes[class]
+		if !ok {
+			break
+		}
+		for name := range info.members {
+			names = append(names, name)
+		}
+		class = info.parent
+	}
+	return names
+}
+
 type Compiler struct {
 	Module          *ir.Module
 	SymbolTable     map[string]value.Value
@@ -110,6 +306,30 @@ type Compiler struct {
 	workingDir      string
 	RequiredImports []string
 	PackageCache    cache.PackageCache
+	exceptions      *exceptionRuntime
+	init            *Context
+	classes         map[string]*classInfo
+	interfaces      map[string]*interfaceInfo
+	enums           map[string]*enumInfo
+	genericFuncs    map[string]*genericFunc
+	genericClasses  map[string]*genericClass
+	// Aliases of the modules imported with import "path" as alias
+	modules map[string]bool
+	// Import statements of the program, which FindImports removes from AST
+	imports []*parser.Statement
+	// Imported files by path, parsed once for the checker and the compiler
+	parsed map[string]*parser.Program
+	// Imported globals whose type or value is inferred by the checker
+	inferredGlobals []importedGlobal
+	// Types the checker gave the program and the implicit conversions it
+	// found, which the program is compiled with
+	Info *checker.Info
+	// Names classes, interfaces and enums are known as in this file, by
+	// their definition, to find the types the checker refers to
+	declNames map[interface{}]string
+	// Statements instructions were generated for
+	positions   map[value.User]lexer.Position
+	Diagnostics diagnostics.List
 }
 
 func NewCompiler() *Compiler {
@@ -118,6 +338,15 @@ func NewCompiler() *Compiler {
 		SymbolTable:     make(map[string]value.Value),
 		StructFields:    make(map[string][]*parser.FieldDefinition),
 		RequiredImports: make([]string, 0),
+		classes:         make(map[string]*classInfo),
+		interfaces:      make(map[string]*interfaceInfo),
+		enums:           make(map[string]*enumInfo),
+		genericFuncs:    make(map[string]*genericFunc),
+		genericClasses:  make(map[string]*genericClass),
+		modules:         make(map[string]bool),
+		parsed:          make(map[string]*parser.Program),
+		declNames:       make(map[interface{}]string),
+		positions:       make(map[value.User]lexer.Position),
 	}
 }
 
@@ -133,14 +362,125 @@ func (c *Compiler) Init(program *parser.Program, workingDir string) {
 	}
 }
 
-func (c *Compiler) Compile() (err error) {
-	for _, s := range c.AST.Statements {
-		err := c.Context.compileStatement(s)
-		if err != nil {
-			return err
+// Compile checks the program and compiles it into the module. Errors do not
+// stop the compilation, they are collected in Diagnostics and returned
+// together. Programs the checker rejects are not compiled.
+func (c *Compiler) Compile() error {
+	program := &parser.Program{Package: c.AST.Package, Statements: append(append([]*parser.Statement{}, c.imports...), c.AST.Statements...)}
+	info, err := checker.Check(program, c.parseImport)
+	if err != nil {
+		c.report(err)
+		return c.Diagnostics.Err()
+	}
+	c.Info = info
+	for _, g := range c.inferredGlobals {
+		g.ctx.importGlobalVariable(g.v, g.name, g.pkg)
+	}
+
+	c.Context.compileBody(c.AST.Statements)
+	c.finishModuleInit()
+	// Errors leave the module incomplete, it is only valid without them
+	if len(c.Diagnostics) == 0 {
+		c.report(c.Verify())
+	}
+	return c.Diagnostics.Err()
+}
+
+// CheckPartial checks a program recovered from a file with syntax errors,
+// which can not be compiled. Its errors are reported in Diagnostics.
+func (c *Compiler) CheckPartial() error {
+	program := &parser.Program{Package: c.AST.Package, Statements: append(append([]*parser.Statement{}, c.imports...), c.AST.Statements...)}
+	_, err := checker.CheckPartial(program, c.parseImport)
+	c.report(err)
+	return c.Diagnostics.Err()
+}
+
+// report records err as a diagnostic of the compiled file.
+func (c *Compiler) report(err error) {
+	c.Diagnostics.Report(err)
+}
+
+// compileBody compiles a list of statements. A statement failing to compile
+// is reported and skipped, so the errors of all statements are found at once.
+// It reports whether every statement compiled.
+func (ctx *Context) compileBody(stmts []*parser.Statement) bool {
+	ok := true
+	for _, s := range stmts {
+		mark := ctx.markPositions()
+		if err := ctx.compileStatementRecover(s); err != nil {
+			ctx.report(err)
+			ok = false
 		}
+		ctx.recordPositions(mark, s.Pos)
 	}
-	return nil
+	return ok
+}
+
+// compileStatementRecover compiles s, turning a panic of the compiler into an
+// error at the position of s.
+func (ctx *Context) compileStatementRecover(s *parser.Statement) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = diagnostics.Errorf(diagnostics.CodeInternal, diagnostics.At(s.Pos), "Internal compiler error: %v", r)
+		}
+	}()
+	return ctx.compileStatement(s)
+}
+
+// mangle returns the name of the symbol name of package pkg in object files.
+// Symbols are prefixed with their package so packages can use the same names,
+// only the main function keeps its name for the C runtime to find it.
+func mangle(pkg string, name string) string {
+	if pkg == "main" && name == "main" {
+		return name
+	}
+	return pkg + "." + name
+}
+
+// functionSymbol returns the name of the function f of package pkg in object
+// files. Functions defined as extern keep their name, so they can be called
+// from C.
+func functionSymbol(pkg string, f *parser.FunctionDefinition) string {
+	if f.Extern {
+		return f.Name.Name
+	}
+	return mangle(pkg, f.Name.Name)
+}
+
+// moduleInit returns the context of the function initializing globals whose
+// value is not known at compile time, creating it on first use.
+func (c *Context) moduleInit() *Context {
+	if c.Compiler.init == nil {
+		fn := c.Module.NewFunc("cffc.init", types.Void)
+		fn.Linkage = enum.LinkageInternal
+		c.Compiler.init = c.Compiler.Context.NewContext(fn.NewBlock(""))
+	}
+	return c.Compiler.init
+}
+
+// finishModuleInit terminates the module initializer and registers it as a
+// global constructor, or drops it if there was nothing to initialize.
+func (c *Compiler) finishModuleInit() {
+	if c.init == nil {
+		return
+	}
+	fn := c.init.Block.Parent
+	if len(fn.Blocks) == 1 && len(fn.Blocks[0].Insts) == 0 {
+		for i, f := range c.Module.Funcs {
+			if f == fn {
+				c.Module.Funcs = append(c.Module.Funcs[:i], c.Module.Funcs[i+1:]...)
+				break
+			}
+		}
+		c.init = nil
+		return
+	}
+	c.init.NewRet(nil)
+
+	ctorType := types.NewStruct(types.I32, types.NewPointer(fn.Sig), types.I8Ptr)
+	ctor := constant.NewStruct(ctorType, constant.NewInt(types.I32, 65535), fn, constant.NewNull(types.I8Ptr))
+	ctors := c.Module.NewGlobalDef("llvm.global_ctors", constant.NewArray(types.NewArray(1, ctorType), ctor))
+	ctors.Linkage = enum.LinkageAppending
 }
 
 func (c *Compiler) ListImportedFiles() (requiredImports []string, err error) {
@@ -180,22 +520,25 @@ func (c *Compiler) ListImportedFiles() (requiredImports []string, err error) {
 	return requiredImports, nil
 }
 
+// FindImports imports the files imported by the program. Every import is
+// attempted, the errors of all of them are returned together.
 func (c *Compiler) FindImports() error {
 	for i := len(c.AST.Statements) - 1; i >= 0; i-- {
 		s := c.AST.Statements[i]
+		var err error
 		if s.Import != nil {
-			err := c.ImportAll(s.Import.Package, c.Context)
-			if err != nil {
-				return err
+			if s.Import.Alias != "" {
+				err = c.ImportModule(s.Import.Package, s.Import.Alias, c.Context)
+			} else {
+				err = c.ImportAll(s.Import.Package, c.Context)
 			}
-			c.AST.Statements = append(c.AST.Statements[:i], c.AST.Statements[i+1:]...)
 		} else if s.FromImport != nil {
-			symbols := map[string]string{strings.Trim(s.FromImport.Symbol, "\""): strings.Trim(s.FromImport.Symbol, "\"")}
-			err := c.ImportAs(s.FromImport.Package, symbols, c.Context)
-			if err != nil {
-				return err
+			alias := s.FromImport.Alias
+			if alias == "" {
+				alias = s.FromImport.Symbol
 			}
-			c.AST.Statements = append(c.AST.Statements[:i], c.AST.Statements[i+1:]...)
+			symbols := map[string]string{strings.Trim(s.FromImport.Symbol, "\""): strings.Trim(alias, "\"")}
+			err = c.ImportAs(s.FromImport.Package, symbols, c.Context)
 		} else if s.FromImportMultiple != nil {
 			symbols := map[string]string{}
 			for _, symbol := range s.FromImportMultiple.Symbols {
@@ -204,21 +547,59 @@ func (c *Compiler) FindImports() error {
 				}
 				symbols[strings.Trim(symbol.Name, "\"")] = strings.Trim(symbol.Alias, "\"")
 			}
-			err := c.ImportAs(s.FromImportMultiple.Package, symbols, c.Context)
-			if err != nil {
-				return err
-			}
-			c.AST.Statements = append(c.AST.Statements[:i], c.AST.Statements[i+1:]...)
+			err = c.ImportAs(s.FromImportMultiple.Package, symbols, c.Context)
+		} else {
+			continue
 		}
+		c.report(importError(s.Pos, err))
+		c.imports = append([]*parser.Statement{s}, c.imports...)
+		c.AST.Statements = append(c.AST.Statements[:i], c.AST.Statements[i+1:]...)
 	}
-	return nil
+	return c.Diagnostics.Err()
 }
 
-func (c *Compiler) ImportAll(path string, ctx *Context) error {
+// importError places err at the import statement at pos, unless it is a
+// diagnostic that already points into the imported file.
+func importError(pos lexer.Position, err error) error {
+	if err == nil {
+		return nil
+	}
+	var list diagnostics.List
+	if errors.As(err, &list) {
+		return err
+	}
+	var d *diagnostics.Diagnostic
+	if errors.As(err, &d) {
+		if d.Span.IsZero() {
+			d.Span = diagnostics.At(pos)
+		}
+		return d
+	}
+	return diagnostics.Errorf(diagnostics.CodeImport, diagnostics.At(pos), "%s", err)
+}
+
+// importFile parses the file imported as path and records it as required.
+func (c *Compiler) importFile(path string) (*parser.Program, error) {
+	ast, importpath, err := c.resolveImport(path)
+	if importpath != "" {
+		c.RequiredImports = append(c.RequiredImports, importpath)
+	}
+	return ast, err
+}
+
+// parseImport parses the file imported as path.
+func (c *Compiler) parseImport(path string) (*parser.Program, error) {
+	ast, _, err := c.resolveImport(path)
+	return ast, err
+}
+
+// resolveImport parses the file imported as path. It also returns the path
+// of the file the import is compiled from, once it is known.
+func (c *Compiler) resolveImport(path string) (*parser.Program, string, error) {
 	path = strings.Trim(path, "\"")
 	path, importpath, err := ResolveImportPath(path, c.PackageCache)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 	if !filepath.IsAbs(path) {
 		path = filepath.Clean(filepath.Join(c.workingDir, path))
@@ -226,61 +607,55 @@ func (c *Compiler) ImportAll(path string, ctx *Context) error {
 	if !filepath.IsAbs(importpath) {
 		importpath = filepath.Clean(filepath.Join(c.workingDir, importpath))
 	}
-	c.RequiredImports = append(c.RequiredImports, importpath)
 	info, err := os.Stat(path)
 	if err != nil {
-		return err
+		return nil, importpath, diagnostics.Errorf(diagnostics.CodeImport, diagnostics.Span{}, "Unable to import %s: %s", path, err)
 	}
 	if info.IsDir() {
-		return cli.Exit(color.RedString("Unable to import directory"), 1)
+		return nil, importpath, diagnostics.Errorf(diagnostics.CodeImport, diagnostics.Span{}, "Unable to import directory %s", path)
+	}
+	if ast, ok := c.parsed[path]; ok {
+		return ast, importpath, nil
+	}
+	ast, err := parser.ParseFile(path)
+	if err == nil {
+		c.parsed[path] = ast
+	}
+	return ast, importpath, err
+}
+
+func (c *Compiler) ImportAll(path string, ctx *Context) error {
+	ast, err := c.importFile(path)
+	if err != nil {
+		return err
 	}
-	ast := parser.ParseFile(path)
+	scope := &packageScope{ast: ast, types: ctx.typeParams}
 	for _, s := range ast.Statements {
 		if s.Export != nil {
-			if s.Export.FunctionDefinition != nil {
+			if s.Export.FunctionDefinition != nil && len(s.Export.FunctionDefinition.TypeParams) > 0 {
+				ctx.genericFuncs[s.Export.FunctionDefinition.Name.Name] = &genericFunc{FunctionDefinition: s.Export.FunctionDefinition, scope: scope}
+			} else if s.Export.FunctionDefinition != nil {
 				var params []*ir.Param
 				for _, p := range s.Export.FunctionDefinition.Parameters {
 					params = append(params, ir.NewParam(p.Name, ctx.CFTypeToLLType(p.Type)))
 				}
-				fn := c.Module.NewFunc(s.Export.FunctionDefinition.Name.Name, ctx.CFMultiTypeToLLType(s.Export.FunctionDefinition.ReturnType), params...)
+				fn := c.Module.NewFunc(functionSymbol(ast.Package, s.Export.FunctionDefinition), ctx.CFMultiTypeToLLType(s.Export.FunctionDefinition.ReturnType), params...)
 				if s.Export.FunctionDefinition.Variadic != "" {
 					fn.Sig.Variadic = true
 				}
 				ctx.SymbolTable[s.Export.FunctionDefinition.Name.Name] = fn
 
+			} else if s.Export.ClassDefinition != nil && len(s.Export.ClassDefinition.TypeParams) > 0 {
+				ctx.genericClasses[s.Export.ClassDefinition.Name] = &genericClass{ClassDefinition: s.Export.ClassDefinition, scope: scope}
+				c.declareName(s.Export.ClassDefinition, s.Export.ClassDefinition.Name)
 			} else if s.Export.ClassDefinition != nil {
-				cStruct := types.NewStruct()
-				cStruct.SetName(s.Export.ClassDefinition.Name)
-				ctx.Module.NewTypeDef(s.Export.ClassDefinition.Name, cStruct)
-				ctx.structNames[cStruct] = s.Export.ClassDefinition.Name
-				for _, st := range s.Export.ClassDefinition.Body {
-					if st.FieldDefinition != nil {
-						cStruct.Fields = append(cStruct.Fields, ctx.CFTypeToLLType(st.FieldDefinition.Type))
-						ctx.Compiler.StructFields[s.Export.ClassDefinition.Name] = append(ctx.Compiler.StructFields[s.Export.ClassDefinition.Name], st.FieldDefinition)
-					} else if st.FunctionDefinition != nil {
-						f := st.FunctionDefinition
-						var params []*ir.Param
-						params = append(params, ir.NewParam("this", types.NewPointer(cStruct)))
-						for _, arg := range f.Parameters {
-							params = append(params, ir.NewParam(arg.Name, ctx.CFTypeToLLType(arg.Type)))
-						}
-
-						ms := "." + f.Name.Name
-						if f.Name.Op {
-							ms = ".op." + strings.Trim(f.Name.Name, "\"")
-						} else if f.Name.Get {
-							ms = ".get." + strings.Trim(f.Name.Name, "\"")
-						} else if f.Name.Set {
-							ms = ".set." + strings.Trim(f.Name.Name, "\"")
-						}
-
-						fn := ctx.Module.NewFunc(s.Export.ClassDefinition.Name+ms, ctx.CFMultiTypeToLLType(f.ReturnType), params...)
-						if st.FunctionDefinition.Variadic != "" {
-							fn.Sig.Variadic = true
-						}
-
-						ctx.SymbolTable[s.Export.ClassDefinition.Name+ms] = fn
-					}
+				c.declareName(s.Export.ClassDefinition, s.Export.ClassDefinition.Name)
+				_, err := ctx.declareClass(exportedClass(s.Export.ClassDefinition), s.Export.ClassDefinition.Name, ast.Package)
+				if err != nil {
+					return err
+				}
+				for _, v := range staticFields(s.Export.ClassDefinition) {
+					ctx.importGlobalVariable(v, v.Name, ast.Package)
 				}
 			} else if s.Export.External != nil {
 				var params []*ir.Param
@@ -290,6 +665,20 @@ func (c *Compiler) ImportAll(path string, ctx *Context) error {
 				fn := c.Module.NewFunc(s.Export.External.Name, ctx.CFMultiTypeToLLType(s.Export.External.ReturnType), params...)
 				fn.Sig.Variadic = s.Export.External.Variadic
 				ctx.SymbolTable[s.Export.External.Name] = fn
+			} else if s.Export.VariableDefinition != nil {
+				ctx.importGlobalVariable(s.Export.VariableDefinition, s.Export.VariableDefinition.Name, ast.Package)
+			} else if s.Export.Interface != nil {
+				c.declareName(s.Export.Interface, s.Export.Interface.Name)
+				err := ctx.declareInterface(s.Export.Interface, s.Export.Interface.Name)
+				if err != nil {
+					return err
+				}
+			} else if s.Export.Enum != nil {
+				c.declareName(s.Export.Enum, s.Export.Enum.Name)
+				err := ctx.declareEnum(s.Export.Enum, s.Export.Enum.Name)
+				if err != nil {
+					return err
+				}
 			} else {
 				continue
 			}
@@ -299,38 +688,82 @@ func (c *Compiler) ImportAll(path string, ctx *Context) error {
 }
 
 func (c *Compiler) ImportAs(path string, symbols map[string]string, ctx *Context) error {
-	path = strings.Trim(path, "\"")
-	path, importpath, err := ResolveImportPath(path, c.PackageCache)
+	ast, err := c.importFile(path)
 	if err != nil {
 		return err
 	}
-	if !filepath.IsAbs(path) {
-		path = filepath.Clean(filepath.Join(c.workingDir, path))
-	}
-	if !filepath.IsAbs(importpath) {
-		importpath = filepath.Clean(filepath.Join(c.workingDir, importpath))
+	return c.importSymbols(ast, symbols, ctx)
+}
+
+// ImportModule imports every export of the file at path under alias, they
+// can only be accessed qualified with it, as alias.name.
+func (c *Compiler) ImportModule(path string, alias string, ctx *Context) error {
+	if c.modules[alias] {
+		return diagnostics.Errorf(diagnostics.CodeImport, diagnostics.Span{}, "A module is already imported as %s", alias)
 	}
-	c.RequiredImports = append(c.RequiredImports, importpath)
-	info, err := os.Stat(path)
+	ast, err := c.importFile(path)
 	if err != nil {
 		return err
 	}
-	if info.IsDir() {
-		return cli.Exit(color.RedString("Unable to import directory"), 1)
+	symbols := make(map[string]string)
+	for _, s := range ast.Statements {
+		if s.Export == nil {
+			continue
+		}
+		var name string
+		switch {
+		case s.Export.FunctionDefinition != nil:
+			name = s.Export.FunctionDefinition.Name.Name
+		case s.Export.ClassDefinition != nil:
+			name = s.Export.ClassDefinition.Name
+		case s.Export.External != nil:
+			name = strings.Trim(s.Export.External.Name, "\"")
+		case s.Export.VariableDefinition != nil:
+			name = s.Export.VariableDefinition.Name
+		case s.Export.Interface != nil:
+			name = s.Export.Interface.Name
+		case s.Export.Enum != nil:
+			name = s.Export.Enum.Name
+		default:
+			continue
+		}
+		symbols[name] = alias + "." + name
+	}
+	c.modules[alias] = true
+	return c.importSymbols(ast, symbols, ctx)
+}
+
+// importSymbols declares the exports of ast listed in symbols under their new
+// names. Types the exports refer to are resolved to the names they were
+// imported as.
+func (c *Compiler) importSymbols(ast *parser.Program, symbols map[string]string, ctx *Context) error {
+	rename := func(name string) string {
+		if newname, ok := symbols[name]; ok && newname != "" {
+			return newname
+		}
+		return name
 	}
-	ast := parser.ParseFile(path)
+	ictx := *ctx
+	ictx.typeParams = make(map[string]types.Type)
+	ctx = &ictx
+	scope := &packageScope{ast: ast, types: ctx.typeParams}
+
 	for _, s := range ast.Statements {
 		if s.Export != nil {
 			if s.Export.FunctionDefinition != nil {
 				if newname, ok := symbols[s.Export.FunctionDefinition.Name.Name]; ok {
+					if newname == "" {
+						newname = s.Export.FunctionDefinition.Name.Name
+					}
+					if len(s.Export.FunctionDefinition.TypeParams) > 0 {
+						ctx.genericFuncs[newname] = &genericFunc{FunctionDefinition: s.Export.FunctionDefinition, scope: scope}
+						continue
+					}
 					var params []*ir.Param
 					for _, p := range s.Export.FunctionDefinition.Parameters {
 						params = append(params, ir.NewParam(p.Name, ctx.CFTypeToLLType(p.Type)))
 					}
-					fn := c.Module.NewFunc(s.Export.FunctionDefinition.Name.Name, ctx.CFMultiTypeToLLType(s.Export.FunctionDefinition.ReturnType), params...)
-					if newname == "" {
-						newname = s.Export.FunctionDefinition.Name.Name
-					}
+					fn := c.Module.NewFunc(functionSymbol(ast.Package, s.Export.FunctionDefinition), ctx.CFMultiTypeToLLType(s.Export.FunctionDefinition.ReturnType), params...)
 					ctx.SymbolTable[newname] = fn
 				}
 			} else if s.Export.ClassDefinition != nil {
@@ -338,36 +771,24 @@ func (c *Compiler) ImportAs(path string, symbols map[string]string, ctx *Context
 					if newname == "" {
 						newname = s.Export.ClassDefinition.Name
 					}
-					cStruct := types.NewStruct()
-					for _, st := range s.Export.ClassDefinition.Body {
-						if st.FieldDefinition != nil {
-							cStruct.Fields = append(cStruct.Fields, ctx.CFTypeToLLType(st.FieldDefinition.Type))
-						} else if st.FunctionDefinition != nil {
-							var params []*ir.Param
-							for _, p := range st.FunctionDefinition.Parameters {
-								params = append(params, ir.NewParam(p.Name, ctx.CFTypeToLLType(p.Type)))
-							}
-							f := st.FunctionDefinition
-
-							ms := "." + f.Name.Name
-							if f.Name.Op {
-								ms = ".op." + strings.Trim(f.Name.Name, "\"")
-							} else if f.Name.Get {
-								ms = ".get." + strings.Trim(f.Name.Name, "\"")
-							} else if f.Name.Set {
-								ms = ".set." + strings.Trim(f.Name.Name, "\"")
-							}
-
-							fn := ctx.Module.NewFunc(s.Export.ClassDefinition.Name+ms, ctx.CFMultiTypeToLLType(f.ReturnType), params...)
-							if st.FunctionDefinition.Variadic != "" {
-								fn.Sig.Variadic = true
-							}
-
-							ctx.SymbolTable[s.Export.ClassDefinition.Name+ms] = fn
-						}
+					c.declareName(s.Export.ClassDefinition, newname)
+					if len(s.Export.ClassDefinition.TypeParams) > 0 {
+						ctx.genericClasses[newname] = &genericClass{ClassDefinition: s.Export.ClassDefinition, scope: scope}
+						continue
+					}
+					class := exportedClass(s.Export.ClassDefinition)
+					class.Extends = rename(class.Extends)
+					class.Implements = nil
+					for _, iface := range s.Export.ClassDefinition.Implements {
+						class.Implements = append(class.Implements, rename(iface))
+					}
+					_, err := ctx.declareClass(class, newname, ast.Package)
+					if err != nil {
+						return err
+					}
+					for _, v := range staticFields(s.Export.ClassDefinition) {
+						ctx.importGlobalVariable(v, newname+strings.TrimPrefix(v.Name, s.Export.ClassDefinition.Name), ast.Package)
 					}
-					ctx.structNames[cStruct] = newname
-					ctx.Module.NewTypeDef(newname, cStruct)
 				}
 			} else if s.Export.External != nil {
 				var params []*ir.Param
@@ -375,7 +796,38 @@ func (c *Compiler) ImportAs(path string, symbols map[string]string, ctx *Context
 					params = append(params, ir.NewParam(p.Name, ctx.CFTypeToLLType(p.Type)))
 				}
 				fn := c.Module.NewFunc(s.Export.External.Name, ctx.CFMultiTypeToLLType(s.Export.External.ReturnType), params...)
-				ctx.SymbolTable[s.Export.External.Name] = fn
+				ctx.SymbolTable[rename(s.Export.External.Name)] = fn
+			} else if s.Export.VariableDefinition != nil {
+				if newname, ok := symbols[s.Export.VariableDefinition.Name]; ok {
+					if newname == "" {
+						newname = s.Export.VariableDefinition.Name
+					}
+					ctx.importGlobalVariable(s.Export.VariableDefinition, newname, ast.Package)
+				}
+			} else if s.Export.Interface != nil {
+				if newname, ok := symbols[s.Export.Interface.Name]; ok {
+					if newname == "" {
+						newname = s.Export.Interface.Name
+					}
+					c.declareName(s.Export.Interface, newname)
+					err := ctx.declareInterface(s.Export.Interface, newname)
+					if err != nil {
+						return err
+					}
+					ctx.typeParams[s.Export.Interface.Name], _ = ctx.lookupClass(newname)
+				}
+			} else if s.Export.Enum != nil {
+				if newname, ok := symbols[s.Export.Enum.Name]; ok {
+					if newname == "" {
+						newname = s.Export.Enum.Name
+					}
+					c.declareName(s.Export.Enum, newname)
+					err := ctx.declareEnum(s.Export.Enum, newname)
+					if err != nil {
+						return err
+					}
+					ctx.typeParams[s.Export.Enum.Name] = ctx.enums[newname].typ
+				}
 			} else {
 				continue
 			}
@@ -383,3 +835,50 @@ func (c *Compiler) ImportAs(path string, symbols map[string]string, ctx *Context
 	}
 	return nil
 }
+
+// importedGlobal is a global imported by importGlobalVariable.
+type importedGlobal struct {
+	ctx  *Context
+	v    *parser.VariableDefinition
+	name string
+	pkg  string
+}
+
+// importGlobalVariable declares a global exported by another file of package
+// pkg and makes it available under name. Globals defined without a type are
+// declared once the checker inferred it.
+func (ctx *Context) importGlobalVariable(v *parser.VariableDefinition, name string, pkg string) {
+	valType, err := ctx.variableType(v)
+	if err != nil {
+		ctx.report(err)
+		return
+	}
+	if ctx.Info == nil && (valType == nil || v.Constant == "const") {
+		// The checker infers the type of the variable, and the value of constants
+		ctx.inferredGlobals = append(ctx.inferredGlobals, importedGlobal{ctx: ctx, v: v, name: name, pkg: pkg})
+		return
+	} else if valType == nil {
+		ctx.report(posError(v.Pos, "Unable to infer the type of %s, it needs a type", v.Name))
+		return
+	}
+	if folded, ok := ctx.foldedConstant(v, valType); ok {
+		ctx.Compiler.Context.vars[name] = &Variable{
+			Name:     name,
+			Type:     valType,
+			Value:    folded,
+			Pos:      v.Pos,
+			Constant: true,
+		}
+		return
+	}
+	global := ctx.Module.NewGlobal(mangle(pkg, v.Name), valType)
+	global.Linkage = enum.LinkageExternal
+	global.Immutable = v.Constant == "const"
+	ctx.Compiler.Context.vars[name] = &Variable{
+		Name:     name,
+		Type:     valType,
+		Value:    global,
+		Pos:      v.Pos,
+		Constant: global.Immutable,
+	}
+}
@@ -0,0 +1,213 @@
+package compiler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// compileFiles writes files to a temporary directory and compiles main.cffc,
+// which may import the other files.
+func compileFiles(t *testing.T, files map[string]string) (*Compiler, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ast, err := parser.ParseFile(filepath.Join(dir, "main.cffc"))
+	if err != nil {
+		return nil, err
+	}
+	c := NewCompiler()
+	c.Init(ast, dir)
+	if err := c.FindImports(); err != nil {
+		return c, err
+	}
+	return c, c.Compile()
+}
+
+// compileErrors compiles main and returns the diagnostics it fails with.
+func compileErrors(t *testing.T, main string) diagnostics.List {
+	t.Helper()
+	_, err := compileFiles(t, map[string]string{"main.cffc": main})
+	var diags diagnostics.List
+	if !errors.As(err, &diags) {
+		t.Fatalf("expected a list of diagnostics, got %v", err)
+	}
+	return diags
+}
+
+func hasFunc(c *Compiler, name string) bool {
+	for _, f := range c.Module.Funcs {
+		if f.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+const genericLib = `package genlib;
+export func maxOf<T>(a: T, b: T): T {
+  if (a > b) { return a; }
+  return b;
+}
+`
+
+func TestImportedGenericCall(t *testing.T) {
+	tests := []struct {
+		name  string
+		main  string
+		funcs []string
+	}{
+		{
+			name: "explicit type arguments through an alias",
+			main: `package main;
+import "./genlib.cffc" as gl;
+func main(): i32 {
+  var x: i64 = gl.maxOf<i64>(4, 8);
+  var y: f64 = gl.maxOf<f64>(2.5, 1.0);
+  return 0;
+}`,
+			funcs: []string{"genlib.maxOf<i64>", "genlib.maxOf<f64>"},
+		},
+		{
+			name: "inferred type arguments through an alias",
+			main: `package main;
+import "./genlib.cffc" as gl;
+func main(): i32 {
+  var z = gl.maxOf(3, 9);
+  return 0;
+}`,
+			funcs: []string{"genlib.maxOf<i64>"},
+		},
+		{
+			name: "explicit type arguments of an imported symbol",
+			main: `package main;
+from "./genlib.cffc" import maxOf;
+func main(): i32 {
+  var x: i32 = maxOf<i32>(1, 2);
+  return 0;
+}`,
+			funcs: []string{"genlib.maxOf<i32>"},
+		},
+		{
+			name: "imported symbol renamed with as",
+			main: `package main;
+from "./genlib.cffc" import maxOf as larger;
+func main(): i32 {
+  var x: i32 = larger<i32>(1, 2);
+  return 0;
+}`,
+			funcs: []string{"genlib.maxOf<i32>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := compileFiles(t, map[string]string{"main.cffc": tt.main, "genlib.cffc": genericLib})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, name := range tt.funcs {
+				if !hasFunc(c, name) {
+					t.Errorf("function %s was not instantiated", name)
+				}
+			}
+		})
+	}
+}
+
+func TestGenericsOfImportedPackages(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{
+		"main.cffc": `package main;
+import "./a.cffc" as a;
+import "./b.cffc" as b;
+func main(): i32 {
+  var x: i64 = a.pick<i64>(1, 2);
+  var y: i64 = b.pick<i64>(1, 2);
+  return 0;
+}`,
+		"a.cffc": `package a;
+export func pick<T>(x: T, y: T): T { return x; }
+`,
+		"b.cffc": `package b;
+func helper(): i64 { return 1; }
+export func pick<T>(x: T, y: T): T {
+  if (helper() > 0) { return y; }
+  return x;
+}
+`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Each package has its own instance, which calls the functions of its
+	// package even if they are not exported
+	if ir := funcIR(t, c, "main"); !strings.Contains(ir, "@\"a.pick<i64>\"(") || !strings.Contains(ir, "@\"b.pick<i64>\"(") {
+		t.Errorf("expected main to call the instances of both packages:\n%s", ir)
+	}
+	if ir := funcIR(t, c, "b.pick<i64>"); !strings.Contains(ir, "@b.helper()") {
+		t.Errorf("expected pick to call the helper of its package:\n%s", ir)
+	}
+}
+
+func TestHeaderDeclaresExportedFunctions(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": `package external;
+export func testing(): i64 { return 0; }
+func hidden(): i64 { return 1; }
+export const version: i64 = 3;
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "external.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := WriteHeader(f, c); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := string(contents)
+	for _, decl := range []string{
+		`long long testing() __asm__("external.testing");`,
+		`extern const long long version __asm__("external.version");`,
+	} {
+		if !strings.Contains(header, decl) {
+			t.Errorf("header does not declare %s:\n%s", decl, header)
+		}
+	}
+	if strings.Contains(header, "hidden") {
+		t.Errorf("header declares a function that is not exported:\n%s", header)
+	}
+}
+
+func TestDeclarationErrorsReportedWithTypeErrors(t *testing.T) {
+	diags := compileErrors(t, `package main;
+enum E { A, B }
+func main(): i32 {
+  var x: i64 = true;
+  var e: E = E.A;
+  match (e) { case A: return 1; }
+  return 0;
+}
+`)
+	// Both errors are found by the checker, so neither hides the other
+	if len(diags) != 2 || !strings.Contains(diags[0].Message, "definition of x") || !strings.Contains(diags[1].Message, "not exhaustive") {
+		t.Errorf("expected the type error and the match error, got %s", diags)
+	}
+}
@@ -0,0 +1,304 @@
+package compiler
+
+import (
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// Enums without payloads are integers of a type of their own, the value of
+// a variant is its index. As soon as a variant carries a payload the enum
+// becomes a tagged union: a struct holding the index of the variant followed
+// by storage large enough for the payload of any variant.
+
+type enumInfo struct {
+	typ      types.Type
+	variants []string
+	// Types of the values carried by each variant, nil for plain enums
+	payloads [][]types.Type
+}
+
+func (info *enumInfo) tagged() bool {
+	return info.payloads != nil
+}
+
+func (info *enumInfo) variant(name string) int {
+	for i, v := range info.variants {
+		if v == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// declareEnum declares enum e, which is known as name in this file.
+func (ctx *Context) declareEnum(e *parser.EnumDefinition, name string) error {
+	info := &enumInfo{}
+	tagged := false
+	for _, v := range e.Variants {
+		info.variants = append(info.variants, v.Name)
+		tagged = tagged || len(v.Payload) > 0
+	}
+
+	if !tagged {
+		info.typ = types.NewInt(32)
+		ctx.enums[name] = info
+		return nil
+	}
+
+	var size uint64
+	for _, v := range e.Variants {
+		var payload []types.Type
+		for _, t := range v.Payload {
+			payload = append(payload, ctx.CFTypeToLLType(t))
+		}
+		info.payloads = append(info.payloads, payload)
+		if s := sizeOf(types.NewStruct(payload...)); s > size {
+			size = s
+		}
+	}
+	typ := types.NewStruct(types.I32, types.NewArray((size+7)/8, types.I64))
+	typ.SetName(name)
+	ctx.Context.structNames[typ] = name
+	ctx.Module.NewTypeDef(name, typ)
+	info.typ = typ
+	ctx.enums[name] = info
+	return nil
+}
+
+// sizeOf returns the number of bytes a value of typ takes up in memory.
+func sizeOf(typ types.Type) uint64 {
+	switch typ := typ.(type) {
+	case *types.StructType:
+		var size uint64
+		for _, field := range typ.Fields {
+			align := alignOf(field)
+			size = (size+align-1)/align*align + sizeOf(field)
+		}
+		align := alignOf(typ)
+		return (size + align - 1) / align * align
+	case *types.ArrayType:
+		return typ.Len * sizeOf(typ.ElemType)
+	default:
+		return alignOf(typ)
+	}
+}
+
+func alignOf(typ types.Type) uint64 {
+	switch typ := typ.(type) {
+	case *types.IntType:
+		size := uint64(1)
+		for size*8 < typ.BitSize && size < 8 {
+			size *= 2
+		}
+		return size
+	case *types.FloatType:
+		switch typ.Kind {
+		case types.FloatKindHalf:
+			return 2
+		case types.FloatKindFloat:
+			return 4
+		case types.FloatKindFP128:
+			return 16
+		}
+		return 8
+	case *types.StructType:
+		align := uint64(1)
+		for _, field := range typ.Fields {
+			if a := alignOf(field); a > align {
+				align = a
+			}
+		}
+		return align
+	case *types.ArrayType:
+		return alignOf(typ.ElemType)
+	default:
+		return 8
+	}
+}
+
+// enumOf returns the name of the enum typ is, or points to.
+func (ctx *Context) enumOf(typ types.Type) (string, bool) {
+	if ptrType, ok := typ.(*types.PointerType); ok {
+		typ = ptrType.ElemType
+	}
+	for name, info := range ctx.enums {
+		if info.typ == typ {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// enumVariant returns the enum and variant i refers to, if it refers to a
+// variant of an enum rather than to a variable or one of its fields.
+func (ctx *Context) enumVariant(i *parser.Identifier) (string, string, bool) {
+	i = ctx.resolveModule(i)
+	if i == nil || i.Sub == nil || i.Sub.Sub != nil || i.GEP != nil || i.Sub.GEP != nil || i.Ref != "" || i.Deref != "" {
+		return "", "", false
+	}
+	_, ok := ctx.enums[i.Name]
+	return i.Name, i.Sub.Name, ok && ctx.lookupVariable(i.Name) == nil
+}
+
+// enumConstant returns the value of a variant that carries no payload.
+func (ctx *Context) enumConstant(pos lexer.Position, name string, variant string) (constant.Constant, error) {
+	info := ctx.enums[name]
+	index := info.variant(variant)
+	if index < 0 {
+		return nil, undefinedError(pos, "Variant %s not found in enum %s", variant, name).Suggest(variant, info.variants)
+	}
+	if !info.tagged() {
+		return constant.NewInt(info.typ.(*types.IntType), int64(index)), nil
+	}
+	if len(info.payloads[index]) > 0 {
+		return nil, posError(pos, "Variant %s of enum %s expects %d values", variant, name, len(info.payloads[index]))
+	}
+	typ := info.typ.(*types.StructType)
+	return constant.NewStruct(typ, constant.NewInt(types.I32, int64(index)), constant.NewZeroInitializer(typ.Fields[1])), nil
+}
+
+// compileEnumVariant creates a value of a variant carrying a payload.
+func (ctx *Context) compileEnumVariant(pos lexer.Position, name string, variant string, args *parser.ArgumentList) (value.Value, error) {
+	info := ctx.enums[name]
+	index := info.variant(variant)
+	if index < 0 {
+		return nil, undefinedError(pos, "Variant %s not found in enum %s", variant, name).Suggest(variant, info.variants)
+	}
+	if !info.tagged() || len(info.payloads[index]) != len(args.Arguments) {
+		expected := 0
+		if info.tagged() {
+			expected = len(info.payloads[index])
+		}
+		return nil, posError(pos, "Variant %s of enum %s expects %d values, got %d", variant, name, expected, len(args.Arguments))
+	}
+	if len(args.Arguments) == 0 {
+		return ctx.enumConstant(pos, name, variant)
+	}
+
+	typ := info.typ.(*types.StructType)
+	ptr := ctx.NewAlloca(typ)
+	payload := ctx.enumPayload(ptr, info, index)
+	ctx.NewStore(constant.NewInt(types.I32, int64(index)), ctx.NewGetElementPtr(typ, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0)))
+	for i, arg := range args.Arguments {
+		fieldType := info.payloads[index][i]
+		val, err := ctx.compileExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !val.Type().Equal(fieldType) {
+			return nil, posError(arg.Pos, "Value %d of variant %s must be of type %s, got %s", i+1, variant, ctx.TypeToString(fieldType), ctx.TypeToString(val.Type()))
+		}
+		payloadType := payload.Type().(*types.PointerType).ElemType
+		ctx.NewStore(val, ctx.NewGetElementPtr(payloadType, payload, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i))))
+	}
+	return ctx.NewLoad(typ, ptr), nil
+}
+
+// enumPayload returns a pointer to the payload of variant index in the
+// tagged union at ptr.
+func (ctx *Context) enumPayload(ptr value.Value, info *enumInfo, index int) value.Value {
+	typ := info.typ.(*types.StructType)
+	storage := ctx.NewGetElementPtr(typ, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 1))
+	return ctx.NewBitCast(storage, types.NewPointer(types.NewStruct(info.payloads[index]...)))
+}
+
+// compileMatch compiles a match statement into a switch on the variant of the
+// value, binding the payload of the matched variant in each arm.
+func (ctx *Context) compileMatch(m *parser.Match) error {
+	val, err := ctx.compileExpression(m.Value)
+	if err != nil {
+		return err
+	}
+	name, ok := ctx.enumOf(val.Type())
+	if !ok {
+		return posError(m.Value.Pos, "Cannot match on a value of type %s", ctx.TypeToString(val.Type()))
+	}
+	info := ctx.enums[name]
+
+	// Tagged unions are matched in memory, so the payload can be read
+	var tag, ptr value.Value
+	if info.tagged() {
+		ptr = val
+		if !isStorage(val, info.typ) {
+			ptr = ctx.NewAlloca(info.typ)
+			ctx.NewStore(val, ptr)
+		}
+		tag = ctx.NewLoad(types.I32, ctx.NewGetElementPtr(info.typ, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0)))
+	} else {
+		if isStorage(val, info.typ) {
+			val = ctx.NewLoad(info.typ, val)
+		}
+		tag = val
+	}
+	tagType := tag.Type().(*types.IntType)
+
+	mergeBlock := ctx.Block.Parent.NewBlock("")
+	defaultBlock := mergeBlock
+	if m.HasDefault {
+		defaultBlock = ctx.Block.Parent.NewBlock("")
+	}
+	armBlocks := make([]*ir.Block, len(m.Arms))
+	armVariants := make([]int, len(m.Arms))
+	covered := make([]bool, len(info.variants))
+	var cases []*ir.Case
+	for i, arm := range m.Arms {
+		armBlocks[i] = ctx.Block.Parent.NewBlock("")
+		for _, p := range arm.Patterns {
+			if p.Enum != "" && p.Enum != name {
+				return posError(p.Pos, "Pattern of enum %s can not match a value of enum %s", p.Enum, name)
+			}
+			index := info.variant(p.Variant)
+			if index < 0 {
+				return undefinedError(p.Pos, "Variant %s not found in enum %s", p.Variant, name).Suggest(p.Variant, info.variants)
+			}
+			if covered[index] {
+				// Reported by the checker, a switch can not have a case twice
+				continue
+			}
+			if p.Bindings != nil {
+				if len(arm.Patterns) > 1 {
+					return posError(p.Pos, "Patterns binding values can not be combined with other patterns")
+				}
+				if !info.tagged() || len(p.Bindings) != len(info.payloads[index]) {
+					return posError(p.Pos, "Variant %s of enum %s does not carry %d values", p.Variant, name, len(p.Bindings))
+				}
+			}
+			covered[index] = true
+			armVariants[i] = index
+			cases = append(cases, ir.NewCase(constant.NewInt(tagType, int64(index)), armBlocks[i]))
+		}
+	}
+	ctx.NewSwitch(tag, defaultBlock, cases...)
+
+	for i, arm := range m.Arms {
+		var bindings []*Variable
+		if p := arm.Patterns[0]; len(p.Bindings) > 0 {
+			bctx := ctx.NewContext(armBlocks[i])
+			payload := bctx.enumPayload(ptr, info, armVariants[i])
+			payloadType := payload.Type().(*types.PointerType).ElemType
+			for j, binding := range p.Bindings {
+				if binding == "_" {
+					continue
+				}
+				fieldType := info.payloads[armVariants[i]][j]
+				field := bctx.NewGetElementPtr(payloadType, payload, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(j)))
+				bindings = append(bindings, &Variable{Name: binding, Type: fieldType, Value: bctx.NewLoad(fieldType, field)})
+			}
+		}
+		if err := ctx.compileSwitchBody(arm.Body, armBlocks[i], mergeBlock, bindings...); err != nil {
+			return err
+		}
+	}
+	if m.HasDefault {
+		if err := ctx.compileSwitchBody(m.Default, defaultBlock, mergeBlock); err != nil {
+			return err
+		}
+	}
+
+	ctx.Block = mergeBlock
+	return nil
+}
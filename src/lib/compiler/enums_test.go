@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+const results = `package main;
+enum Color { Red, Green, Blue }
+enum Result { Ok(i64), Err(*i8) }
+`
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// Parameter and match statement of f
+		param string
+		match string
+		insts []string
+	}{
+		{
+			name:  "plain enum",
+			param: "c: Color",
+			match: "match (c) { case Red: return 1; case Green, Blue: return 2; }",
+			insts: []string{"switch i32", "i32 0, label", "i32 1, label", "i32 2, label"},
+		},
+		{
+			name:  "tagged union",
+			param: "r: Result",
+			match: "match (r) { case Ok(v): return v; case Err(_): return 0; }",
+			insts: []string{"switch i32", "to { i64 }*", "load i64"},
+		},
+		{
+			name:  "qualified patterns",
+			param: "c: Color",
+			match: "match (c) { case Color.Red: return 1; case Color.Green: return 2; case Color.Blue: return 3; }",
+			insts: []string{"switch i32"},
+		},
+		{
+			name:  "empty default",
+			param: "c: Color",
+			match: "match (c) { case Red: return 1; default: }",
+			insts: []string{"switch i32", "i32 0, label"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := compileFiles(t, map[string]string{"main.cffc": results + "func f(" + tt.param + "): i64 {\n" + tt.match + "\nreturn 0;\n}\nfunc main(): i32 { return 0; }\n"})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			ir := funcIR(t, c, "main.f")
+			for _, inst := range tt.insts {
+				if !strings.Contains(ir, inst) {
+					t.Errorf("expected %q in the IR of f:\n%s", inst, ir)
+				}
+			}
+		})
+	}
+}
+
+func TestEnumLayout(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": results + `
+func main(): i32 {
+  var c: Color = Color.Blue;
+  var r: Result = Result.Err("failed");
+  return 0;
+}
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Plain enums are integers, tagged unions a tag and room for the largest
+	// payload
+	if !strings.Contains(c.Module.String(), "%Result = type { i32, [1 x i64] }") {
+		t.Errorf("expected Result to be a tag and a payload:\n%s", c.Module)
+	}
+	ir := funcIR(t, c, "main")
+	for _, inst := range []string{"store i32 2, i32*", "store i32 1, i32*", "to { i8* }*"} {
+		if !strings.Contains(ir, inst) {
+			t.Errorf("expected %q in the IR of main:\n%s", inst, ir)
+		}
+	}
+}
+
+func TestIntegerIsNotEnum(t *testing.T) {
+	diags := compileErrors(t, results+`
+func main(): i32 {
+  var c: Color = 1;
+  return 0;
+}
+`)
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "definition of c") {
+		t.Fatalf("expected the integer to be rejected, got %s", diags)
+	}
+}
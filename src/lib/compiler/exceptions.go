@@ -0,0 +1,134 @@
+package compiler
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Exceptions are implemented with setjmp/longjmp. Every try block pushes a
+// frame onto a thread local stack, throwing pops the innermost frame, stores
+// the thrown value in it and jumps back to the try block that pushed it.
+// The runtime is emitted into every module with linkonce_odr linkage, so the
+// linker keeps a single copy of it.
+
+// Frame layout: jmp_buf, previous frame, thrown value.
+var exceptionFrameType = types.NewStruct(types.NewArray(64, types.I64), types.I8Ptr, types.I8Ptr)
+
+type exceptionRuntime struct {
+	current *ir.Global
+	throw   *ir.Func
+	setjmp  *ir.Func
+}
+
+// Cleanup is code that has to run whenever control leaves a try or catch
+// block, be it by falling through, returning, breaking or continuing.
+type Cleanup struct {
+	parent *Cleanup
+	emit   func(ctx *Context) error
+}
+
+func (ctx *Context) exceptionRuntime() *exceptionRuntime {
+	if ctx.Compiler.exceptions != nil {
+		return ctx.Compiler.exceptions
+	}
+
+	current := ctx.Module.NewGlobalDef("cffc.exception", constant.NewNull(types.I8Ptr))
+	current.Linkage = enum.LinkageLinkOnceODR
+	current.TLSModel = enum.TLSModelGeneric
+
+	setjmp, ok := ctx.lookupFunction("setjmp")
+	if !ok {
+		setjmp = ctx.Module.NewFunc("setjmp", types.I32, ir.NewParam("env", types.I8Ptr))
+	}
+	setjmp.FuncAttrs = append(setjmp.FuncAttrs, enum.FuncAttrReturnsTwice)
+
+	longjmp, ok := ctx.lookupFunction("longjmp")
+	if !ok {
+		longjmp = ctx.Module.NewFunc("longjmp", types.Void, ir.NewParam("env", types.I8Ptr), ir.NewParam("val", types.I32))
+	}
+
+	fflush, ok := ctx.lookupFunction("fflush")
+	if !ok {
+		fflush = ctx.Module.NewFunc("fflush", types.I32, ir.NewParam("stream", types.I8Ptr))
+	}
+
+	abort, ok := ctx.lookupFunction("abort")
+	if !ok {
+		abort = ctx.Module.NewFunc("abort", types.Void)
+	}
+
+	// cffc.throw(value) unwinds to the innermost try block, or flushes the
+	// output and aborts the program if the exception is not caught
+	val := ir.NewParam("value", types.I8Ptr)
+	throw := ctx.Module.NewFunc("cffc.throw", types.Void, val)
+	throw.Linkage = enum.LinkageLinkOnceODR
+	throw.FuncAttrs = append(throw.FuncAttrs, enum.FuncAttrNoReturn)
+
+	entry := throw.NewBlock("")
+	uncaught := throw.NewBlock("")
+	caught := throw.NewBlock("")
+
+	frame := entry.NewLoad(types.I8Ptr, current)
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, frame, constant.NewNull(types.I8Ptr)), uncaught, caught)
+
+	uncaught.NewCall(fflush, constant.NewNull(types.I8Ptr))
+	uncaught.NewCall(abort)
+	uncaught.NewUnreachable()
+
+	f := caught.NewBitCast(frame, types.NewPointer(exceptionFrameType))
+	caught.NewStore(caught.NewLoad(types.I8Ptr, exceptionFramePtr(caught, f, 1)), current)
+	caught.NewStore(val, exceptionFramePtr(caught, f, 2))
+	caught.NewCall(longjmp, caught.NewBitCast(exceptionFramePtr(caught, f, 0), types.I8Ptr), constant.NewInt(types.I32, 1))
+	caught.NewUnreachable()
+
+	ctx.Compiler.exceptions = &exceptionRuntime{
+		current: current,
+		throw:   throw,
+		setjmp:  setjmp,
+	}
+	return ctx.Compiler.exceptions
+}
+
+func exceptionFramePtr(b *ir.Block, frame value.Value, field int64) *ir.InstGetElementPtr {
+	return b.NewGetElementPtr(exceptionFrameType, frame, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+}
+
+// pushExceptionFrame links a new frame into the exception stack and branches
+// to body, or to handler once an exception is thrown while the frame is active.
+func (ctx *Context) pushExceptionFrame(body *ir.Block, handler *ir.Block) value.Value {
+	rt := ctx.exceptionRuntime()
+
+	frame := ctx.NewAlloca(exceptionFrameType)
+	frame.Align = 16
+	ctx.NewStore(ctx.NewLoad(types.I8Ptr, rt.current), exceptionFramePtr(ctx.Block, frame, 1))
+	ctx.NewStore(ctx.NewBitCast(frame, types.I8Ptr), rt.current)
+
+	res := ctx.NewCall(rt.setjmp, ctx.NewBitCast(exceptionFramePtr(ctx.Block, frame, 0), types.I8Ptr))
+	ctx.NewCondBr(ctx.NewICmp(enum.IPredEQ, res, constant.NewInt(types.I32, 0)), body, handler)
+
+	return frame
+}
+
+// popExceptionFrame removes a frame that was not unwound by a throw.
+func (ctx *Context) popExceptionFrame(frame value.Value) {
+	rt := ctx.exceptionRuntime()
+	ctx.NewStore(ctx.NewLoad(types.I8Ptr, exceptionFramePtr(ctx.Block, frame, 1)), rt.current)
+}
+
+// thrownValue returns the value stored in a frame by the throw that unwound it.
+func (ctx *Context) thrownValue(frame value.Value) value.Value {
+	return ctx.NewLoad(types.I8Ptr, exceptionFramePtr(ctx.Block, frame, 2))
+}
+
+// emitCleanups runs every cleanup between the current one and target.
+func (ctx *Context) emitCleanups(target *Cleanup) error {
+	for c := ctx.cleanup; c != nil && c != target; c = c.parent {
+		if err := c.emit(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
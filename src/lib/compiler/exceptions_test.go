@@ -0,0 +1,61 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFinallyRunsOnExit(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// Instruction leaving the try block after the finally block ran
+		exit string
+	}{
+		{"fall through", "try { n = 1; } catch e { } finally { done(); }", "ret i64 0"},
+		{"return", "try { return 1; } catch e { } finally { done(); }", "ret i64 1"},
+		{"break out of for", "for (var i: i64 = 0; i < 3; i++;) { try { break; } catch e { } finally { done(); } }", "br label"},
+		{"continue in for", "for (var i: i64 = 0; i < 3; i++;) { try { continue; } catch e { } finally { done(); } }", "br label"},
+		{"continue in while", "while (n < 3) { n++; try { continue; } catch e { } finally { done(); } }", "br label"},
+		{"continue in until", "until (n == 3) { n++; try { continue; } catch e { } finally { done(); } }", "br label"},
+		{"rethrow from catch", `try { throw "a"; } catch e { throw e; } finally { done(); }`, "call void @cffc.throw("},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := compileFiles(t, map[string]string{"main.cffc": `package main;
+func done(): void { }
+func f(): i64 {
+  var n: i64 = 0;
+  ` + tt.body + `
+  return 0;
+}
+func main(): i32 { return 0; }
+`})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if ir := funcIR(t, c, "main.f"); !strings.Contains(ir, "call void @main.done()\n\t"+tt.exit) {
+				t.Errorf("expected the finally block to run before %q:\n%s", tt.exit, ir)
+			}
+		})
+	}
+}
+
+func TestThrowUsesRuntime(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": `package main;
+func fail(): i64 { throw "failed"; }
+func main(): i32 {
+  try { fail(); } catch e { return 1; }
+  return 0;
+}
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ir := funcIR(t, c, "main.fail"); !strings.Contains(ir, "call void @cffc.throw(") {
+		t.Errorf("expected throw to call the runtime:\n%s", ir)
+	}
+	if ir := funcIR(t, c, "main"); !strings.Contains(ir, "call i32 @setjmp(") {
+		t.Errorf("expected the try block to set a jump buffer:\n%s", ir)
+	}
+}
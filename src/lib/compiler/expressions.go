@@ -5,45 +5,147 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/fatih/color"
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
-	"github.com/urfave/cli/v2"
 	"github.com/vyPal/CaffeineC/lib/parser"
 )
 
 func (ctx *Context) compileExpression(e *parser.Expression) (value.Value, error) {
-	cond, err := ctx.compileLogicalOr(e.Condition)
+	val, err := ctx.compileTernary(e)
 	if err != nil {
 		return nil, err
 	}
+	return ctx.convert(e, val)
+}
 
-	if e.True != nil && e.False != nil {
-		if cond.Type() != types.I1 {
-			return nil, posError(e.Condition.Pos, "condition in ternary expression must be a boolean")
+// convert applies the implicit conversion the checker found for e to val, the
+// value e compiled to. Values that are not converted keep their type, but
+// structs and arrays held in memory are loaded.
+func (ctx *Context) convert(e *parser.Expression, val value.Value) (value.Value, error) {
+	if target, ok := ctx.Info.Conversions[e]; ok {
+		typ, ok := ctx.checkedType(target)
+		if !ok {
+			return nil, posError(e.Pos, "Cannot convert a value of type %s to %s", ctx.TypeToString(val.Type()), target)
 		}
+		return ctx.convertValue(e.Pos, val, typ)
+	}
+	if typ, ok := ctx.checkedType(ctx.Info.Types[e]); ok && isStorage(val, typ) {
+		return ctx.NewLoad(typ, val), nil
+	}
+	return val, nil
+}
 
-		trueVal, err := ctx.compileExpression(e.True)
-		if err != nil {
-			return nil, err
+// convertValue converts val to typ: a pointer to an instance of a class
+// becomes a pointer to one of its parent classes or an interface value, and
+// an array becomes a pointer to its first element. Values held in memory are
+// loaded.
+func (ctx *Context) convertValue(pos lexer.Position, val value.Value, typ types.Type) (value.Value, error) {
+	if val.Type().Equal(typ) {
+		return val, nil
+	}
+	if isStorage(val, typ) {
+		return ctx.NewLoad(typ, val), nil
+	}
+	switch t := typ.(type) {
+	case *types.StructType:
+		if iface, ok := ctx.interfaceOf(t); ok {
+			return ctx.toInterface(pos, val, t, iface)
 		}
-
-		falseVal, err := ctx.compileExpression(e.False)
-		if err != nil {
-			return nil, err
+	case *types.PointerType:
+		switch v := val.Type().(type) {
+		case *types.ArrayType:
+			if v.ElemType.Equal(t.ElemType) {
+				return ctx.decay(val, v), nil
+			}
+		case *types.PointerType:
+			if arrType, ok := v.ElemType.(*types.ArrayType); ok && arrType.ElemType.Equal(t.ElemType) {
+				return ctx.NewGetElementPtr(arrType, val, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0)), nil
+			}
+			if _, ok := ctx.classOf(v); ok {
+				if c, ok := val.(constant.Constant); ok {
+					return constant.NewBitCast(c, typ), nil
+				}
+				return ctx.NewBitCast(val, typ), nil
+			}
+		case *types.StructType:
+			// Interface values used through a pointer are kept in memory
+			if v.Equal(t.ElemType) {
+				ptr := ctx.NewAlloca(v)
+				ctx.NewStore(val, ptr)
+				return ptr, nil
+			}
 		}
+	}
+	return nil, posError(pos, "Cannot convert a value of type %s to %s", ctx.TypeToString(val.Type()), ctx.TypeToString(typ))
+}
 
-		if trueVal.Type() != falseVal.Type() {
-			return nil, posError(e.Pos, "true and false expressions in ternary expression must be the same type")
-		}
+// decay returns a pointer to the first element of the array arr. Constant
+// arrays are stored in a global, others on the stack, unless they were loaded
+// from memory already.
+func (ctx *Context) decay(arr value.Value, arrType *types.ArrayType) value.Value {
+	zero := constant.NewInt(types.I32, 0)
+	switch a := arr.(type) {
+	case constant.Constant:
+		global := ctx.Module.NewGlobalDef("", a)
+		global.Immutable = true
+		global.Linkage = enum.LinkagePrivate
+		return constant.NewGetElementPtr(arrType, global, zero, zero)
+	case *ir.InstLoad:
+		return ctx.NewGetElementPtr(arrType, a.Src, zero, zero)
+	}
+	ptr := ctx.NewAlloca(arrType)
+	ctx.NewStore(arr, ptr)
+	return ctx.NewGetElementPtr(arrType, ptr, zero, zero)
+}
 
-		return ctx.NewSelect(cond, trueVal, falseVal), nil
+// compileTernary compiles an expression, which is a ternary expression if it
+// has a true and a false side.
+func (ctx *Context) compileTernary(e *parser.Expression) (value.Value, error) {
+	if e.True == nil || e.False == nil {
+		return ctx.compileLogicalOr(e.Condition)
 	}
 
-	return cond, nil
+	cond, err := ctx.compileLogicalOr(e.Condition)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isBool(cond.Type()) {
+		return nil, posError(e.Condition.Pos, "condition in ternary expression must be a boolean")
+	}
+
+	// Only evaluate the selected side
+	trueBlock := ctx.Block.Parent.NewBlock("")
+	falseBlock := ctx.Block.Parent.NewBlock("")
+	mergeBlock := ctx.Block.Parent.NewBlock("")
+	ctx.NewCondBr(cond, trueBlock, falseBlock)
+
+	ctx.Block = trueBlock
+	trueVal, err := ctx.compileExpression(e.True)
+	if err != nil {
+		return nil, err
+	}
+	trueEnd := ctx.Block
+
+	ctx.Block = falseBlock
+	falseVal, err := ctx.compileExpression(e.False)
+	if err != nil {
+		return nil, err
+	}
+	falseEnd := ctx.Block
+
+	if !trueVal.Type().Equal(falseVal.Type()) {
+		return nil, posError(e.Pos, "true and false expressions in ternary expression must be the same type")
+	}
+
+	trueEnd.NewBr(mergeBlock)
+	falseEnd.NewBr(mergeBlock)
+	ctx.Block = mergeBlock
+	return ctx.NewPhi(ir.NewIncoming(trueVal, trueEnd), ir.NewIncoming(falseVal, falseEnd)), nil
 }
 
 func (ctx *Context) compileLogicalAnd(l *parser.LogicalAnd) (value.Value, error) {
@@ -52,11 +154,27 @@ func (ctx *Context) compileLogicalAnd(l *parser.LogicalAnd) (value.Value, error)
 		return nil, err
 	}
 
-	if len(l.Right) != 0 && left.Type() != types.I1 {
+	if len(l.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(l.Right[0].Pos, "&&", left, func() (value.Value, error) {
+			return ctx.compileLogicalAnd(l.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
+	if len(l.Right) != 0 && !isBool(left.Type()) {
 		return nil, posError(l.Left.Pos, "logical and operator requires boolean operands")
 	}
 
 	for _, right := range l.Right {
+		// Only evaluate the right operand if the left one is true
+		leftEnd := ctx.Block
+		rightBlock := ctx.Block.Parent.NewBlock("")
+		mergeBlock := ctx.Block.Parent.NewBlock("")
+		ctx.NewCondBr(left, rightBlock, mergeBlock)
+
+		ctx.Block = rightBlock
 		rightVal, err := ctx.compileLogicalAnd(right)
 		if err != nil {
 			return nil, err
@@ -66,11 +184,14 @@ func (ctx *Context) compileLogicalAnd(l *parser.LogicalAnd) (value.Value, error)
 			rightVal = ctx.NewLoad(ptrType.ElemType, rightVal)
 		}
 
-		if rightVal.Type() != types.I1 {
+		if !isBool(rightVal.Type()) {
 			return nil, posError(right.Pos, "logical and operator requires boolean operands")
 		}
 
-		left = ctx.NewAnd(left, rightVal)
+		rightEnd := ctx.Block
+		ctx.NewBr(mergeBlock)
+		ctx.Block = mergeBlock
+		left = ctx.NewPhi(ir.NewIncoming(constant.False, leftEnd), ir.NewIncoming(rightVal, rightEnd))
 	}
 
 	return left, nil
@@ -82,11 +203,27 @@ func (ctx *Context) compileLogicalOr(l *parser.LogicalOr) (value.Value, error) {
 		return nil, err
 	}
 
-	if len(l.Right) != 0 && left.Type() != types.I1 {
+	if len(l.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(l.Right[0].Pos, "||", left, func() (value.Value, error) {
+			return ctx.compileLogicalOr(l.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
+	if len(l.Right) != 0 && !isBool(left.Type()) {
 		return nil, posError(l.Left.Pos, "logical or operator requires boolean operands")
 	}
 
 	for _, right := range l.Right {
+		// Only evaluate the right operand if the left one is false
+		leftEnd := ctx.Block
+		rightBlock := ctx.Block.Parent.NewBlock("")
+		mergeBlock := ctx.Block.Parent.NewBlock("")
+		ctx.NewCondBr(left, mergeBlock, rightBlock)
+
+		ctx.Block = rightBlock
 		rightVal, err := ctx.compileLogicalOr(right)
 		if err != nil {
 			return nil, err
@@ -96,11 +233,14 @@ func (ctx *Context) compileLogicalOr(l *parser.LogicalOr) (value.Value, error) {
 			rightVal = ctx.NewLoad(ptrType.ElemType, rightVal)
 		}
 
-		if rightVal.Type() != types.I1 {
+		if !isBool(rightVal.Type()) {
 			return nil, posError(right.Pos, "logical or operator requires boolean operands")
 		}
 
-		left = ctx.NewOr(left, rightVal)
+		rightEnd := ctx.Block
+		ctx.NewBr(mergeBlock)
+		ctx.Block = mergeBlock
+		left = ctx.NewPhi(ir.NewIncoming(constant.True, leftEnd), ir.NewIncoming(rightVal, rightEnd))
 	}
 
 	return left, nil
@@ -112,6 +252,15 @@ func (ctx *Context) compileBitwiseAnd(b *parser.BitwiseAnd) (value.Value, error)
 		return nil, err
 	}
 
+	if len(b.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(b.Right[0].Pos, b.Op, left, func() (value.Value, error) {
+			return ctx.compileBitwiseAnd(b.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
 	if _, ok := left.Type().(*types.IntType); len(b.Right) != 0 && !ok {
 		return nil, posError(b.Left.Pos, "bitwise and operator requires integer operands")
 	}
@@ -146,6 +295,15 @@ func (ctx *Context) compileBitwiseXor(b *parser.BitwiseXor) (value.Value, error)
 		return nil, err
 	}
 
+	if len(b.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(b.Right[0].Pos, b.Op, left, func() (value.Value, error) {
+			return ctx.compileBitwiseXor(b.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
 	if _, ok := left.Type().(*types.IntType); len(b.Right) != 0 && !ok {
 		return nil, posError(b.Left.Pos, "bitwise xor operator requires integer operands")
 	}
@@ -180,6 +338,15 @@ func (ctx *Context) compileBitwiseOr(b *parser.BitwiseOr) (value.Value, error) {
 		return nil, err
 	}
 
+	if len(b.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(b.Right[0].Pos, b.Op, left, func() (value.Value, error) {
+			return ctx.compileBitwiseOr(b.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
 	if _, ok := left.Type().(*types.IntType); len(b.Right) != 0 && !ok {
 		return nil, posError(b.Left.Pos, "bitwise or operator requires integer operands")
 	}
@@ -214,9 +381,17 @@ func (ctx *Context) compileEquality(e *parser.Equality) (value.Value, error) {
 		return nil, err
 	}
 
+	if len(e.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(e.Right[0].Pos, e.Op, left, func() (value.Value, error) {
+			return ctx.compileEquality(e.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
 	lrop := e.Op
 	for _, right := range e.Right {
-		ctx.RequestedType = left.Type()
 		rightVal, err := ctx.compileEquality(right)
 		if err != nil {
 			return nil, err
@@ -258,13 +433,21 @@ func (ctx *Context) compileRelational(r *parser.Relational) (value.Value, error)
 		return nil, err
 	}
 
+	if len(r.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(r.Right[0].Pos, r.Op, left, func() (value.Value, error) {
+			return ctx.compileRelational(r.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
 	if len(r.Right) != 0 && !isNumeric(left.Type()) {
 		return nil, posError(r.Left.Pos, "relational operator requires numeric operands")
 	}
 
 	lrop := r.Op
 	for _, right := range r.Right {
-		ctx.RequestedType = left.Type()
 		rightVal, err := ctx.compileRelational(right)
 		if err != nil {
 			return nil, err
@@ -282,28 +465,37 @@ func (ctx *Context) compileRelational(r *parser.Relational) (value.Value, error)
 			return nil, posError(right.Pos, "operands must be the same type (%s != %s)", left.Type(), rightVal.Type())
 		}
 
+		unsigned := isUnsigned(left.Type()) || isUnsigned(rightVal.Type())
 		switch lrop {
 		case "<=":
 			if types.IsFloat(left.Type()) {
 				left = ctx.NewFCmp(enum.FPredOLE, left, rightVal)
+			} else if unsigned {
+				left = ctx.NewICmp(enum.IPredULE, left, rightVal)
 			} else {
 				left = ctx.NewICmp(enum.IPredSLE, left, rightVal)
 			}
 		case ">=":
 			if types.IsFloat(left.Type()) {
 				left = ctx.NewFCmp(enum.FPredOGE, left, rightVal)
+			} else if unsigned {
+				left = ctx.NewICmp(enum.IPredUGE, left, rightVal)
 			} else {
 				left = ctx.NewICmp(enum.IPredSGE, left, rightVal)
 			}
 		case "<":
 			if types.IsFloat(left.Type()) {
 				left = ctx.NewFCmp(enum.FPredOLT, left, rightVal)
+			} else if unsigned {
+				left = ctx.NewICmp(enum.IPredULT, left, rightVal)
 			} else {
 				left = ctx.NewICmp(enum.IPredSLT, left, rightVal)
 			}
 		case ">":
 			if types.IsFloat(left.Type()) {
 				left = ctx.NewFCmp(enum.FPredOGT, left, rightVal)
+			} else if unsigned {
+				left = ctx.NewICmp(enum.IPredUGT, left, rightVal)
 			} else {
 				left = ctx.NewICmp(enum.IPredSGT, left, rightVal)
 			}
@@ -322,6 +514,15 @@ func (ctx *Context) compileShift(s *parser.Shift) (value.Value, error) {
 		return nil, err
 	}
 
+	if len(s.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(s.Right[0].Pos, s.Op, left, func() (value.Value, error) {
+			return ctx.compileShift(s.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
 	if _, ok := left.Type().(*types.IntType); len(s.Right) != 0 && !ok {
 		return nil, posError(s.Left.Pos, "shift operator requires integer operands")
 	}
@@ -348,7 +549,14 @@ func (ctx *Context) compileShift(s *parser.Shift) (value.Value, error) {
 		switch lrop {
 		case "<<":
 			left = ctx.NewShl(left, rightVal)
-		case ">>", ">>>":
+		case ">>":
+			// Shift right is arithmetic for signed and logical for unsigned integers
+			if isUnsigned(left.Type()) {
+				left = ctx.NewLShr(left, rightVal)
+			} else {
+				left = ctx.NewAShr(left, rightVal)
+			}
+		case ">>>":
 			left = ctx.NewLShr(left, rightVal)
 		default:
 			return nil, posError(right.Pos, "unknown shift operator: %s", lrop)
@@ -365,15 +573,22 @@ func (ctx *Context) compileAdditive(a *parser.Additive) (value.Value, error) {
 		return nil, err
 	}
 
+	if len(a.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(a.Right[0].Pos, a.Op, left, func() (value.Value, error) {
+			return ctx.compileAdditive(a.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
 	if len(a.Right) != 0 && !isNumeric(left.Type()) {
 		return nil, posError(a.Left.Pos, "additive operator requires numeric operands")
 	}
 
 	lrop := a.Op
 	for _, right := range a.Right {
-		ctx.RequestedType = left.Type()
 		rightVal, err := ctx.compileAdditive(right)
-		ctx.RequestedType = nil
 		if err != nil {
 			return nil, err
 		}
@@ -390,21 +605,6 @@ func (ctx *Context) compileAdditive(a *parser.Additive) (value.Value, error) {
 			return nil, posError(right.Pos, "operands must be the same type (%s != %s)", left.Type(), rightVal.Type())
 		}
 
-		switch leftType := left.(type) {
-		case *ir.InstLoad, *ir.InstCall, *ir.InstAlloca:
-			if ptrType, ok := leftType.Type().(*types.PointerType); ok {
-				if structType, ok := ptrType.ElemType.(*types.StructType); ok {
-					// Check if the class has a method with the name "classname.op.operator"
-					methodName := fmt.Sprintf("%s.op.%s", structType.Name(), a.Op)
-					if method, ok := ctx.lookupFunction(methodName); ok {
-						// Call the method and use its result as the result
-						left = ctx.NewCall(method, left, rightVal)
-						continue
-					}
-				}
-			}
-		}
-
 		switch lrop {
 		case "+":
 			if types.IsFloat(left.Type()) {
@@ -433,15 +633,22 @@ func (ctx *Context) compileMultiplicative(m *parser.Multiplicative) (value.Value
 		return nil, err
 	}
 
+	if len(m.Right) != 0 {
+		overloaded, err := ctx.compileBinaryOverload(m.Right[0].Pos, m.Op, left, func() (value.Value, error) {
+			return ctx.compileMultiplicative(m.Right[0])
+		})
+		if overloaded != nil || err != nil {
+			return overloaded, err
+		}
+	}
+
 	if len(m.Right) != 0 && !isNumeric(left.Type()) {
 		return nil, posError(m.Left.Pos, "multiplicative operator requires numeric operands")
 	}
 
 	lrop := m.Op
 	for _, right := range m.Right {
-		ctx.RequestedType = left.Type()
 		rightVal, err := ctx.compileMultiplicative(right)
-		ctx.RequestedType = nil
 		if err != nil {
 			return nil, err
 		}
@@ -458,21 +665,7 @@ func (ctx *Context) compileMultiplicative(m *parser.Multiplicative) (value.Value
 			return nil, posError(right.Pos, "operands must be the same type (%s != %s)", left.Type(), rightVal.Type())
 		}
 
-		switch leftType := left.(type) {
-		case *ir.InstLoad, *ir.InstCall, *ir.InstAlloca:
-			if ptrType, ok := leftType.Type().(*types.PointerType); ok {
-				if structType, ok := ptrType.ElemType.(*types.StructType); ok {
-					// Check if the class has a method with the name "classname.op.operator"
-					methodName := fmt.Sprintf("%s.op.%s", structType.Name(), right.Op)
-					if method, ok := ctx.lookupFunction(methodName); ok {
-						// Call the method and use its result as the result
-						left = ctx.NewCall(method, left, rightVal)
-						continue
-					}
-				}
-			}
-		}
-
+		unsigned := isUnsigned(left.Type()) || isUnsigned(rightVal.Type())
 		switch lrop {
 		case "*":
 			if types.IsFloat(left.Type()) {
@@ -483,12 +676,16 @@ func (ctx *Context) compileMultiplicative(m *parser.Multiplicative) (value.Value
 		case "/":
 			if types.IsFloat(left.Type()) {
 				left = ctx.NewFDiv(left, rightVal)
+			} else if unsigned {
+				left = ctx.NewUDiv(left, rightVal)
 			} else {
 				left = ctx.NewSDiv(left, rightVal)
 			}
 		case "%":
 			if types.IsFloat(left.Type()) {
 				left = ctx.NewFRem(left, rightVal)
+			} else if unsigned {
+				left = ctx.NewURem(left, rightVal)
 			} else {
 				left = ctx.NewSRem(left, rightVal)
 			}
@@ -508,7 +705,10 @@ func (ctx *Context) compileLogicalNot(l *parser.LogicalNot) (value.Value, error)
 	}
 
 	if l.Op != "" {
-		if right.Type() != types.I1 {
+		if overloaded, err := ctx.compileUnaryOverload(l.Pos, l.Op, right); overloaded != nil || err != nil {
+			return overloaded, err
+		}
+		if !isBool(right.Type()) {
 			return nil, posError(l.Right.Pos, "logical not operator requires a boolean operand")
 		}
 		right = ctx.NewXor(right, constant.NewInt(types.I1, 1))
@@ -524,6 +724,9 @@ func (ctx *Context) compileBitwiseNot(b *parser.BitwiseNot) (value.Value, error)
 	}
 
 	if b.Op != "" {
+		if overloaded, err := ctx.compileUnaryOverload(b.Pos, b.Op, right); overloaded != nil || err != nil {
+			return overloaded, err
+		}
 		intType, ok := right.Type().(*types.IntType)
 		if !ok {
 			return nil, posError(b.Right.Pos, "bitwise not operator requires an integer operand")
@@ -536,82 +739,167 @@ func (ctx *Context) compileBitwiseNot(b *parser.BitwiseNot) (value.Value, error)
 	return right, nil
 }
 
+// intOne returns the 1 ++ and -- add to an integer of type t.
+func intOne(t types.Type) constant.Constant {
+	if intType, ok := t.(*types.IntType); ok {
+		return constant.NewInt(intType, 1)
+	}
+	return constant.NewInt(types.I8, 1)
+}
+
 func (ctx *Context) compilePrefixAdditive(p *parser.PrefixAdditive) (value.Value, error) {
+	if p.Op != "" && p.Right.Op == "" {
+		return ctx.compileIncrement(p.Pos, p.Op, p.Right.Left, false)
+	}
 	right, err := ctx.compilePostfixAdditive(p.Right)
 	if err != nil {
 		return nil, err
 	}
-
 	if p.Op != "" {
-		if _, ok := right.Type().(*types.FloatType); ok {
-			if p.Op == "++" {
-				return ctx.NewFAdd(right, constant.NewFloat(types.Float, 1)), nil
-			} else {
-				return ctx.NewFSub(right, constant.NewFloat(types.Float, 1)), nil
-			}
-		} else {
-			if p.Op == "++" {
-				return ctx.NewAdd(right, constant.NewInt(types.I8, 1)), nil
-			} else {
-				return ctx.NewSub(right, constant.NewInt(types.I8, 1)), nil
-			}
-		}
+		return ctx.incremented(p.Pos, p.Op, right)
 	}
-
 	return right, nil
 }
 
 func (ctx *Context) compilePostfixAdditive(p *parser.PostfixAdditive) (value.Value, error) {
-	left, err := ctx.compileFactor(p.Left)
+	if p.Op != "" {
+		return ctx.compileIncrement(p.Pos, p.Op, p.Left, true)
+	}
+	return ctx.compileFactor(p.Left)
+}
+
+// compileIncrement compiles op, ++ or --, applied to f. The new value is
+// stored back to the variable, field or property f refers to. Postfix
+// operators return the value f had before, prefix operators the new one.
+func (ctx *Context) compileIncrement(pos lexer.Position, op string, f *parser.Factor, postfix bool) (value.Value, error) {
+	if f.Identifier == nil {
+		val, err := ctx.compileFactor(f)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.incremented(pos, op, val)
+	}
+	if _, _, ok := ctx.enumVariant(f.Identifier); ok {
+		return nil, posError(pos, "Operator %s can not be used on an enum variant", op)
+	}
+	if err := ctx.checkIncrement(op, f); err != nil {
+		return nil, err
+	}
+
+	target, _, prop, err := ctx.compileAssignmentTarget(f.Identifier)
 	if err != nil {
 		return nil, err
 	}
+	if prop != nil {
+		return ctx.incrementProperty(pos, op, prop, postfix)
+	}
 
-	if p.Op != "" {
-		original := left
-		if _, ok := left.Type().(*types.FloatType); ok {
-			if p.Op == "++" {
-				left = ctx.NewFAdd(left, constant.NewFloat(types.Float, 1))
-			} else {
-				left = ctx.NewFSub(left, constant.NewFloat(types.Float, 1))
-			}
-		} else {
-			if p.Op == "++" {
-				left = ctx.NewAdd(left, constant.NewInt(types.I8, 1))
-			} else {
-				left = ctx.NewSub(left, constant.NewInt(types.I8, 1))
-			}
+	var current value.Value = target
+	inMemory := false
+	switch target.(type) {
+	case *ir.InstAlloca, *ir.Global, *ir.InstGetElementPtr:
+		elemType := target.Type().(*types.PointerType).ElemType
+		// Instances are used through the pointer to them
+		if _, isStruct := elemType.(*types.StructType); !isStruct {
+			current = ctx.NewLoad(elemType, target)
+			inMemory = true
 		}
-		if _, ok := original.Type().(*types.PointerType); ok {
-			ctx.NewStore(left, original)
-		} else if ctx.DestPtr != nil {
-			ctx.NewStore(left, ctx.DestPtr)
-			ctx.StoredInDest = true
-			ctx.DestPtr = nil
+	}
+	result, err := ctx.incremented(pos, op, current)
+	if err != nil {
+		return nil, err
+	}
+
+	if inMemory && result.Type().Equal(current.Type()) {
+		ctx.NewStore(result, target)
+	} else if !inMemory && f.Identifier.Sub == nil {
+		// Values that are not stored in memory, like parameters, are rebound
+		if v := ctx.lookupVariable(f.Identifier.Name); v != nil && v.Value == target {
+			ctx.vars[f.Identifier.Name] = &Variable{Name: v.Name, Type: v.Type, Value: result, Pos: v.Pos}
 		}
-		return original, nil
 	}
+	if postfix {
+		return current, nil
+	}
+	return result, nil
+}
 
-	return left, nil
+// incrementProperty applies op, ++ or --, to the property p by calling its
+// getter and passing the new value to its setter.
+func (ctx *Context) incrementProperty(pos lexer.Position, op string, p *property, postfix bool) (value.Value, error) {
+	if p.getter == nil {
+		return nil, posError(pos, "Property %s can not be read, it has no getter", p.name)
+	}
+	if len(p.setter.Sig.Params) != 2 {
+		return nil, posError(pos, "Setter of property %s must take a single argument", p.name)
+	}
+	getterThis, err := ctx.convertValue(pos, p.instance, p.getter.Sig.Params[0])
+	if err != nil {
+		return nil, err
+	}
+	current := ctx.NewCall(p.getter, getterThis)
+	result, err := ctx.incremented(pos, op, current)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Type().Equal(p.setter.Sig.Params[1]) {
+		return nil, posError(pos, "Cannot assign %s to property %s of type %s", ctx.TypeToString(result.Type()), p.name, ctx.TypeToString(p.setter.Sig.Params[1]))
+	}
+	setterThis, err := ctx.convertValue(pos, p.instance, p.setter.Sig.Params[0])
+	if err != nil {
+		return nil, err
+	}
+	ctx.NewCall(p.setter, setterThis, result)
+	if postfix {
+		return current, nil
+	}
+	return result, nil
+}
+
+// incremented returns val with op, ++ or --, applied to it. Classes
+// overloading the operator return the result of the overload.
+func (ctx *Context) incremented(pos lexer.Position, op string, val value.Value) (value.Value, error) {
+	if overloaded, err := ctx.compileUnaryOverload(pos, op, val); overloaded != nil || err != nil {
+		return overloaded, err
+	}
+	if floatType, ok := val.Type().(*types.FloatType); ok {
+		if op == "++" {
+			return ctx.NewFAdd(val, constant.NewFloat(floatType, 1)), nil
+		}
+		return ctx.NewFSub(val, constant.NewFloat(floatType, 1)), nil
+	}
+	if !isNumeric(val.Type()) {
+		return nil, posError(pos, "Operator %s requires a numeric operand, got %s", op, ctx.TypeToString(val.Type()))
+	}
+	if op == "++" {
+		return ctx.NewAdd(val, intOne(val.Type())), nil
+	}
+	return ctx.NewSub(val, intOne(val.Type())), nil
+}
+
+// checkIncrement returns an error if op, ++ or --, is used on a constant.
+func (ctx *Context) checkIncrement(op string, f *parser.Factor) error {
+	if op == "++" {
+		return ctx.checkMutable(f.Identifier, "increment")
+	}
+	return ctx.checkMutable(f.Identifier, "decrement")
 }
 
 func (ctx *Context) compileFactor(f *parser.Factor) (value.Value, error) {
 	if f.Value != nil {
 		return ctx.compileValue(f.Value)
+	} else if name, variant, ok := ctx.enumVariant(f.Identifier); ok {
+		return ctx.enumConstant(f.Identifier.Pos, name, variant)
 	} else if f.Identifier != nil {
 		val, _, err := ctx.compileIdentifier(f.Identifier, false)
 		if err != nil {
 			return nil, err
 		}
-		ctx.DestPtr = val
-		if v, ok := val.(*ir.InstAlloca); ok {
-			elemType := v.Type().(*types.PointerType).ElemType
+		if isVariableStorage(val) {
+			elemType := val.Type().(*types.PointerType).ElemType
 			if _, isStruct := elemType.(*types.StructType); isStruct {
 				return val, nil
 			}
-			if _, isPointer := elemType.(*types.PointerType); isPointer {
-				return val, nil
-			}
 			return ctx.NewLoad(elemType, val), nil
 		} else if v, ok := val.(*ir.InstPhi); ok {
 			return ctx.NewLoad(v.Type().(*types.PointerType).ElemType, val), nil
@@ -633,7 +921,6 @@ func (ctx *Context) compileFactor(f *parser.Factor) (value.Value, error) {
 }
 
 func (ctx *Context) compileBitCast(bc *parser.BitCast) (value.Value, error) {
-	ctx.RequestedType = nil
 	val, err := ctx.compileExpression(bc.Expr)
 	if err != nil {
 		return nil, err
@@ -647,6 +934,16 @@ func (ctx *Context) compileBitCast(bc *parser.BitCast) (value.Value, error) {
 
 	// If the value is already of the target type, just return it
 	if val.Type().Equal(targetType) {
+		_, isInt := targetType.(*types.IntType)
+		_, fromEnum := ctx.enumOf(val.Type())
+		_, toEnum := ctx.enumOf(targetType)
+		if isUnsigned(val.Type()) != isUnsigned(targetType) || isInt && (fromEnum || toEnum) && val.Type() != targetType {
+			// Only the signedness or enum type changes, the bits stay the same
+			if c, ok := val.(*constant.Int); ok {
+				return &constant.Int{Typ: targetType.(*types.IntType), X: c.X}, nil
+			}
+			return ctx.NewBitCast(val, targetType), nil
+		}
 		return val, nil
 	}
 
@@ -656,34 +953,8 @@ func (ctx *Context) compileBitCast(bc *parser.BitCast) (value.Value, error) {
 		}
 	}
 
-	// Predefined bitcasts
-	switch targetType {
-	case types.Double:
-		if val.Type().Equal(types.Float) {
-			return ctx.NewFPExt(val, types.Double), nil
-		}
-		if _, ok := val.Type().(*types.IntType); ok {
-			return ctx.NewSIToFP(val, targetType), nil
-		}
-	case types.Float:
-		if val.Type().Equal(types.Double) {
-			return ctx.NewFPTrunc(val, types.Float), nil
-		}
-		if _, ok := val.Type().(*types.IntType); ok {
-			return ctx.NewSIToFP(val, targetType), nil
-		}
-	}
-
-	if valType, ok := val.Type().(*types.IntType); ok {
-		if targetType, ok := targetType.(*types.IntType); ok {
-			if valType.BitSize < targetType.BitSize {
-				// Extend if valType is smaller than targetType
-				return ctx.NewSExt(val, targetType), nil
-			} else if valType.BitSize > targetType.BitSize {
-				// Truncate if valType is larger than targetType
-				return ctx.NewTrunc(val, targetType), nil
-			}
-		}
+	if conv, ok := ctx.convertNumber(val, targetType); ok {
+		return conv, nil
 	}
 
 	// If the value is a struct type or a pointer to a struct type, try to find a conversion function
@@ -714,32 +985,103 @@ func (ctx *Context) compileBitCast(bc *parser.BitCast) (value.Value, error) {
 	return nil, posError(bc.Pos, "Cannot convert %s to %s", val.Type().Name(), ctx.CFTypeToLLType(bc.Type).Name())
 }
 
-func (ctx *Context) compileClassInitializer(ci *parser.ClassInitializer) (value.Value, error) {
-	// Lookup the class
-	class, exists := ctx.lookupClass(ci.ClassName)
-	if !exists {
-		return nil, posError(ci.Pos, "Class %s not found", ci.ClassName)
+// convertNumber converts the integer or float val to the number type target,
+// keeping its value as far as target can hold it. Converting to a bool
+// compares the value with zero.
+func (ctx *Context) convertNumber(val value.Value, target types.Type) (value.Value, bool) {
+	switch from := val.Type().(type) {
+	case *types.IntType:
+		switch to := target.(type) {
+		case *types.IntType:
+			switch {
+			case to.BitSize == 1 && from.BitSize > 1:
+				return ctx.NewICmp(enum.IPredNE, val, constant.NewInt(from, 0)), true
+			case from.BitSize < to.BitSize && isUnsigned(from):
+				return ctx.NewZExt(val, to), true
+			case from.BitSize < to.BitSize:
+				return ctx.NewSExt(val, to), true
+			case from.BitSize > to.BitSize:
+				return ctx.NewTrunc(val, to), true
+			}
+		case *types.FloatType:
+			if isUnsigned(from) || from.BitSize == 1 {
+				return ctx.NewUIToFP(val, to), true
+			}
+			return ctx.NewSIToFP(val, to), true
+		}
+	case *types.FloatType:
+		switch to := target.(type) {
+		case *types.IntType:
+			switch {
+			case to.BitSize == 1:
+				// NaN converts to true, like in C
+				return ctx.NewFCmp(enum.FPredUNE, val, constant.NewFloat(from, 0)), true
+			case isUnsigned(to):
+				return ctx.NewFPToUI(val, to), true
+			default:
+				return ctx.NewFPToSI(val, to), true
+			}
+		case *types.FloatType:
+			switch {
+			case floatBits(from) < floatBits(to):
+				return ctx.NewFPExt(val, to), true
+			case floatBits(from) > floatBits(to):
+				return ctx.NewFPTrunc(val, to), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// floatBits returns the size of floats of type t in bits.
+func floatBits(t *types.FloatType) int {
+	switch t.Kind {
+	case types.FloatKindHalf:
+		return 16
+	case types.FloatKindFloat:
+		return 32
+	case types.FloatKindDouble:
+		return 64
+	case types.FloatKindX86_FP80:
+		return 80
+	default:
+		return 128
 	}
-	class = class.(*types.StructType)
-	var classPtr value.Value
-	if ctx.DestPtr == nil {
-		classPtr = ctx.NewAlloca(class)
+}
+
+func (ctx *Context) compileClassInitializer(ci *parser.ClassInitializer) (value.Value, error) {
+	// Lookup the class, generic classes are instantiated for the type arguments
+	var class types.Type
+	if len(ci.TypeArgs) > 0 {
+		var err error
+		class, err = ctx.instantiateClass(ci.Pos, ci.ClassName, ctx.typeArgs(ci.TypeArgs))
+		if err != nil {
+			return nil, err
+		}
 	} else {
-		classPtr = ctx.DestPtr
-		ctx.StoredInDest = true
+		var exists bool
+		class, exists = ctx.lookupClass(ci.ClassName)
+		if !exists {
+			return nil, undefinedError(ci.Pos, "Class %s not found", ci.ClassName).Suggest(ci.ClassName, ctx.classNames())
+		}
 	}
+	// Instances may outlive the function creating them
+	classPtr := ctx.newInstance(class.(*types.StructType))
+	ctx.initInstance(classPtr, class.(*types.StructType))
 
-	// Initialize the class
-	constructor, exists := ctx.lookupFunction(class.Name() + ".constructor")
+	// Initialize the class, classes without a constructor use the one of their parent
+	method, exists := ctx.lookupMethod(types.NewPointer(class), "constructor")
 	if exists {
+		if err := ctx.checkAccess(ci.Pos, class, "constructor"); err != nil {
+			return nil, err
+		}
+		constructor := method.(*ir.Func)
 		if len(ci.Args.Arguments) != len(constructor.Sig.Params)-1 {
 			return nil, posError(ci.Pos, "Invalid number of arguments for class constructor")
 		}
 		// Compile the arguments
 		compiledArgs := make([]value.Value, len(ci.Args.Arguments))
 		for i, arg := range ci.Args.Arguments {
-			ctx.RequestedType = constructor.Sig.Params[i+1]
-			ctx.DestPtr = nil
 			expr, err := ctx.compileExpression(arg)
 			if err != nil {
 				return nil, err
@@ -748,7 +1090,11 @@ func (ctx *Context) compileClassInitializer(ci *parser.ClassInitializer) (value.
 		}
 
 		// Call the constructor
-		ctx.NewCall(constructor, append([]value.Value{classPtr}, compiledArgs...)...)
+		this, err := ctx.convertValue(ci.Pos, classPtr, constructor.Sig.Params[0])
+		if err != nil {
+			return nil, err
+		}
+		ctx.NewCall(constructor, append([]value.Value{this}, compiledArgs...)...)
 	}
 
 	// Return the class pointer
@@ -758,22 +1104,24 @@ func (ctx *Context) compileClassInitializer(ci *parser.ClassInitializer) (value.
 func (ctx *Context) compileFunctionCall(fc *parser.FunctionCall) (value.Value, error) {
 	// Lookup the function
 	fc.FunctionName = strings.Trim(fc.FunctionName, "\"")
+	if _, ok := ctx.lookupGeneric(fc.FunctionName); ok {
+		return ctx.compileGenericCall(fc)
+	}
 	function, exists := ctx.lookupFunction(fc.FunctionName)
 	if !exists {
-		return nil, posError(fc.Pos, "Function %s not found", fc.FunctionName)
+		return nil, undefinedError(fc.Pos, "Function %s not found", fc.FunctionName).Suggest(fc.FunctionName, ctx.functionNames())
+	}
+	if len(fc.TypeArgs) > 0 {
+		return nil, posError(fc.Pos, "Function %s is not generic", fc.FunctionName)
 	}
 
 	// Compile the arguments
 	compiledArgs := make([]value.Value, len(fc.Args.Arguments))
 	for i, arg := range fc.Args.Arguments {
-		if i < len(function.Sig.Params) {
-			ctx.RequestedType = function.Sig.Params[i]
-		}
 		expr, err := ctx.compileExpression(arg)
 		if err != nil {
 			return nil, err
 		}
-		ctx.RequestedType = nil
 		compiledArgs[i] = expr
 	}
 
@@ -781,51 +1129,61 @@ func (ctx *Context) compileFunctionCall(fc *parser.FunctionCall) (value.Value, e
 	return ctx.NewCall(function, compiledArgs...), nil
 }
 
+// compileGenericCall calls the instance of a generic function for the type
+// arguments of the call, which are inferred from the arguments when omitted.
+func (ctx *Context) compileGenericCall(fc *parser.FunctionCall) (value.Value, error) {
+	var function *ir.Func
+	if len(fc.TypeArgs) > 0 {
+		var err error
+		function, err = ctx.instantiateFunction(fc.Pos, fc.FunctionName, ctx.typeArgs(fc.TypeArgs))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	compiledArgs := make([]value.Value, len(fc.Args.Arguments))
+	for i, arg := range fc.Args.Arguments {
+		expr, err := ctx.compileExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		compiledArgs[i] = expr
+	}
+
+	if function == nil {
+		args, err := ctx.inferTypeArgs(fc.Pos, fc.FunctionName, compiledArgs)
+		if err != nil {
+			return nil, err
+		}
+		function, err = ctx.instantiateFunction(fc.Pos, fc.FunctionName, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ctx.NewCall(function, compiledArgs...), nil
+}
+
 func (ctx *Context) compileValue(v *parser.Value) (value.Value, error) {
+	// Literals get the type the checker gave them, numbers and null that
+	// were not given one are i64, f64 and *i8
+	typ := ctx.literalType(v)
 	if v.Float != nil {
-		if ctx.RequestedType != nil {
-			if ptrType, ok := ctx.RequestedType.(*types.PointerType); ok {
-				if floatType, ok := ptrType.ElemType.(*types.FloatType); ok {
-					return constant.NewFloat(floatType, *v.Float), nil
-				}
-			} else if ctx.RequestedType == types.Float {
-				return constant.NewFloat(types.Float, *v.Float), nil
-			} else if ctx.RequestedType == types.Double {
-				return constant.NewFloat(types.Double, *v.Float), nil
-			} else if ctx.RequestedType == types.Half {
-				return constant.NewFloat(types.Half, *v.Float), nil
-			} else if ctx.RequestedType == types.FP128 {
-				return constant.NewFloat(types.FP128, *v.Float), nil
-			} else if ctx.RequestedType == types.I32 {
-				return constant.NewInt(types.I32, int64(*v.Float)), nil
-			} else if ctx.RequestedType == types.I64 {
-				return constant.NewInt(types.I64, int64(*v.Float)), nil
-			} else if ctx.RequestedType == types.I1 {
-				if *v.Float == 0 {
-					return constant.NewInt(types.I1, 0), nil
-				} else {
-					return constant.NewInt(types.I1, 1), nil
-				}
+		switch t := typ.(type) {
+		case *types.IntType:
+			if t.BitSize == 1 && *v.Float != 0 {
+				return constant.NewInt(t, 1), nil
 			}
+			return constant.NewInt(t, int64(*v.Float)), nil
+		case *types.FloatType:
+			return constant.NewFloat(t, *v.Float), nil
 		}
 		return constant.NewFloat(types.Double, *v.Float), nil
 	} else if v.Int != nil {
-		if ctx.RequestedType != nil {
-			if ptrType, ok := ctx.RequestedType.(*types.PointerType); ok {
-				if intType, ok := ptrType.ElemType.(*types.IntType); ok {
-					return constant.NewInt(intType, *v.Int), nil
-				}
-			} else if intType, ok := ctx.RequestedType.(*types.IntType); ok {
-				return constant.NewInt(intType, *v.Int), nil
-			} else if ctx.RequestedType == types.Float {
-				return constant.NewFloat(types.Float, float64(*v.Int)), nil
-			} else if ctx.RequestedType == types.Double {
-				return constant.NewFloat(types.Double, float64(*v.Int)), nil
-			} else if ctx.RequestedType == types.Half {
-				return constant.NewFloat(types.Half, float64(*v.Int)), nil
-			} else if ctx.RequestedType == types.FP128 {
-				return constant.NewFloat(types.FP128, float64(*v.Int)), nil
-			}
+		switch t := typ.(type) {
+		case *types.IntType:
+			return constant.NewInt(t, *v.Int), nil
+		case *types.FloatType:
+			return constant.NewFloat(t, float64(*v.Int)), nil
 		}
 		return constant.NewInt(types.I64, *v.Int), nil
 	} else if v.Bool != nil {
@@ -842,42 +1200,145 @@ func (ctx *Context) compileValue(v *parser.Value) (value.Value, error) {
 		strGlobal := ctx.Module.NewGlobalDef("", constant.NewCharArrayFromString(str+"\000"))
 		strGlobal.Immutable = true
 		strGlobal.Linkage = enum.LinkagePrivate
-		return strGlobal, nil
+		return constant.NewGetElementPtr(strGlobal.ContentType, strGlobal, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0)), nil
 	} else if v.Null {
+		if ptrType, ok := typ.(*types.PointerType); ok {
+			return constant.NewNull(ptrType), nil
+		}
 		return constant.NewNull(types.I8Ptr), nil
+	} else if v.IsArray {
+		return ctx.compileArray(v, typ)
 	} else {
 		return nil, posError(v.Pos, "Unknown value type")
 	}
 }
 
-func (ctx *Context) compileIdentifier(i *parser.Identifier, returnTopLevelStruct bool) (value.Value, types.Type, error) {
-	val := ctx.lookupVariable(i.Name)
-	if val == nil {
-		return nil, nil, posError(i.Pos, "Variable %s not found", i.Name)
+// literalType returns the type the checker gave the literal v, or nil if it
+// did not give it one.
+func (ctx *Context) literalType(v *parser.Value) types.Type {
+	typ, _ := ctx.checkedType(ctx.Info.Literals[v])
+	return typ
+}
+
+// compileArray compiles the array literal v of type typ into an array value,
+// which is a constant if all of the elements are constant.
+func (ctx *Context) compileArray(v *parser.Value, typ types.Type) (value.Value, error) {
+	arrType, ok := typ.(*types.ArrayType)
+	if !ok {
+		return nil, posError(v.Pos, "Unable to infer the type of an empty array literal")
 	}
 
-	if i.Sub == nil {
-		if i.GEP != nil {
-			ctx.RequestedType = types.I32
-			gepExpr, err := ctx.compileExpression(i.GEP)
-			if err != nil {
-				return nil, nil, err
-			}
-			ctx.RequestedType = nil
-
-			var elementType types.Type
-			switch t := val.Type.(type) {
-			case *types.PointerType:
-				elementType = t.ElemType
-			case *types.ArrayType:
-				elementType = t.ElemType
-			default:
-				return nil, nil, posError(i.GEP.Pos, "unsupported type for GetElementPtr: %s", t)
-			}
+	elems := make([]value.Value, len(v.Array))
+	isConstant := true
+	for i, e := range v.Array {
+		elem, err := ctx.compileExpression(e)
+		if err != nil {
+			return nil, err
+		}
+		if !elem.Type().Equal(arrType.ElemType) {
+			return nil, posError(e.Pos, "Array element must be of type %s, got %s", arrType.ElemType, elem.Type())
+		}
+		if _, ok := elem.(constant.Constant); !ok {
+			isConstant = false
+		}
+		elems[i] = elem
+	}
+
+	if isConstant {
+		consts := make([]constant.Constant, len(elems))
+		for i, elem := range elems {
+			consts[i] = elem.(constant.Constant)
+		}
+		if len(consts) == 0 {
+			return constant.NewZeroInitializer(arrType), nil
+		}
+		return constant.NewArray(arrType, consts...), nil
+	}
+
+	var arr value.Value = constant.NewZeroInitializer(arrType)
+	for i, elem := range elems {
+		arr = ctx.NewInsertValue(arr, elem, uint64(i))
+	}
+	return arr, nil
+}
+
+// compileIndex returns a pointer to an element of an array, or of the memory
+// a pointer points to. The value may also be the storage of the array or
+// pointer, as returned for variables and fields.
+func (ctx *Context) compileIndex(typ types.Type, val value.Value, index *parser.Expression) (value.Value, types.Type, error) {
+	idx, err := ctx.compileExpression(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := idx.Type().(*types.IntType); !ok {
+		return nil, nil, posError(index.Pos, "Index must be an integer, got %s", idx.Type())
+	}
+
+	switch t := typ.(type) {
+	case *types.ArrayType:
+		if c, ok := idx.(*constant.Int); ok && (c.X.Sign() < 0 || c.X.Uint64() >= t.Len) {
+			return nil, nil, posError(index.Pos, "Index %s is out of bounds for an array of length %d", c.X, t.Len)
+		}
+		if !isStorage(val, t) {
+			arrPtr := ctx.NewAlloca(t)
+			ctx.NewStore(val, arrPtr)
+			val = arrPtr
+		}
+		return ctx.NewGetElementPtr(t, val, constant.NewInt(types.I32, 0), idx), t.ElemType, nil
+	case *types.PointerType:
+		if isStorage(val, t) {
+			val = ctx.NewLoad(t, val)
+		}
+		return ctx.NewGetElementPtr(t.ElemType, val, idx), t.ElemType, nil
+	default:
+		return nil, nil, posError(index.Pos, "Cannot index a value of type %s", typ)
+	}
+}
+
+// resolveModule rewrites an identifier qualified with the alias of an imported
+// module, like io.File.open, so it starts with the name the export was
+// imported as. Other identifiers are returned unchanged.
+func (ctx *Context) resolveModule(i *parser.Identifier) *parser.Identifier {
+	if i == nil || i.Sub == nil || i.GEP != nil || !ctx.modules[i.Name] || ctx.lookupVariable(i.Name) != nil {
+		return i
+	}
+	resolved := *i.Sub
+	resolved.Name = i.Name + "." + i.Sub.Name
+	resolved.Ref = i.Ref
+	resolved.Deref = i.Deref
+	return &resolved
+}
+
+// resolveQualified resolves the module and static field an identifier may
+// start with.
+func (ctx *Context) resolveQualified(i *parser.Identifier) (*parser.Identifier, error) {
+	return ctx.resolveStatic(ctx.resolveModule(i))
+}
 
-			v := ctx.NewGetElementPtr(elementType, val.Value, gepExpr)
-			return v, elementType, nil
+func (ctx *Context) compileIdentifier(i *parser.Identifier, returnTopLevelStruct bool) (value.Value, types.Type, error) {
+	i, err := ctx.resolveQualified(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	v := ctx.lookupVariable(i.Name)
+	if v == nil {
+		return nil, nil, undefinedError(i.Pos, "Variable %s not found", i.Name).Suggest(i.Name, ctx.variableNames())
+	}
+	// Work on a copy, referencing and dereferencing must not change the variable
+	val := *v
+
+	if i.GEP != nil {
+		elemPtr, elemType, err := ctx.compileIndex(val.Type, val.Value, i.GEP)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i.Sub == nil {
+			return elemPtr, elemType, nil
 		}
+		val = Variable{Name: i.Name, Type: elemType, Value: elemPtr}
+	}
+
+	if i.Sub == nil {
 		// Handle referencing
 		for j := 0; j < len(i.Ref); j++ {
 			// Create a pointer to the variable
@@ -893,22 +1354,24 @@ func (ctx *Context) compileIdentifier(i *parser.Identifier, returnTopLevelStruct
 			val.Value = ctx.NewLoad(val.Value.Type().(*types.PointerType).ElemType, val.Value)
 			val.Type = val.Value.Type()
 		}
+
+		if returnTopLevelStruct {
+			return ctx.structInstance(&val), val.Type, nil
+		}
 		return val.Value, val.Type, nil
 	}
 
-	originalVal := val
-
 	// Iterate over the subs
-	currentVal := val
+	currentVal := &val
 	currentSub := i.Sub
 	for currentSub != nil {
-		fieldType, fieldPtr, isMethod, err := ctx.compileSubIdentifier(currentVal, currentSub)
+		_, fieldPtr, isMethod, err := ctx.compileSubIdentifier(currentVal, currentSub)
 		if err != nil {
 			return nil, nil, err
 		}
 		if isMethod {
 			if returnTopLevelStruct {
-				return currentVal.Value, currentVal.Type, nil
+				return ctx.structInstance(currentVal), currentVal.Type, nil
 			} else {
 				return nil, nil, posError(i.Pos, "Cannot call method %s on %s", currentSub.Name, currentVal.Type.Name())
 			}
@@ -937,9 +1400,11 @@ func (ctx *Context) compileIdentifier(i *parser.Identifier, returnTopLevelStruct
 			return fieldPtr, fieldPtr.Type(), nil
 		}
 
-		// Otherwise, load the field and continue
-		if !fieldType.Equal(fieldPtr.Type()) {
-			currentVal.Value = ctx.NewLoad(fieldType, fieldPtr)
+		// Otherwise continue with the field
+		currentVal = &Variable{
+			Name:  currentSub.Name,
+			Type:  fieldPtr.Type().(*types.PointerType).ElemType,
+			Value: fieldPtr,
 		}
 		currentSub = nextSub
 	}
@@ -947,22 +1412,33 @@ func (ctx *Context) compileIdentifier(i *parser.Identifier, returnTopLevelStruct
 	// Handle referencing
 	for j := 0; j < len(i.Ref); j++ {
 		// Create a pointer to the variable
-		ptrType := types.NewPointer(originalVal.Value.Type())
+		ptrType := types.NewPointer(currentVal.Value.Type())
 		ptr := ctx.NewAlloca(ptrType)
-		ctx.NewStore(originalVal.Value, ptr)
-		originalVal.Value = ptr
-		originalVal.Type = ptrType
+		ctx.NewStore(currentVal.Value, ptr)
+		currentVal.Value = ptr
+		currentVal.Type = ptrType
 	}
 
 	// Handle dereferencing
 	for j := 0; j < len(i.Deref); j++ {
 		// Load the value the pointer points to
-		originalVal.Value = ctx.NewLoad(originalVal.Value.Type().(*types.PointerType).ElemType, originalVal.Value)
-		originalVal.Type = originalVal.Value.Type()
+		currentVal.Value = ctx.NewLoad(currentVal.Value.Type().(*types.PointerType).ElemType, currentVal.Value)
+		currentVal.Type = currentVal.Value.Type()
 	}
 
-	// If we're here, we're returning the top-level struct
-	return originalVal.Value, originalVal.Type, nil
+	// If we're here, we're returning the struct a method is called on
+	return ctx.structInstance(currentVal), currentVal.Type, nil
+}
+
+// structInstance returns a pointer to the struct held by a variable or field,
+// loading it first if the variable holds a pointer to the struct.
+func (ctx *Context) structInstance(v *Variable) value.Value {
+	if ptrType, ok := v.Type.(*types.PointerType); ok && isStorage(v.Value, v.Type) {
+		if _, ok := ptrType.ElemType.(*types.StructType); ok {
+			return ctx.NewLoad(v.Type, v.Value)
+		}
+	}
+	return v.Value
 }
 
 func (ctx *Context) compileSubIdentifier(f *Variable, sub *parser.Identifier) (FieldType types.Type, Pointer value.Value, IsMethod bool, err error) {
@@ -972,14 +1448,38 @@ func (ctx *Context) compileSubIdentifier(f *Variable, sub *parser.Identifier) (F
 			return f.Type, f.Value, true, nil
 		}
 
+		// Find the struct that is accessed, it's either stored in the
+		// variable directly or the variable holds a pointer to it
+		structPtr := f.Value
+		structType, ok := f.Type.(*types.StructType)
+		if !ok {
+			if ptrType, isPtr := f.Type.(*types.PointerType); isPtr {
+				structType, ok = ptrType.ElemType.(*types.StructType)
+				if ok && isStorage(structPtr, f.Type) {
+					structPtr = ctx.NewLoad(f.Type, structPtr)
+				}
+			}
+			if !ok {
+				return nil, nil, false, posError(sub.Pos, "Cannot access field %s of non-struct type %s", sub.Name, f.Type)
+			}
+		} else if !isStorage(structPtr, structType) {
+			structPtr = ctx.NewAlloca(structType)
+			ctx.NewStore(f.Value, structPtr)
+		}
+
+		if f.Name != "this" {
+			if getter, ok := ctx.lookupMethod(types.NewPointer(structType), "get."+sub.Name); ok {
+				if err := ctx.checkAccess(sub.Pos, structType, "get."+sub.Name); err != nil {
+					return nil, nil, false, err
+				}
+				typ, ptr, err := ctx.compileGetter(sub, getter.(*ir.Func), structPtr)
+				return typ, ptr, false, err
+			}
+		}
+
 		var field *parser.FieldDefinition
 		var nfield int
-		var elemtypename string
-		if ptrType, ok := f.Type.(*types.PointerType); ok {
-			elemtypename = ptrType.ElemType.Name()
-		} else {
-			elemtypename = f.Type.Name()
-		}
+		elemtypename := structType.Name()
 		for f := range ctx.Compiler.StructFields[elemtypename] {
 			if ctx.Compiler.StructFields[elemtypename][f].Name == sub.Name {
 				field = ctx.Compiler.StructFields[elemtypename][f]
@@ -988,25 +1488,27 @@ func (ctx *Context) compileSubIdentifier(f *Variable, sub *parser.Identifier) (F
 			}
 		}
 		if field == nil {
-			return nil, nil, false, posError(sub.Pos, "Field %s not found in struct %s", sub.Name, elemtypename)
+			var names []string
+			for _, f := range ctx.Compiler.StructFields[elemtypename] {
+				names = append(names, f.Name)
+			}
+			return nil, nil, false, undefinedError(sub.Pos, "Field %s not found in struct %s", sub.Name, elemtypename).Suggest(sub.Name, names)
+		}
+		if err := ctx.checkAccess(sub.Pos, structType, sub.Name); err != nil {
+			return nil, nil, false, err
 		}
 
-		fieldPtr := ctx.NewGetElementPtr(ctx.CFTypeToLLType(field.Type), f.Value, constant.NewInt(types.I32, int64(nfield)))
+		// Class fields come after the vtable pointer
+		if _, isClass := ctx.classOf(structType); isClass {
+			nfield++
+		}
+		fieldPtr := ctx.NewGetElementPtr(structType, structPtr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(nfield)))
 		if sub.GEP != nil {
-			ctx.RequestedType = types.I32
-			gepExpr, err := ctx.compileExpression(sub.GEP)
+			elemPtr, _, err := ctx.compileIndex(ctx.CFTypeToLLType(field.Type), fieldPtr, sub.GEP)
 			if err != nil {
 				return nil, nil, false, err
 			}
-			ctx.RequestedType = nil
-
-			// Load the array pointer
-			arrayPtr := ctx.NewLoad(fieldPtr.Type().(*types.PointerType).ElemType, fieldPtr)
-
-			// Get the pointer to the specific element
-			elemPtr := ctx.NewGetElementPtr(arrayPtr.Type().(*types.PointerType).ElemType, arrayPtr, gepExpr)
-
-			return elemPtr.Type().(*types.PointerType).ElemType.(*types.PointerType).ElemType, elemPtr, false, nil
+			return elemPtr.Type(), elemPtr, false, nil
 		}
 		return fieldPtr.Type(), fieldPtr, false, nil
 	}
@@ -1014,48 +1516,117 @@ func (ctx *Context) compileSubIdentifier(f *Variable, sub *parser.Identifier) (F
 }
 
 func (ctx *Context) compileClassMethod(cm *parser.ClassMethod) (value.Value, error) {
-	var methodName string
-	var currentSub *parser.Identifier
-	var prevSub *parser.Identifier
-
-	// Go through the recursive list of identifier.sub
-	for currentSub = cm.Identifier.Sub; currentSub != nil; currentSub = currentSub.Sub {
-		methodName = currentSub.Name
-		if currentSub.Sub != nil {
-			prevSub = currentSub
+	ident, err := ctx.resolveQualified(cm.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	if ident.Sub == nil {
+		if ctx.lookupVariable(ident.Name) != nil {
+			return nil, posError(cm.Pos, "%s is not a function", ident.Name)
 		}
+		// A function of an imported module
+		return ctx.compileFunctionCall(&parser.FunctionCall{Pos: cm.Pos, FunctionName: ident.Name, TypeArgs: cm.TypeArgs, Args: *cm.Args})
 	}
 
-	// Remove the last sub from cm.Identifier
-	if prevSub != nil {
-		prevSub.Sub = nil
-	} else {
-		cm.Identifier.Sub = nil
+	// Split the identifier into the class instance and the method name. The
+	// AST is copied instead of modified, as it may be compiled more than once.
+	instance := *ident
+	current := &instance
+	for current.Sub.Sub != nil {
+		sub := *current.Sub
+		current.Sub = &sub
+		current = current.Sub
+	}
+	methodName := current.Sub.Name
+	current.Sub = nil
+	if len(cm.TypeArgs) > 0 {
+		return nil, posError(cm.Pos, "Method %s is not generic", methodName)
+	}
+
+	if instance.Name == "super" && instance.Sub == nil && ctx.lookupVariable("super") == nil {
+		return ctx.compileSuperCall(cm, methodName)
+	}
+	if _, ok := ctx.enums[instance.Name]; ok && instance.Sub == nil && ctx.lookupVariable(instance.Name) == nil {
+		return ctx.compileEnumVariant(cm.Pos, instance.Name, methodName, cm.Args)
+	}
+	if _, ok := ctx.classes[instance.Name]; ok && instance.Sub == nil && instance.GEP == nil && ctx.lookupVariable(instance.Name) == nil {
+		return ctx.compileStaticCall(cm, instance.Name, methodName)
 	}
 
 	// Compile the class identifier to get the class instance
-	classInstance, _, err := ctx.compileIdentifier(cm.Identifier, true)
+	classInstance, instanceType, err := ctx.compileIdentifier(&instance, true)
 	if err != nil {
 		return nil, err
 	}
 
-	// Then, compile the method call on the class instance
-	return ctx.compileMethodCall(classInstance, methodName, cm.Args)
+	// Then, compile the method call on the class instance. Only calls through
+	// a pointer can reach an instance of a subclass, so only those are virtual.
+	_, isPointer := instanceType.(*types.PointerType)
+	return ctx.compileMethodCall(classInstance, methodName, cm.Args, isPointer)
 }
 
-func (ctx *Context) compileMethodCall(classInstance value.Value, methodName string, arguments *parser.ArgumentList) (value.Value, error) {
+// compileSuperCall calls the implementation of a method in the parent class
+// of the class the current method belongs to.
+func (ctx *Context) compileSuperCall(cm *parser.ClassMethod, methodName string) (value.Value, error) {
+	this := ctx.lookupVariable("this")
+	if this == nil {
+		return nil, posError(cm.Pos, "super can only be used inside of methods")
+	}
+	class, ok := ctx.classOf(this.Type)
+	if !ok || ctx.classes[class].parent == "" {
+		return nil, posError(cm.Pos, "Class %s does not have a parent class", class)
+	}
+	parentType, _ := ctx.lookupClass(ctx.classes[class].parent)
+	parent := ctx.NewBitCast(this.Value, types.NewPointer(parentType))
+	return ctx.compileMethodCall(parent, methodName, cm.Args, false)
+}
+
+func (ctx *Context) compileMethodCall(classInstance value.Value, methodName string, arguments *parser.ArgumentList, virtual bool) (value.Value, error) {
+	if iface, ok := ctx.interfaceOf(classInstance.Type()); ok {
+		return ctx.compileInterfaceCall(classInstance, iface, methodName, arguments)
+	}
+
 	// Lookup the method on the class
 	pointerType, ok := classInstance.Type().(*types.PointerType)
 	if !ok {
-		return nil, cli.Exit(color.RedString("Error: Cannot call method on non-pointer type"), 1)
+		return nil, posError(arguments.Pos, "Cannot call method %s on a value of type %s", methodName, ctx.TypeToString(classInstance.Type()))
 	}
 	method, exists := ctx.lookupMethod(pointerType, methodName)
 	if !exists {
-		return nil, cli.Exit(color.RedString("Error: Method %s not found on type %s", methodName, pointerType.ElemType.Name()), 1)
+		class, _ := ctx.classOf(pointerType)
+		return nil, undefinedError(arguments.Pos, "Method %s not found on type %s", methodName, ctx.TypeToString(pointerType.ElemType)).Suggest(methodName, ctx.memberNames(class))
+	}
+	if err := ctx.checkAccess(arguments.Pos, pointerType, methodName); err != nil {
+		return nil, err
+	}
+	if class, _ := ctx.classOf(pointerType); ctx.staticMember(class, methodName) {
+		return nil, posError(arguments.Pos, "Static method %s must be called on class %s, not on an instance", methodName, class)
+	}
+	fn := method.(*ir.Func)
+
+	var callee value.Value = fn
+	if virtual {
+		callee = ctx.virtualMethod(classInstance, fn, methodName)
 	}
 
-	// Prepare the arguments for the method call
-	args := []value.Value{classInstance}
+	// Prepare the arguments for the method call, inherited methods
+	// expect a pointer to the class they were defined in
+	this, err := ctx.convertValue(arguments.Pos, classInstance, fn.Sig.Params[0])
+	if err != nil {
+		return nil, err
+	}
+	args, err := ctx.compileMethodArguments(fn, []value.Value{this}, arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Call the method
+	return ctx.Block.NewCall(callee, args...), nil
+}
+
+// compileMethodArguments compiles the arguments of a call to fn, following
+// the arguments already in args.
+func (ctx *Context) compileMethodArguments(fn *ir.Func, args []value.Value, arguments *parser.ArgumentList) ([]value.Value, error) {
 	for _, arg := range arguments.Arguments {
 		compiledArg, err := ctx.compileExpression(arg)
 		if err != nil {
@@ -1063,9 +1634,28 @@ func (ctx *Context) compileMethodCall(classInstance value.Value, methodName stri
 		}
 		args = append(args, compiledArg)
 	}
+	return args, nil
+}
 
-	// Call the method
-	return ctx.Block.NewCall(method, args...), nil
+// compileStaticCall calls the static method methodName of class.
+func (ctx *Context) compileStaticCall(cm *parser.ClassMethod, class string, methodName string) (value.Value, error) {
+	classType, _ := ctx.lookupClass(class)
+	method, ok := ctx.lookupMethod(types.NewPointer(classType), methodName)
+	if !ok {
+		return nil, undefinedError(cm.Pos, "Method %s not found on class %s", methodName, class).Suggest(methodName, ctx.memberNames(class))
+	}
+	if !ctx.staticMember(class, methodName) {
+		return nil, posError(cm.Pos, "Method %s of class %s is not static, it must be called on an instance", methodName, class)
+	}
+	if err := ctx.checkClassAccess(cm.Pos, class, methodName); err != nil {
+		return nil, err
+	}
+	fn := method.(*ir.Func)
+	args, err := ctx.compileMethodArguments(fn, nil, cm.Args)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.NewCall(fn, args...), nil
 }
 
 func (ctx *Context) lookupMethod(parentType types.Type, methodName string) (value.Value, bool) {
@@ -1085,8 +1675,17 @@ func (ctx *Context) lookupMethod(parentType types.Type, methodName string) (valu
 		return nil, false
 	}
 
-	// Check if methodName is a method of the struct
-	methodKey := fmt.Sprintf("%s.%s", structName, methodName)
-	method, exists := ctx.SymbolTable[methodKey]
-	return method, exists
+	// Check if methodName is a method of the struct or one of its parents
+	for structName != "" {
+		methodKey := fmt.Sprintf("%s.%s", structName, methodName)
+		if method, exists := ctx.SymbolTable[methodKey]; exists {
+			return method, true
+		}
+		info, ok := ctx.classes[structName]
+		if !ok {
+			break
+		}
+		structName = info.parent
+	}
+	return nil, false
 }
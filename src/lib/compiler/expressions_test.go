@@ -0,0 +1,84 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// funcIR returns the IR of the function name of the module compiled by c.
+func funcIR(t *testing.T, c *Compiler, name string) string {
+	t.Helper()
+	for _, f := range c.Module.Funcs {
+		if f.Name() == name {
+			return f.LLString()
+		}
+	}
+	t.Fatalf("function %s was not compiled", name)
+	return ""
+}
+
+func TestNumericCasts(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// Instruction expected in the IR of main
+		inst string
+	}{
+		{"float to signed", "var f: f64 = 3.75; var n: i64 = (f): i64;", "fptosi double"},
+		{"float to unsigned", "var f: f64 = 3.75; var n: u8 = (f): u8;", "fptoui double"},
+		{"float to bool", "var f: f32 = 0.5; var b: i1 = (f): i1;", "fcmp une float"},
+		{"unsigned to float", "var u: u32 = 7; var f: f64 = (u): f64;", "uitofp i32"},
+		{"signed to float", "var s: i32 = 7; var f: f64 = (s): f64;", "sitofp i32"},
+		{"float narrowing", "var f: f64 = 1.5; var g: f32 = (f): f32;", "fptrunc double"},
+		{"unsigned widening", "var u: u16 = 7; var w: u64 = (u): u64;", "zext i16"},
+		{"signed widening", "var s: i8 = 7; var w: i64 = (s): i64;", "sext i8"},
+		{"integer to bool", "var w: i64 = 300; var b: i1 = (w): i1;", "icmp ne i64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := compileFiles(t, map[string]string{"main.cffc": "package main;\nfunc main(): i32 {\n" + tt.body + "\nreturn 0;\n}\n"})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if ir := funcIR(t, c, "main"); !strings.Contains(ir, tt.inst) {
+				t.Errorf("expected %q in the IR of main:\n%s", tt.inst, ir)
+			}
+		})
+	}
+}
+
+func TestEmptyArrayLiteral(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": `package main;
+func first(xs: *i64): *i64 { return xs; }
+func main(): i32 {
+  var e: [0]i64 = [];
+  var p: *i64 = first([]);
+  return 0;
+}
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ir := funcIR(t, c, "main"); !strings.Contains(ir, "[0 x i64]") {
+		t.Errorf("expected an array of no i64 in the IR of main:\n%s", ir)
+	}
+}
+
+func TestTemplateLiterals(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": `package main;
+func inc<T>(x: T): T { return x + 1; }
+func main(): i32 {
+  var n: i32 = inc<i32>(2);
+  var f: f64 = inc<f64>(2.5);
+  return n;
+}
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for name, inst := range map[string]string{"main.inc<i32>": "add i32", "main.inc<f64>": "fadd double"} {
+		if ir := funcIR(t, c, name); !strings.Contains(ir, inst) {
+			t.Errorf("expected %q in the IR of %s:\n%s", inst, name, ir)
+		}
+	}
+}
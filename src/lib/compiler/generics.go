@@ -0,0 +1,243 @@
+package compiler
+
+import (
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// Generic functions and classes are kept as templates and compiled once for
+// every combination of type arguments they are used with. Instances are named
+// after the template and their type arguments, e.g. max<i64> or List<*i8>,
+// and every module using an instance compiles its own linkonce_odr copy.
+// Templates imported from another package are compiled in the scope of that
+// package, and their instances are qualified with it, e.g. util.max<i64>.
+
+// packageScope is the package an imported template is defined in.
+type packageScope struct {
+	ast *parser.Program
+	// Types of the package, by the names it refers to them with
+	types map[string]types.Type
+}
+
+// genericFunc is the template of a generic function.
+type genericFunc struct {
+	*parser.FunctionDefinition
+	// Package the function is defined in, nil for the package being compiled
+	scope *packageScope
+}
+
+// genericClass is the template of a generic class.
+type genericClass struct {
+	*parser.ClassDefinition
+	// Package the class is defined in, nil for the package being compiled
+	scope *packageScope
+}
+
+// genericName returns the name of the instance of name for args.
+func (ctx *Context) genericName(name string, args []types.Type) string {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = ctx.TypeToString(arg)
+	}
+	return name + "<" + strings.Join(names, ",") + ">"
+}
+
+// typeArgs resolves the type arguments written at a use of a template.
+func (ctx *Context) typeArgs(args []*parser.Type) []types.Type {
+	typs := make([]types.Type, len(args))
+	for i, arg := range args {
+		typs[i] = ctx.CFTypeToLLType(arg)
+	}
+	return typs
+}
+
+// templateContext returns a top level context of the package scope in which
+// the type parameters of a template refer to args.
+func (ctx *Context) templateContext(scope *packageScope, params []string, args []types.Type) *Context {
+	ictx := NewContext(nil, ctx.Compiler)
+	ictx.scope = scope
+	ictx.typeParams = make(map[string]types.Type)
+	if scope != nil {
+		for name, typ := range scope.types {
+			ictx.typeParams[name] = typ
+		}
+	}
+	for i, param := range params {
+		ictx.typeParams[param] = args[i]
+	}
+	return ictx
+}
+
+// instantiateFunction returns the instance of the generic function name for
+// args, compiling it on first use.
+func (ctx *Context) instantiateFunction(pos lexer.Position, name string, args []types.Type) (*ir.Func, error) {
+	tmpl, _ := ctx.lookupGeneric(name)
+	if len(args) != len(tmpl.TypeParams) {
+		return nil, posError(pos, "Function %s expects %d type arguments, got %d", name, len(tmpl.TypeParams), len(args))
+	}
+
+	inst := *tmpl.FunctionDefinition
+	inst.Name.Name = ctx.genericName(tmpl.Name.Name, args)
+	inst.TypeParams = nil
+	ictx := ctx.templateContext(tmpl.scope, tmpl.TypeParams, args)
+	symbol := functionSymbol(ictx.pkg(), &inst)
+	if fn, ok := ctx.moduleFunc(symbol); ok {
+		return fn, nil
+	}
+	if _, _, _, err := ictx.compileFunctionDefinition(&inst); err != nil {
+		return nil, err
+	}
+	fn, _ := ctx.moduleFunc(symbol)
+	fn.Linkage = enum.LinkageLinkOnceODR
+	return fn, nil
+}
+
+// lookupGeneric returns the generic function name. Templates compiled in the
+// scope of another package see the generic functions of that package.
+func (ctx *Context) lookupGeneric(name string) (*genericFunc, bool) {
+	if ctx.scope != nil {
+		for _, s := range ctx.scope.ast.Statements {
+			if s.Export != nil {
+				s = s.Export
+			}
+			if f := s.FunctionDefinition; f != nil && len(f.TypeParams) > 0 && f.Name.Name == name {
+				return &genericFunc{FunctionDefinition: f, scope: ctx.scope}, true
+			}
+		}
+	}
+	tmpl, ok := ctx.genericFuncs[name]
+	return tmpl, ok
+}
+
+// instantiateClass returns the type of the instance of the generic class name
+// for args, compiling it on first use.
+func (ctx *Context) instantiateClass(pos lexer.Position, name string, args []types.Type) (types.Type, error) {
+	tmpl, ok := ctx.lookupGenericClass(name)
+	if !ok {
+		return nil, posError(pos, "Class %s is not generic", name)
+	}
+	if len(args) != len(tmpl.TypeParams) {
+		return nil, posError(pos, "Class %s expects %d type arguments, got %d", name, len(tmpl.TypeParams), len(args))
+	}
+	inst := *tmpl.ClassDefinition
+	inst.Name = ctx.genericName(tmpl.Name, args)
+	inst.TypeParams = nil
+	ictx := ctx.templateContext(tmpl.scope, tmpl.TypeParams, args)
+	mangled := inst.Name
+	if tmpl.scope != nil {
+		mangled = tmpl.scope.ast.Package + "." + inst.Name
+	}
+	if classType, ok := ctx.lookupClass(mangled); ok {
+		return classType, nil
+	}
+
+	classType, err := ictx.declareClass(&inst, mangled, ictx.pkg())
+	if err != nil {
+		return nil, err
+	}
+	ctx.classes[mangled].template = name
+	ctx.classes[mangled].typeArgs = args
+	for _, s := range inst.Body {
+		if s.FunctionDefinition == nil {
+			continue
+		}
+		if len(s.FunctionDefinition.TypeParams) > 0 {
+			return nil, posError(s.FunctionDefinition.Pos, "Methods can not have type parameters")
+		}
+		if err := ictx.compileClassMethodDefinition(s.FunctionDefinition, mangled, classType); err != nil {
+			return nil, err
+		}
+		if !s.FunctionDefinition.Private {
+			ctx.SymbolTable[mangled+methodSuffix(s.FunctionDefinition.Name)].(*ir.Func).Linkage = enum.LinkageLinkOnceODR
+		}
+	}
+	return classType, nil
+}
+
+// lookupGenericClass returns the generic class name. Templates compiled in
+// the scope of another package see the generic classes of that package.
+func (ctx *Context) lookupGenericClass(name string) (*genericClass, bool) {
+	if ctx.scope != nil {
+		for _, s := range ctx.scope.ast.Statements {
+			if s.Export != nil {
+				s = s.Export
+			}
+			if c := s.ClassDefinition; c != nil && len(c.TypeParams) > 0 && c.Name == name {
+				return &genericClass{ClassDefinition: c, scope: ctx.scope}, true
+			}
+		}
+	}
+	tmpl, ok := ctx.genericClasses[name]
+	return tmpl, ok
+}
+
+// inferTypeArgs infers the type arguments of the generic function name from
+// the types of the arguments it is called with.
+func (ctx *Context) inferTypeArgs(pos lexer.Position, name string, args []value.Value) ([]types.Type, error) {
+	tmpl, _ := ctx.lookupGeneric(name)
+	bound := make(map[string]types.Type)
+	for i, param := range tmpl.Parameters {
+		if i < len(args) {
+			ctx.bindTypeParams(param.Type, args[i].Type(), tmpl.TypeParams, bound)
+		}
+	}
+
+	typs := make([]types.Type, len(tmpl.TypeParams))
+	for i, param := range tmpl.TypeParams {
+		typ, ok := bound[param]
+		if !ok {
+			return nil, posError(pos, "Unable to infer type parameter %s of function %s", param, name)
+		}
+		typs[i] = typ
+	}
+	return typs, nil
+}
+
+// bindTypeParams matches the parameter type t against typ, recording the
+// type bound to every type parameter t refers to.
+func (ctx *Context) bindTypeParams(t *parser.Type, typ types.Type, params []string, bound map[string]types.Type) {
+	if t.Array != nil {
+		arrayType, ok := typ.(*types.ArrayType)
+		if !ok {
+			return
+		}
+		typ = arrayType.ElemType
+	}
+	for i := 0; i < strings.Count(t.Ptr, "*"); i++ {
+		ptrType, ok := typ.(*types.PointerType)
+		if !ok {
+			return
+		}
+		typ = ptrType.ElemType
+	}
+	if t.Inner != nil {
+		ctx.bindTypeParams(t.Inner, typ, params, bound)
+		return
+	}
+	if len(t.TypeArgs) > 0 {
+		class, ok := ctx.classOf(typ)
+		if !ok || ctx.classes[class].template != t.Name {
+			return
+		}
+		for i, arg := range t.TypeArgs {
+			if i < len(ctx.classes[class].typeArgs) {
+				ctx.bindTypeParams(arg, ctx.classes[class].typeArgs[i], params, bound)
+			}
+		}
+		return
+	}
+	for _, param := range params {
+		if param == t.Name {
+			if _, ok := bound[param]; !ok {
+				bound[param] = typ
+			}
+			return
+		}
+	}
+}
@@ -2,22 +2,28 @@ package compiler
 
 import (
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 )
 
 func convertCffTypeToCType(t types.Type) string {
 	switch typ := t.(type) {
 	case *types.IntType:
+		prefix := ""
+		if isUnsigned(typ) {
+			prefix = "unsigned "
+		}
 		if typ.BitSize <= 8 {
-			return "char"
+			return prefix + "char"
 		} else if typ.BitSize <= 16 {
-			return "short"
+			return prefix + "short"
 		} else if typ.BitSize <= 32 {
-			return "long"
+			return prefix + "long"
 		} else {
-			return "long long"
+			return prefix + "long long"
 		}
 	case *types.FloatType:
 		if typ.Kind == types.FloatKindFloat {
@@ -66,8 +72,48 @@ func WriteHeader(f *os.File, comp *Compiler) error {
 		return err
 	}
 
+	for _, g := range comp.Module.Globals {
+		// Only exported globals defined in this module are declared, under
+		// their name without the package
+		name := strings.TrimPrefix(g.Name(), comp.AST.Package+".")
+		if g.Init == nil || g.Linkage != enum.LinkageNone || name == g.Name() || strings.Count(name, ".") > 0 {
+			continue
+		}
+
+		decl := "extern "
+		if g.Immutable {
+			decl += "const "
+		}
+		label := " __asm__(\"" + g.Name() + "\");\n"
+		if arrType, ok := g.ContentType.(*types.ArrayType); ok {
+			decl += convertCffTypeToCType(arrType.ElemType) + " " + name + "[" + strconv.FormatUint(arrType.Len, 10) + "]" + label
+		} else {
+			decl += convertCffTypeToCType(g.ContentType) + " " + name + label
+		}
+
+		_, err = f.WriteString(decl)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Exported functions are declared under their name without the package,
+	// like globals
+	exported := make(map[string]bool)
+	for _, s := range comp.AST.Statements {
+		if s.Export != nil && s.Export.FunctionDefinition != nil && len(s.Export.FunctionDefinition.TypeParams) == 0 {
+			exported[functionSymbol(comp.AST.Package, s.Export.FunctionDefinition)] = true
+		}
+	}
+
 	for _, fn := range comp.Module.Funcs {
-		if strings.Count(fn.Name(), ".") > 0 {
+		name, label := fn.Name(), ""
+		if exported[fn.Name()] && len(fn.Blocks) > 0 {
+			name = strings.TrimPrefix(fn.Name(), comp.AST.Package+".")
+			if name != fn.Name() {
+				label = " __asm__(\"" + fn.Name() + "\")"
+			}
+		} else if strings.Count(fn.Name(), ".") > 0 || fn.Linkage != enum.LinkageNone {
 			continue
 		}
 		_, err = f.WriteString(convertCffTypeToCType(fn.Sig.RetType) + " ")
@@ -75,7 +121,7 @@ func WriteHeader(f *os.File, comp *Compiler) error {
 			return err
 		}
 
-		_, err = f.WriteString(fn.Name())
+		_, err = f.WriteString(name)
 		if err != nil {
 			return err
 		}
@@ -116,7 +162,7 @@ func WriteHeader(f *os.File, comp *Compiler) error {
 			}
 		}
 
-		_, err = f.WriteString(")")
+		_, err = f.WriteString(")" + label)
 		if err != nil {
 			return err
 		}
@@ -128,11 +174,22 @@ func WriteHeader(f *os.File, comp *Compiler) error {
 	}
 
 	for _, c := range comp.Module.TypeDefs {
+		// Instances of generic classes have no name usable from C++
+		if _, ok := comp.classes[c.Name()]; !ok || strings.ContainsAny(c.Name(), "<.") {
+			continue
+		}
+
 		_, err = f.WriteString("class " + c.Name() + "\n{\nprivate:\n")
 		if err != nil {
 			return err
 		}
 
+		// Every class starts with a pointer to its vtable
+		_, err = f.WriteString("void *vtable;\n")
+		if err != nil {
+			return err
+		}
+
 		for _, field := range comp.StructFields[c.Name()] {
 			if !field.Private {
 				continue
@@ -160,16 +217,29 @@ func WriteHeader(f *os.File, comp *Compiler) error {
 
 		for _, fn := range comp.Module.Funcs {
 			var parts []string
-			if strings.Count(fn.Name(), ".") == 0 {
+			if strings.Count(fn.Name(), ".") == 0 || fn.Linkage != enum.LinkageNone {
 				continue
 			} else {
-				parts = strings.Split(fn.Name(), ".")
-				if parts[0] != c.Name() {
+				parts = strings.Split(strings.TrimPrefix(fn.Name(), comp.AST.Package+"."), ".")
+				// Operators and property accessors have no C++ counterpart
+				if parts[0] != c.Name() || len(parts) > 2 {
 					continue
 				}
 			}
 
 			isConstructor := parts[1] == "constructor"
+			isStatic := comp.Context.staticMember(c.Name(), parts[1])
+			params := fn.Params
+			if !isStatic {
+				params = params[1:]
+			}
+
+			if isStatic {
+				_, err = f.WriteString("static ")
+				if err != nil {
+					return err
+				}
+			}
 
 			if !isConstructor {
 				_, err = f.WriteString(convertCffTypeToCType(fn.Sig.RetType) + " ")
@@ -193,8 +263,8 @@ func WriteHeader(f *os.File, comp *Compiler) error {
 				return err
 			}
 
-			for i, param := range fn.Sig.Params[1:] {
-				_, err = f.WriteString(convertCffTypeToCType(param))
+			for i, param := range params {
+				_, err = f.WriteString(convertCffTypeToCType(param.Type()))
 				if err != nil {
 					return err
 				}
@@ -204,12 +274,12 @@ func WriteHeader(f *os.File, comp *Compiler) error {
 					return err
 				}
 
-				_, err = f.WriteString(fn.Params[i+1].Name())
+				_, err = f.WriteString(param.Name())
 				if err != nil {
 					return err
 				}
 
-				if i != len(fn.Sig.Params)-2 {
+				if i != len(params)-1 {
 					_, err = f.WriteString(", ")
 					if err != nil {
 						return err
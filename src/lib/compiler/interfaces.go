@@ -0,0 +1,163 @@
+package compiler
+
+import (
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// Interface values are fat pointers: a pointer to the instance and a pointer
+// to a method table holding the implementation of every interface method for
+// the class of the instance. Classes implement an interface either by
+// declaring it with implements, or by having all of its methods.
+
+var interfaceType = types.NewStruct(types.I8Ptr, types.I8Ptr)
+
+type interfaceInfo struct {
+	methods []string
+	// Signatures take the instance as an i8* as their first parameter
+	sigs []*types.FuncType
+	// Method tables of the classes converted to the interface
+	tables map[string]*ir.Global
+}
+
+// declareInterface declares interface i, which is known as name in this file.
+func (ctx *Context) declareInterface(i *parser.InterfaceDefinition, name string) error {
+	typ := types.NewStruct(interfaceType.Fields...)
+	typ.SetName(name)
+	ctx.Context.structNames[typ] = name
+	ctx.Module.NewTypeDef(name, typ)
+
+	info := &interfaceInfo{tables: make(map[string]*ir.Global)}
+	for _, m := range i.Methods {
+		params := []types.Type{types.I8Ptr}
+		for _, p := range m.Parameters {
+			params = append(params, ctx.CFTypeToLLType(p.Type))
+		}
+		info.methods = append(info.methods, m.Name)
+		info.sigs = append(info.sigs, types.NewFunc(ctx.CFMultiTypeToLLType(m.ReturnType), params...))
+	}
+
+	ctx.interfaces[name] = info
+	return nil
+}
+
+func (info *interfaceInfo) method(name string) int {
+	for i, m := range info.methods {
+		if m == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// interfaceOf returns the name of the interface typ is, or points to.
+func (ctx *Context) interfaceOf(typ types.Type) (string, bool) {
+	if ptrType, ok := typ.(*types.PointerType); ok {
+		typ = ptrType.ElemType
+	}
+	structType, ok := typ.(*types.StructType)
+	if !ok {
+		return "", false
+	}
+	name, ok := ctx.Context.structNames[structType]
+	if !ok {
+		return "", false
+	}
+	_, ok = ctx.interfaces[name]
+	return name, ok
+}
+
+// methodTable returns the method table of class for iface. Every entry calls
+// the method through the vtable, so an instance of a subclass converted
+// through a pointer to its parent still reaches its own overrides.
+func (ctx *Context) methodTable(class string, iface string) *ir.Global {
+	info := ctx.interfaces[iface]
+	if table, ok := info.tables[class]; ok {
+		return table
+	}
+
+	classType, _ := ctx.lookupClass(class)
+	entries := make([]constant.Constant, len(info.methods))
+	for i, name := range info.methods {
+		method, _ := ctx.lookupMethod(types.NewPointer(classType), name)
+		impl := method.(*ir.Func)
+
+		var params []*ir.Param
+		for j, p := range info.sigs[i].Params {
+			params = append(params, ir.NewParam(impl.Params[j].Name(), p))
+		}
+		thunk := ctx.Module.NewFunc(class+"."+iface+"."+name, info.sigs[i].RetType, params...)
+		thunk.Linkage = enum.LinkageInternal
+
+		tctx := ctx.Compiler.Context.NewContext(thunk.NewBlock(""))
+		this := tctx.NewBitCast(params[0], types.NewPointer(classType))
+		args := []value.Value{tctx.NewBitCast(this, impl.Sig.Params[0])}
+		for _, p := range params[1:] {
+			args = append(args, p)
+		}
+		result := tctx.NewCall(tctx.virtualMethod(this, impl, name), args...)
+		if info.sigs[i].RetType.Equal(types.Void) {
+			tctx.NewRet(nil)
+		} else {
+			tctx.NewRet(result)
+		}
+
+		entries[i] = constant.NewBitCast(thunk, types.I8Ptr)
+	}
+
+	table := ctx.Module.NewGlobalDef(class+"."+iface+".itable", constant.NewArray(types.NewArray(uint64(len(entries)), types.I8Ptr), entries...))
+	table.Linkage = enum.LinkageInternal
+	table.Immutable = true
+	info.tables[class] = table
+	return table
+}
+
+// toInterface wraps a pointer to an instance of a class into an interface value.
+func (ctx *Context) toInterface(pos lexer.Position, val value.Value, typ *types.StructType, iface string) (value.Value, error) {
+	class, ok := ctx.classOf(val.Type())
+	if !ok {
+		return nil, posError(pos, "Cannot convert %s to interface %s", val.Type(), iface)
+	}
+	table := constant.NewBitCast(ctx.methodTable(class, iface), types.I8Ptr)
+	if c, ok := val.(constant.Constant); ok {
+		return constant.NewStruct(typ, constant.NewBitCast(c, types.I8Ptr), table), nil
+	}
+	result := ctx.NewInsertValue(constant.NewUndef(typ), ctx.NewBitCast(val, types.I8Ptr), 0)
+	return ctx.NewInsertValue(result, table, 1), nil
+}
+
+// compileInterfaceCall calls a method of the instance held by an interface value.
+func (ctx *Context) compileInterfaceCall(instance value.Value, iface string, methodName string, arguments *parser.ArgumentList) (value.Value, error) {
+	info := ctx.interfaces[iface]
+	index := info.method(methodName)
+	if index < 0 {
+		return nil, undefinedError(arguments.Pos, "Method %s not found on interface %s", methodName, iface).Suggest(methodName, info.methods)
+	}
+	sig := info.sigs[index]
+	if len(arguments.Arguments) != len(sig.Params)-1 {
+		return nil, posError(arguments.Pos, "Method %s of interface %s expects %d arguments, got %d", methodName, iface, len(sig.Params)-1, len(arguments.Arguments))
+	}
+
+	if ptrType, ok := instance.Type().(*types.PointerType); ok {
+		instance = ctx.NewLoad(ptrType.ElemType, instance)
+	}
+	data := ctx.NewExtractValue(instance, 0)
+	table := ctx.NewBitCast(ctx.NewExtractValue(instance, 1), types.NewPointer(types.I8Ptr))
+	impl := ctx.NewLoad(types.I8Ptr, ctx.NewGetElementPtr(types.I8Ptr, table, constant.NewInt(types.I32, int64(index))))
+
+	args := []value.Value{data}
+	for _, arg := range arguments.Arguments {
+		compiledArg, err := ctx.compileExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, compiledArg)
+	}
+
+	return ctx.NewCall(ctx.NewBitCast(impl, types.NewPointer(sig)), args...), nil
+}
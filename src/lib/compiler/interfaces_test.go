@@ -0,0 +1,75 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+const shapes = `package main;
+interface Shape {
+  func area(): i64;
+  func scale(k: i64): i64;
+}
+class Circle implements Shape {
+  r: i64;
+  func area(): i64 { return 3 * this.r * this.r; }
+  func scale(k: i64): i64 { return this.r * k; }
+}
+class Square {
+  s: i64;
+  func scale(k: i64): i64 { return this.s * k; }
+  func area(): i64 { return this.s * this.s; }
+}
+func measure(s: Shape): i64 { return s.area() + s.scale(2); }
+`
+
+func TestInterfaceDispatch(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": shapes + `
+func main(): i32 {
+  var c: *Circle = new Circle();
+  var q: *Square = new Square();
+  var n: i64 = measure(c) + measure(q);
+  return 0;
+}
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Method tables follow the order of the interface, whether the class
+	// declares it or just has its methods
+	for _, class := range []string{"Circle", "Square"} {
+		table := globalIR(t, c, class+".Shape.itable")
+		if area, scale := strings.Index(table, "@"+class+".Shape.area"), strings.Index(table, "@"+class+".Shape.scale"); area < 0 || area > scale {
+			t.Errorf("expected the table of %s to hold area and scale in order:\n%s", class, table)
+		}
+	}
+
+	// Methods are loaded from the table of the value
+	ir := funcIR(t, c, "main.measure")
+	if strings.Contains(ir, "@main.Circle.") || strings.Contains(ir, "@main.Square.") {
+		t.Errorf("expected the methods to be called through the method table:\n%s", ir)
+	}
+	if strings.Count(ir, "getelementptr i8*, i8**") != 2 {
+		t.Errorf("expected an entry of the table to be loaded for every call:\n%s", ir)
+	}
+	if ir := funcIR(t, c, "main"); !strings.Contains(ir, "@Circle.Shape.itable") || !strings.Contains(ir, "@Square.Shape.itable") {
+		t.Errorf("expected main to convert both classes to Shape:\n%s", ir)
+	}
+}
+
+func TestInterfaceNotImplemented(t *testing.T) {
+	diags := compileErrors(t, shapes+`
+class Line {
+  func area(): i64 { return 0; }
+}
+func main(): i32 {
+  var l: *Line = new Line();
+  var n: i64 = measure(l);
+  return 0;
+}
+`)
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "Class Line does not implement interface Shape, method scale is missing") {
+		t.Fatalf("expected the missing method to be reported, got %s", diags)
+	}
+}
@@ -0,0 +1,95 @@
+package compiler
+
+import (
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Classes overload operators with methods declared as func op "+"(other: T).
+// An operator used on an instance of a class calls the method of the left
+// operand, binary operators pass the right operand as the only argument and
+// unary operators take no arguments.
+
+// operatorMethod returns the method overloading op on the class val is an
+// instance of, or nil if val is not a class instance.
+func (ctx *Context) operatorMethod(pos lexer.Position, val value.Value, op string, operands int) (*ir.Func, error) {
+	class, ok := ctx.classOf(val.Type())
+	if !ok {
+		return nil, nil
+	}
+	classType, _ := ctx.lookupClass(class)
+	method, ok := ctx.lookupMethod(types.NewPointer(classType), "op."+op)
+	if !ok {
+		return nil, posError(pos, "Class %s does not overload the %s operator", class, op)
+	}
+	if err := ctx.checkAccess(pos, classType, "op."+op); err != nil {
+		return nil, err
+	}
+	fn := method.(*ir.Func)
+	if len(fn.Sig.Params) != operands+1 {
+		return nil, posError(pos, "Operator %s of class %s must take %d arguments", op, class, operands)
+	}
+	return fn, nil
+}
+
+// callOperator calls the operator method on the instance val.
+func (ctx *Context) callOperator(pos lexer.Position, method *ir.Func, val value.Value, operands ...value.Value) (value.Value, error) {
+	if _, ok := val.Type().(*types.StructType); ok {
+		// Methods take a pointer to the instance
+		ptr := ctx.NewAlloca(val.Type())
+		ctx.NewStore(val, ptr)
+		val = ptr
+	}
+	this, err := ctx.convertValue(pos, val, method.Sig.Params[0])
+	if err != nil {
+		return nil, err
+	}
+	args := []value.Value{this}
+	for i, operand := range operands {
+		operand, err := ctx.convertValue(pos, operand, method.Sig.Params[i+1])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, operand)
+	}
+	return ctx.NewCall(method, args...), nil
+}
+
+// compileBinaryOverload calls the method overloading op on the class instance
+// left, with the operand compiled by right. It returns nil if left is not a
+// class instance, or if it is a pointer compared without an overload.
+func (ctx *Context) compileBinaryOverload(pos lexer.Position, op string, left value.Value, right func() (value.Value, error)) (value.Value, error) {
+	if _, ok := ctx.classOf(left.Type()); !ok {
+		return nil, nil
+	}
+	if op == "==" || op == "!=" {
+		// Pointers to instances can always be compared
+		if _, ok := left.Type().(*types.PointerType); ok {
+			if _, overloaded := ctx.lookupMethod(left.Type(), "op."+op); !overloaded {
+				return nil, nil
+			}
+		}
+	}
+
+	method, err := ctx.operatorMethod(pos, left, op, 1)
+	if err != nil {
+		return nil, err
+	}
+	rightVal, err := right()
+	if err != nil {
+		return nil, err
+	}
+	return ctx.callOperator(pos, method, left, rightVal)
+}
+
+// compileUnaryOverload calls the method overloading op on the class instance
+// val, or returns nil if val is not a class instance.
+func (ctx *Context) compileUnaryOverload(pos lexer.Position, op string, val value.Value) (value.Value, error) {
+	method, err := ctx.operatorMethod(pos, val, op, 0)
+	if method == nil || err != nil {
+		return nil, err
+	}
+	return ctx.callOperator(pos, method, val)
+}
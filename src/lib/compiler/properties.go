@@ -0,0 +1,144 @@
+package compiler
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"github.com/vyPal/CaffeineC/lib/parser"
+)
+
+// Classes expose properties with methods declared as func get name() and
+// func set name(v: T). Reading obj.name calls the getter and assigning to it
+// calls the setter. Methods of the class accessing this.name still reach the
+// field of that name, so accessors can be backed by a field of the same name.
+
+type property struct {
+	name     string
+	instance value.Value
+	getter   *ir.Func
+	setter   *ir.Func
+}
+
+// compileGetter reads the property sub of instance through getter. The result
+// is returned in a temporary, like the pointer to a field.
+func (ctx *Context) compileGetter(sub *parser.Identifier, getter *ir.Func, instance value.Value) (types.Type, value.Value, error) {
+	if len(getter.Sig.Params) != 1 {
+		return nil, nil, posError(sub.Pos, "Getter of property %s must not take any arguments", sub.Name)
+	}
+	this, err := ctx.convertValue(sub.Pos, instance, getter.Sig.Params[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	result := ctx.NewCall(getter, this)
+	ptr := ctx.NewAlloca(result.Type())
+	ctx.NewStore(result, ptr)
+	if sub.GEP != nil {
+		elemPtr, _, err := ctx.compileIndex(result.Type(), ptr, sub.GEP)
+		if err != nil {
+			return nil, nil, err
+		}
+		return elemPtr.Type(), elemPtr, nil
+	}
+	return ptr.Type(), ptr, nil
+}
+
+// compileAssignmentTarget compiles the target of an assignment. Properties
+// with a setter are returned as a property instead of a pointer to store to.
+func (ctx *Context) compileAssignmentTarget(ident *parser.Identifier) (value.Value, types.Type, *property, error) {
+	ident, err := ctx.resolveQualified(ident)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if ident.Sub == nil || ident.Ref != "" || ident.Deref != "" || (ident.Name == "this" && ident.Sub.Sub == nil) {
+		val, typ, err := ctx.compileIdentifier(ident, false)
+		return val, typ, nil, err
+	}
+
+	// Split the identifier into the instance and the accessed name, the AST
+	// is copied instead of modified
+	instance := *ident
+	current := &instance
+	for current.Sub.Sub != nil {
+		sub := *current.Sub
+		current.Sub = &sub
+		current = current.Sub
+	}
+	last := current.Sub
+	current.Sub = nil
+	if last.GEP != nil {
+		val, typ, err := ctx.compileIdentifier(ident, false)
+		return val, typ, nil, err
+	}
+
+	instanceVal, instanceType, err := ctx.compileIdentifier(&instance, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if class, ok := ctx.classOf(instanceVal.Type()); ok {
+		classType, _ := ctx.lookupClass(class)
+		setter, hasSetter := ctx.lookupMethod(types.NewPointer(classType), "set."+last.Name)
+		getter, hasGetter := ctx.lookupMethod(types.NewPointer(classType), "get."+last.Name)
+		if hasSetter {
+			if err := ctx.checkAccess(last.Pos, classType, "set."+last.Name); err != nil {
+				return nil, nil, nil, err
+			}
+			p := &property{name: last.Name, instance: instanceVal, setter: setter.(*ir.Func)}
+			if hasGetter {
+				p.getter = getter.(*ir.Func)
+			}
+			return nil, nil, p, nil
+		} else if hasGetter {
+			return nil, nil, nil, posError(last.Pos, "Property %s of class %s is read-only", last.Name, class)
+		}
+	}
+
+	_, fieldPtr, isMethod, err := ctx.compileSubIdentifier(&Variable{Name: current.Name, Type: instanceType, Value: instanceVal}, last)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if isMethod {
+		return nil, nil, nil, posError(last.Pos, "Cannot assign to method %s", last.Name)
+	}
+	return fieldPtr, fieldPtr.Type(), nil, nil
+}
+
+// compilePropertyAssignment assigns to a property through its setter.
+func (ctx *Context) compilePropertyAssignment(a *parser.Assignment, p *property) error {
+	if len(a.Idents) != 1 {
+		return posError(a.Pos, "Properties can not be assigned together with other values")
+	}
+	if len(p.setter.Sig.Params) != 2 {
+		return posError(a.Pos, "Setter of property %s must take a single argument", p.name)
+	}
+	valType := p.setter.Sig.Params[1]
+	this, err := ctx.convertValue(a.Pos, p.instance, p.setter.Sig.Params[0])
+	if err != nil {
+		return err
+	}
+
+	val, err := ctx.compileExpression(a.Right)
+	if err != nil {
+		return err
+	}
+
+	if a.Op != "=" {
+		if p.getter == nil {
+			return posError(a.Pos, "Property %s can not be read, it has no getter", p.name)
+		}
+		getterThis, err := ctx.convertValue(a.Pos, p.instance, p.getter.Sig.Params[0])
+		if err != nil {
+			return err
+		}
+		current := ctx.NewCall(p.getter, getterThis)
+		val, err = ctx.compoundValue(a.Pos, a.Op, current, val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !val.Type().Equal(valType) {
+		return posError(a.Right.Pos, "Cannot assign %s to property %s of type %s", ctx.TypeToString(val.Type()), p.name, ctx.TypeToString(valType))
+	}
+	ctx.NewCall(p.setter, this, val)
+	return nil
+}
@@ -0,0 +1,77 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+const counter = `package main;
+class Count {
+  private v: i64;
+  func get n(): i64 { return this.v; }
+  func set n(x: i64) { this.v = x; }
+  func get double(): i64 { return this.v * 2; }
+  func reset() { this.v = 0; }
+}
+`
+
+func TestPropertyAccessors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// Instructions expected in the IR of f, in order
+		insts []string
+	}{
+		{"read", "return k.n + k.double;", []string{"call i64 @main.Count.get.n(", "call i64 @main.Count.get.double("}},
+		{"write", "k.n = 5; return 0;", []string{"call void @main.Count.set.n(%Count* %2, i64 5)"}},
+		{"compound assignment", "k.n += 2; return 0;", []string{"call i64 @main.Count.get.n(", "add i64", "call void @main.Count.set.n("}},
+		{"postfix increment", "var old: i64 = k.n++; return old;", []string{"call i64 @main.Count.get.n(", "add i64 %4, 1", "call void @main.Count.set.n(%Count* %3, i64 %5)", "store i64 %4"}},
+		{"prefix decrement", "return --k.n;", []string{"call i64 @main.Count.get.n(", "sub i64 %3, 1", "call void @main.Count.set.n(%Count* %2, i64 %4)", "ret i64 %4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := compileFiles(t, map[string]string{"main.cffc": counter + "func f(k: *Count): i64 {\n" + tt.body + "\n}\nfunc main(): i32 { return 0; }\n"})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			ir := funcIR(t, c, "main.f")
+			rest := ir
+			for _, inst := range tt.insts {
+				i := strings.Index(rest, inst)
+				if i < 0 {
+					t.Fatalf("expected %q in the IR of f, after the instructions before it:\n%s", inst, ir)
+				}
+				rest = rest[i+len(inst):]
+			}
+		})
+	}
+}
+
+func TestPropertyBackingField(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": counter + "func main(): i32 { return 0; }\n"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Methods of the class use the field, not the accessors
+	if ir := funcIR(t, c, "main.Count.reset"); strings.Contains(ir, "@main.Count.set.n") {
+		t.Errorf("expected reset to store to the field:\n%s", ir)
+	}
+}
+
+func TestVariableIncrement(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": `package main;
+func f(): i64 {
+  var i: i64 = 1;
+  var j: i64 = i++;
+  return i + j;
+}
+func main(): i32 { return 0; }
+`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// The incremented value is stored back, the old one is the result
+	if ir := funcIR(t, c, "main.f"); !strings.Contains(ir, "%4 = add i64 %3, 1\n\tstore i64 %4, i64* %1\n\tstore i64 %3, i64* %2") {
+		t.Errorf("expected i++ to store the incremented value:\n%s", ir)
+	}
+}
@@ -3,18 +3,21 @@ package compiler
 import (
 	"strings"
 
-	"github.com/fatih/color"
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
-	"github.com/urfave/cli/v2"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
 	"github.com/vyPal/CaffeineC/lib/parser"
 )
 
 func (ctx *Context) compileStatement(s *parser.Statement) error {
 	if s.VariableDefinition != nil {
+		if ctx.Block == nil {
+			return ctx.compileGlobalVariable(s.VariableDefinition, false)
+		}
 		_, _, _, err := ctx.compileVariableDefinition(s.VariableDefinition)
 		return err
 	} else if s.Assignment != nil {
@@ -25,6 +28,14 @@ func (ctx *Context) compileStatement(s *parser.Statement) error {
 	} else if s.ClassDefinition != nil {
 		_, _, _, err := ctx.compileClassDefinition(s.ClassDefinition)
 		return err
+	} else if s.Interface != nil {
+		ctx.declareName(s.Interface, s.Interface.Name)
+		return ctx.declareInterface(s.Interface, s.Interface.Name)
+	} else if s.Enum != nil {
+		ctx.declareName(s.Enum, s.Enum.Name)
+		return ctx.declareEnum(s.Enum, s.Enum.Name)
+	} else if s.Match != nil {
+		return ctx.compileMatch(s.Match)
 	} else if s.If != nil {
 		return ctx.compileIf(s.If)
 	} else if s.For != nil {
@@ -33,11 +44,23 @@ func (ctx *Context) compileStatement(s *parser.Statement) error {
 		return ctx.compileWhile(s.While)
 	} else if s.Until != nil {
 		return ctx.compileUntil(s.Until)
+	} else if s.Switch != nil {
+		return ctx.compileSwitch(s.Switch)
 	} else if s.Return != nil {
 		return ctx.compileReturn(s.Return)
+	} else if s.TryCatch != nil {
+		return ctx.compileTryCatch(s.TryCatch)
+	} else if s.Throw != nil {
+		return ctx.compileThrow(s.Throw)
 	} else if s.Break != nil {
+		if err := ctx.emitCleanups(ctx.fc.LeaveCleanup); err != nil {
+			return err
+		}
 		ctx.NewBr(ctx.fc.Leave)
 	} else if s.Continue != nil {
+		if err := ctx.emitCleanups(ctx.fc.ContinueCleanup); err != nil {
+			return err
+		}
 		ctx.NewBr(ctx.fc.Continue)
 	} else if s.Expression != nil {
 		_, err := ctx.compileExpression(s.Expression)
@@ -47,10 +70,17 @@ func (ctx *Context) compileStatement(s *parser.Statement) error {
 	} else if s.External != nil {
 		ctx.compileExternalFunction(s.External)
 	} else if s.Import != nil {
-		return ctx.Compiler.ImportAll(s.Import.Package, ctx)
+		if s.Import.Alias != "" {
+			return importError(s.Pos, ctx.Compiler.ImportModule(s.Import.Package, s.Import.Alias, ctx))
+		}
+		return importError(s.Pos, ctx.Compiler.ImportAll(s.Import.Package, ctx))
 	} else if s.FromImport != nil {
-		symbols := map[string]string{strings.Trim(s.FromImport.Symbol, "\""): strings.Trim(s.FromImport.Symbol, "\"")}
-		ctx.Compiler.ImportAs(s.FromImport.Package, symbols, ctx)
+		alias := s.FromImport.Alias
+		if alias == "" {
+			alias = s.FromImport.Symbol
+		}
+		symbols := map[string]string{strings.Trim(s.FromImport.Symbol, "\""): strings.Trim(alias, "\"")}
+		return importError(s.Pos, ctx.Compiler.ImportAs(s.FromImport.Package, symbols, ctx))
 	} else if s.FromImportMultiple != nil {
 		symbols := map[string]string{}
 		for _, symbol := range s.FromImportMultiple.Symbols {
@@ -59,8 +89,11 @@ func (ctx *Context) compileStatement(s *parser.Statement) error {
 			}
 			symbols[strings.Trim(symbol.Name, "\"")] = strings.Trim(symbol.Alias, "\"")
 		}
-		ctx.Compiler.ImportAs(s.FromImportMultiple.Package, symbols, ctx)
+		return importError(s.Pos, ctx.Compiler.ImportAs(s.FromImportMultiple.Package, symbols, ctx))
 	} else if s.Export != nil {
+		if s.Export.VariableDefinition != nil && ctx.Block == nil {
+			return ctx.compileGlobalVariable(s.Export.VariableDefinition, true)
+		}
 		return ctx.compileStatement(s.Export)
 	} else if s.Comment != nil {
 		return nil
@@ -82,28 +115,65 @@ func (ctx *Context) compileExternalFunction(v *parser.ExternalFunctionDefinition
 
 	v.Name = strings.Trim(v.Name, "\"")
 
+	// The function may already be declared by an import or the runtime
+	if _, ok := ctx.lookupFunction(v.Name); ok {
+		return
+	}
+
 	fn := ctx.Module.NewFunc(v.Name, retType, args...)
 	fn.Sig.Variadic = v.Variadic
 }
 
+// variableType returns the type of the variable v. Variables defined without
+// a type have the type the checker inferred from their initializer, nil is
+// returned if it is only known once the initializer is compiled, like in
+// generic templates.
+func (ctx *Context) variableType(v *parser.VariableDefinition) (types.Type, error) {
+	if v.Type != nil {
+		return ctx.CFTypeToLLType(v.Type), nil
+	}
+	if v.Assignment == nil {
+		return nil, posError(v.Pos, "Variable %s needs a type or an initial value", v.Name)
+	}
+	if ctx.Info == nil {
+		return nil, nil
+	}
+	if obj, ok := ctx.Info.Defs[v]; ok {
+		if typ, ok := ctx.checkedType(obj.Type); ok {
+			return typ, nil
+		}
+	}
+	return nil, nil
+}
+
 func (ctx *Context) compileVariableDefinition(v *parser.VariableDefinition) (Name string, Type types.Type, Value value.Value, Err error) {
-	// If there is no assignment, create an uninitialized variable
-	valType := ctx.CFTypeToLLType(v.Type)
+	if v.Constant == "const" && v.Assignment == nil {
+		return "", nil, nil, posError(v.Pos, "Constant definition must have assignment")
+	}
+	valType, err := ctx.variableType(v)
+	if err != nil {
+		return "", nil, nil, err
+	}
 
 	if v.Constant == "const" {
-		if v.Assignment == nil {
-			return "", nil, nil, posError(v.Pos, "Constant definition must have assignment")
-		}
-
-		cVal, err := ctx.compileExpression(v.Assignment)
-		if err != nil {
+		// Constants with a known value are folded, so they can be used as
+		// array sizes and switch cases
+		var cVal value.Value
+		if folded, ok := ctx.foldedConstant(v, valType); ok {
+			cVal = folded
+		} else if cVal, err = ctx.compileExpression(v.Assignment); err != nil {
 			return "", nil, nil, err
 		}
+		if valType == nil {
+			valType = cVal.Type()
+		}
 
 		ctx.vars[v.Name] = &Variable{
-			Name:  v.Name,
-			Type:  valType,
-			Value: cVal,
+			Name:     v.Name,
+			Type:     valType,
+			Value:    cVal,
+			Pos:      v.Pos,
+			Constant: true,
 		}
 
 		return v.Name, valType, cVal, nil
@@ -112,38 +182,116 @@ func (ctx *Context) compileVariableDefinition(v *parser.VariableDefinition) (Nam
 	if v.Assignment == nil {
 		alloc := ctx.NewAlloca(valType)
 		ctx.NewStore(constant.NewZeroInitializer(valType), alloc)
+		if classType, ok := valType.(*types.StructType); ok {
+			ctx.initInstance(alloc, classType)
+		}
 		ctx.vars[v.Name] = &Variable{
 			Name:  v.Name,
 			Type:  valType,
 			Value: alloc,
+			Pos:   v.Pos,
 		}
 		return v.Name, alloc.Type(), alloc, nil
 	}
 
-	alloc := ctx.NewAlloca(valType)
+	if valType == nil {
+		// The type is known once the initializer is compiled
+		val, err := ctx.compileExpression(v.Assignment)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		valType = val.Type()
+		alloc := ctx.NewAlloca(valType)
+		ctx.NewStore(val, alloc)
+		ctx.vars[v.Name] = &Variable{
+			Name:  v.Name,
+			Type:  valType,
+			Value: alloc,
+			Pos:   v.Pos,
+		}
+		return v.Name, alloc.Type(), alloc, nil
+	}
 
-	ctx.RequestedType = valType
-	ctx.DestPtr = alloc
-	ctx.StoredInDest = false
+	alloc := ctx.NewAlloca(valType)
 	val, err := ctx.compileExpression(v.Assignment)
 	if err != nil {
 		return "", nil, nil, err
 	}
-	ctx.RequestedType = nil
-	ctx.DestPtr = nil
-	if !ctx.StoredInDest {
-		ctx.NewStore(val, alloc)
-		ctx.StoredInDest = false
-	}
+	ctx.NewStore(val, alloc)
 
 	ctx.vars[v.Name] = &Variable{
 		Name:  v.Name,
 		Type:  valType,
 		Value: alloc,
+		Pos:   v.Pos,
 	}
 	return v.Name, alloc.Type(), alloc, nil
 }
 
+// compileGlobalVariable compiles a variable or constant defined at file scope.
+// Constant initializers are stored in the global directly, others are
+// evaluated by the module initializer before main runs.
+func (ctx *Context) compileGlobalVariable(v *parser.VariableDefinition, exported bool) error {
+	isConst := v.Constant == "const"
+
+	if isConst && v.Assignment == nil {
+		return posError(v.Pos, "Constant definition must have assignment")
+	}
+	valType, err := ctx.variableType(v)
+	if err != nil {
+		return err
+	}
+	if valType == nil {
+		return posError(v.Pos, "Unable to infer the type of %s, it needs a type", v.Name)
+	}
+
+	var init constant.Constant = constant.NewZeroInitializer(valType)
+	if classType, ok := valType.(*types.StructType); ok {
+		init = ctx.zeroInstance(classType)
+	}
+	global := ctx.Module.NewGlobalDef(mangle(ctx.AST.Package, v.Name), init)
+	if !exported {
+		global.Linkage = enum.LinkageInternal
+	}
+
+	variable := &Variable{
+		Name:     v.Name,
+		Type:     valType,
+		Value:    global,
+		Pos:      v.Pos,
+		Constant: isConst,
+	}
+
+	if folded, ok := ctx.foldedConstant(v, valType); ok {
+		// Constants with a known value are used directly
+		global.Init = folded
+		global.Immutable = true
+		variable.Value = folded
+	} else if v.Assignment != nil {
+		initCtx := ctx.moduleInit()
+		val, err := initCtx.compileExpression(v.Assignment)
+		if err != nil {
+			return err
+		}
+		if c, ok := val.(constant.Constant); ok {
+			if !c.Type().Equal(valType) {
+				return posError(v.Assignment.Pos, "Cannot initialize %s of type %s with a value of type %s", v.Name, valType, c.Type())
+			}
+			global.Init = c
+			if isConst {
+				// Constants with a known value are used directly
+				global.Immutable = true
+				variable.Value = c
+			}
+		} else {
+			initCtx.NewStore(val, global)
+		}
+	}
+
+	ctx.vars[v.Name] = variable
+	return nil
+}
+
 func (ctx *Context) compileAssignment(a *parser.Assignment) (Err error) {
 	type Ident struct {
 		Value value.Value
@@ -152,29 +300,39 @@ func (ctx *Context) compileAssignment(a *parser.Assignment) (Err error) {
 	var idents = make([]Ident, len(a.Idents))
 
 	for index, ident := range a.Idents {
-		i, t, err := ctx.compileIdentifier(ident, false)
+		if err := ctx.checkMutable(ident, "assign to"); err != nil {
+			return err
+		}
+		i, t, property, err := ctx.compileAssignmentTarget(ident)
 		if err != nil {
 			return err
 		}
+		if property != nil {
+			return ctx.compilePropertyAssignment(a, property)
+		}
 
-		if a.Op != "=" && !isNumeric(t) {
+		if _, isClass := ctx.classOf(t); a.Op != "=" && !isNumeric(t) && !isClass {
 			return posError(ident.Pos, "Numeric operator used on non-numeric identifier %s", ident.Name)
 		}
 
 		idents[index] = Ident{Value: i, Type: t}
 	}
 
-	if _, ok := idents[0].Type.(*types.PointerType); ok {
-		ctx.RequestedType = idents[0].Type.(*types.PointerType).ElemType
-	}
-	ctx.RequestedType = idents[0].Type
-	ctx.DestPtr = idents[0].Value
-	ctx.StoredInDest = false
 	val, err := ctx.compileExpression(a.Right)
 	if err != nil {
 		return err
 	}
-	ctx.RequestedType = nil
+	if _, ok := ctx.classOf(idents[0].Type); ok && a.Op != "=" {
+		return ctx.compileCompoundOverload(a, idents[0].Value, val)
+	}
+	destType, inMemory := idents[0].Value.Type(), false
+	switch target := idents[0].Value.(type) {
+	case *ir.InstAlloca, *ir.Global, *ir.InstGetElementPtr:
+		destType, inMemory = target.Type().(*types.PointerType).ElemType, true
+	}
+	if inMemory && len(idents) == 1 && a.Op == "=" && !val.Type().Equal(destType) && !isStorage(val, destType) {
+		return posError(a.Right.Pos, "Cannot assign a value of type %s to %s of type %s", ctx.TypeToString(val.Type()), a.Idents[0].Name, ctx.TypeToString(destType))
+	}
 
 	if a.Op != "=" {
 		if !isNumeric(val.Type()) {
@@ -182,66 +340,27 @@ func (ctx *Context) compileAssignment(a *parser.Assignment) (Err error) {
 		}
 
 		for i, ident := range idents {
-			_, isFloat := ident.Value.Type().(*types.FloatType)
-			var v value.Value
-			switch a.Op {
-			case "+=":
-				if isFloat {
-					v = ctx.NewFAdd(ident.Value, val)
-				} else {
-					v = ctx.NewAdd(ident.Value, val)
-				}
-			case "-=":
-				if isFloat {
-					v = ctx.NewFSub(ident.Value, val)
-				} else {
-					v = ctx.NewSub(ident.Value, val)
-				}
-			case "*=":
-				if isFloat {
-					v = ctx.NewFMul(ident.Value, val)
-				} else {
-					v = ctx.NewMul(ident.Value, val)
-				}
-			case "/=":
-				if isFloat {
-					v = ctx.NewFDiv(ident.Value, val)
-				} else {
-					v = ctx.NewSDiv(ident.Value, val)
-				}
-			case "%=":
-				if isFloat {
-					return posError(a.Pos, "Modulus operator not allowed on float")
-				}
-				v = ctx.NewSRem(ident.Value, val)
-			case "&=":
-				v = ctx.NewAnd(ident.Value, val)
-			case "|=":
-				v = ctx.NewOr(ident.Value, val)
-			case "^=":
-				v = ctx.NewXor(ident.Value, val)
-			case "<<=":
-				v = ctx.NewShl(ident.Value, val)
-			case ">>=":
-				v = ctx.NewLShr(ident.Value, val)
-			case ">>>=":
-				v = ctx.NewAShr(ident.Value, val)
-			case "??=":
-				isNull := ctx.NewICmp(enum.IPredEQ, ident.Value, constant.NewNull(ident.Value.Type().(*types.PointerType)))
-				v = ctx.NewSelect(isNull, val, ident.Value)
+			// Variables stored in memory have to be loaded first
+			current := ident.Value
+			switch ident.Value.(type) {
+			case *ir.InstAlloca, *ir.Global, *ir.InstGetElementPtr:
+				current = ctx.NewLoad(ident.Value.Type().(*types.PointerType).ElemType, ident.Value)
+			}
+			v, err := ctx.compoundValue(a.Pos, a.Op, current, val)
+			if err != nil {
+				return err
 			}
 
 			ptr, ok := ident.Value.(*ir.InstGetElementPtr)
 			if !ok {
-				aptr, ok := ident.Value.(*ir.InstAlloca)
-				if !ok {
+				if !isVariableStorage(ident.Value) {
 					ctx.vars[a.Idents[i].Name] = &Variable{
 						Name:  a.Idents[i].Name,
 						Type:  ident.Type,
 						Value: v,
 					}
 				} else {
-					ctx.NewStore(v, aptr)
+					ctx.NewStore(v, ident.Value)
 				}
 			} else {
 				ctx.NewStore(v, ptr)
@@ -249,26 +368,22 @@ func (ctx *Context) compileAssignment(a *parser.Assignment) (Err error) {
 		}
 	} else {
 		if len(idents) == 1 {
-			if ctx.StoredInDest {
-				return nil
-			} else {
-				switch value := idents[0].Value.(type) {
-				case *ir.InstGetElementPtr:
-					if pt, ok := val.Type().(*types.PointerType); ok {
-						ctx.NewStore(ctx.NewLoad(pt.ElemType, val), value)
-					} else {
-						ctx.NewStore(val, value)
-					}
-				case *ir.InstAlloca:
-					ctx.NewStore(val, value)
-				case *ir.InstLoad:
+			switch value := idents[0].Value.(type) {
+			case *ir.InstGetElementPtr:
+				if elemType := value.Type().(*types.PointerType).ElemType; isStorage(val, elemType) {
+					ctx.NewStore(ctx.NewLoad(elemType, val), value)
+				} else {
 					ctx.NewStore(val, value)
-				default:
-					ctx.vars[a.Idents[0].Name] = &Variable{
-						Name:  a.Idents[0].Name,
-						Type:  idents[0].Type,
-						Value: val,
-					}
+				}
+			case *ir.InstAlloca, *ir.Global:
+				ctx.NewStore(val, value)
+			case *ir.InstLoad:
+				ctx.NewStore(val, value)
+			default:
+				ctx.vars[a.Idents[0].Name] = &Variable{
+					Name:  a.Idents[0].Name,
+					Type:  idents[0].Type,
+					Value: val,
 				}
 			}
 		} else {
@@ -283,15 +398,14 @@ func (ctx *Context) compileAssignment(a *parser.Assignment) (Err error) {
 			for i, ident := range idents {
 				ptr, ok := ident.Value.(*ir.InstGetElementPtr)
 				if !ok {
-					aptr, ok := ident.Value.(*ir.InstAlloca)
-					if !ok {
+					if !isVariableStorage(ident.Value) {
 						ctx.vars[a.Idents[i].Name] = &Variable{
 							Name:  a.Idents[i].Name,
 							Type:  ident.Type,
 							Value: ctx.NewExtractValue(val, uint64(i)),
 						}
 					} else {
-						ctx.NewStore(ctx.NewExtractValue(val, uint64(i)), aptr)
+						ctx.NewStore(ctx.NewExtractValue(val, uint64(i)), ident.Value)
 					}
 				} else {
 					ctx.NewStore(ctx.NewExtractValue(val, uint64(i)), ptr)
@@ -303,7 +417,143 @@ func (ctx *Context) compileAssignment(a *parser.Assignment) (Err error) {
 	return nil
 }
 
+// checkMutable returns an error if ident refers to a constant, which can not
+// be modified. action describes the modification, as in "Cannot assign to".
+func (ctx *Context) checkMutable(ident *parser.Identifier, action string) error {
+	i := ctx.resolveModule(ident)
+	if i.Sub != nil || i.Deref != "" {
+		return nil
+	}
+	v := ctx.lookupVariable(i.Name)
+	if v == nil || !v.Constant {
+		return nil
+	}
+	d := diagnostics.Errorf(diagnostics.CodeCompile, diagnostics.At(ident.Pos), "Cannot %s constant %s", action, i.Name)
+	if v.Pos.Line != 0 {
+		d.WithNote(diagnostics.At(v.Pos), "constant %s defined here", v.Name)
+	}
+	return d
+}
+
+// compoundValue computes the value a compound assignment with op stores,
+// given the current value of the target and the assigned value.
+func (ctx *Context) compoundValue(pos lexer.Position, op string, current value.Value, val value.Value) (value.Value, error) {
+	_, isFloat := current.Type().(*types.FloatType)
+	unsigned := isUnsigned(current.Type())
+	var v value.Value
+	switch op {
+	case "+=":
+		if isFloat {
+			v = ctx.NewFAdd(current, val)
+		} else {
+			v = ctx.NewAdd(current, val)
+		}
+	case "-=":
+		if isFloat {
+			v = ctx.NewFSub(current, val)
+		} else {
+			v = ctx.NewSub(current, val)
+		}
+	case "*=":
+		if isFloat {
+			v = ctx.NewFMul(current, val)
+		} else {
+			v = ctx.NewMul(current, val)
+		}
+	case "/=":
+		if isFloat {
+			v = ctx.NewFDiv(current, val)
+		} else if unsigned {
+			v = ctx.NewUDiv(current, val)
+		} else {
+			v = ctx.NewSDiv(current, val)
+		}
+	case "%=":
+		if isFloat {
+			return nil, posError(pos, "Modulus operator not allowed on float")
+		}
+		if unsigned {
+			v = ctx.NewURem(current, val)
+		} else {
+			v = ctx.NewSRem(current, val)
+		}
+	case "&=":
+		v = ctx.NewAnd(current, val)
+	case "|=":
+		v = ctx.NewOr(current, val)
+	case "^=":
+		v = ctx.NewXor(current, val)
+	case "<<=":
+		v = ctx.NewShl(current, val)
+	case ">>=":
+		if unsigned {
+			v = ctx.NewLShr(current, val)
+		} else {
+			v = ctx.NewAShr(current, val)
+		}
+	case ">>>=":
+		v = ctx.NewLShr(current, val)
+	case "??=":
+		isNull := ctx.NewICmp(enum.IPredEQ, current, constant.NewNull(current.Type().(*types.PointerType)))
+		v = ctx.NewSelect(isNull, val, current)
+	}
+	return v, nil
+}
+
+// compileCompoundOverload compiles a compound assignment to a class instance,
+// which assigns the result of the overloaded operator to the target.
+func (ctx *Context) compileCompoundOverload(a *parser.Assignment, target value.Value, val value.Value) error {
+	if len(a.Idents) != 1 {
+		return posError(a.Pos, "Compound assignment to class instances only supports a single target")
+	}
+
+	// Pointers to instances are loaded, instances stored by value are used in place
+	current := target
+	storage, inMemory := target.Type().(*types.PointerType)
+	if _, ok := target.(*ir.InstGetElementPtr); ok || isVariableStorage(target) {
+		if _, ok := storage.ElemType.(*types.PointerType); ok {
+			current = ctx.NewLoad(storage.ElemType, target)
+		}
+	} else {
+		inMemory = false
+	}
+
+	method, err := ctx.operatorMethod(a.Pos, current, strings.TrimSuffix(a.Op, "="), 1)
+	if err != nil {
+		return err
+	}
+	result, err := ctx.callOperator(a.Right.Pos, method, current, val)
+	if err != nil {
+		return err
+	}
+
+	if !inMemory {
+		ctx.vars[a.Idents[0].Name] = &Variable{Name: a.Idents[0].Name, Type: result.Type(), Value: result}
+		return nil
+	}
+
+	// An instance returned for a pointer target replaces the instance it points to
+	dest, destType := target, storage.ElemType
+	if ptrType, ok := destType.(*types.PointerType); ok && !result.Type().Equal(destType) {
+		dest, destType = current, ptrType.ElemType
+	}
+	converted, err := ctx.convertValue(a.Right.Pos, result, destType)
+	if err != nil {
+		return posError(a.Right.Pos, "Cannot assign the result of operator %s (%s) to %s", a.Op, ctx.TypeToString(result.Type()), ctx.TypeToString(destType))
+	}
+	ctx.NewStore(converted, dest)
+	return nil
+}
+
 func (ctx *Context) compileFunctionDefinition(f *parser.FunctionDefinition) (Name string, ReturnType types.Type, Args []*ir.Param, err error) {
+	if len(f.TypeParams) > 0 {
+		if f.Extern {
+			return "", nil, nil, posError(f.Pos, "Extern functions can not have type parameters")
+		}
+		ctx.genericFuncs[f.Name.Name] = &genericFunc{FunctionDefinition: f}
+		return f.Name.Name, nil, nil, nil
+	}
+
 	var params []*ir.Param
 	for _, arg := range f.Parameters {
 		params = append(params, ir.NewParam(arg.Name, ctx.CFTypeToLLType(arg.Type)))
@@ -314,24 +564,22 @@ func (ctx *Context) compileFunctionDefinition(f *parser.FunctionDefinition) (Nam
 
 	retType := ctx.CFMultiTypeToLLType(f.ReturnType)
 
-	fn := ctx.Module.NewFunc(f.Name.Name, retType, params...)
+	fn := ctx.Module.NewFunc(functionSymbol(ctx.pkg(), f), retType, params...)
 	if f.Variadic != "" {
 		fn.Sig.Variadic = true
 	}
 	block := fn.NewBlock("")
 	nctx := NewContext(block, ctx.Compiler)
+	nctx.typeParams = ctx.typeParams
+	nctx.scope = ctx.scope
 	ctx.SymbolTable[f.Name.Name] = fn
 
-	for _, stmt := range f.Body {
-		err := nctx.compileStatement(stmt)
-		if err != nil {
-			return "", nil, []*ir.Param{}, err
-		}
-	}
+	nctx.spillParams(fn)
+	ok := nctx.compileBody(f.Body)
 	if nctx.Term == nil {
 		if retType.Equal(types.Void) {
 			nctx.NewRet(nil)
-		} else {
+		} else if ok {
 			return "", nil, nil, posError(f.Pos, "Function `%s` does not return a value", f.Name.Name)
 		}
 	}
@@ -340,15 +588,27 @@ func (ctx *Context) compileFunctionDefinition(f *parser.FunctionDefinition) (Nam
 }
 
 func (ctx *Context) compileClassDefinition(c *parser.ClassDefinition) (Name string, TypeDef *types.StructType, Methods []ir.Func, err error) {
-	classType := types.NewStruct()
-	classType.SetName(c.Name)
-	ctx.structNames[classType] = c.Name
-	ctx.Module.NewTypeDef(c.Name, classType)
+	ctx.declareName(c, c.Name)
+	if len(c.TypeParams) > 0 {
+		ctx.genericClasses[c.Name] = &genericClass{ClassDefinition: c}
+		return c.Name, nil, nil, nil
+	}
+
+	classType, err := ctx.declareClass(c, c.Name, ctx.AST.Package)
+	if err != nil {
+		return "", nil, []ir.Func{}, err
+	}
+	for _, v := range staticFields(c) {
+		if err := ctx.compileGlobalVariable(v, true); err != nil {
+			return "", nil, []ir.Func{}, err
+		}
+	}
+
 	for _, s := range c.Body {
-		if s.FieldDefinition != nil {
-			classType.Fields = append(classType.Fields, ctx.CFTypeToLLType(s.FieldDefinition.Type))
-			ctx.Compiler.StructFields[c.Name] = append(ctx.Compiler.StructFields[c.Name], s.FieldDefinition)
-		} else if s.FunctionDefinition != nil {
+		if s.FunctionDefinition != nil {
+			if len(s.FunctionDefinition.TypeParams) > 0 {
+				return "", nil, []ir.Func{}, posError(s.FunctionDefinition.Pos, "Methods can not have type parameters")
+			}
 			err := ctx.compileClassMethodDefinition(s.FunctionDefinition, c.Name, classType)
 			if err != nil {
 				return "", nil, []ir.Func{}, err
@@ -359,9 +619,13 @@ func (ctx *Context) compileClassDefinition(c *parser.ClassDefinition) (Name stri
 	return c.Name, classType, nil, nil
 }
 
-func (ctx *Context) compileClassMethodDefinition(f *parser.FunctionDefinition, cname string, ctype *types.StructType) error {
+// declareClassMethod declares the function implementing a method of class
+// cname, which is known as name in this file.
+func (ctx *Context) declareClassMethod(f *parser.FunctionDefinition, cname string, name string, ctype *types.StructType) *ir.Func {
 	var params []*ir.Param
-	params = append(params, ir.NewParam("this", types.NewPointer(ctype)))
+	if !f.Static {
+		params = append(params, ir.NewParam("this", types.NewPointer(ctype)))
+	}
 	for _, arg := range f.Parameters {
 		params = append(params, ir.NewParam(arg.Name, ctx.CFTypeToLLType(arg.Type)))
 	}
@@ -369,103 +633,103 @@ func (ctx *Context) compileClassMethodDefinition(f *parser.FunctionDefinition, c
 		params = append(params, ir.NewParam(f.Variadic, types.I8Ptr))
 	}
 
-	trimmed := strings.Trim(f.Name.Name, "\"")
-	ms := "." + f.Name.Name
-	if f.Name.Op {
-		ms = ".op." + trimmed
-	} else if f.Name.Get {
-		ms = ".get." + trimmed
-	} else if f.Name.Set {
-		ms = ".set." + trimmed
-	}
-
-	retType := ctx.CFMultiTypeToLLType(f.ReturnType)
-
-	fn := ctx.Module.NewFunc(cname+ms, retType, params...)
+	ms := methodSuffix(f.Name)
+	fn := ctx.Module.NewFunc(cname+ms, ctx.CFMultiTypeToLLType(f.ReturnType), params...)
 	if f.Variadic != "" {
 		fn.Sig.Variadic = true
 	}
+	if f.Private {
+		fn.Linkage = enum.LinkageInternal
+	}
+	ctx.SymbolTable[name+ms] = fn
+	return fn
+}
+
+func methodSuffix(name parser.FuncName) string {
+	trimmed := strings.Trim(name.Name, "\"")
+	if name.Op {
+		return ".op." + trimmed
+	} else if name.Get {
+		return ".get." + trimmed
+	} else if name.Set {
+		return ".set." + trimmed
+	}
+	return "." + name.Name
+}
+
+func (ctx *Context) compileClassMethodDefinition(f *parser.FunctionDefinition, cname string, ctype *types.StructType) error {
+	ms := methodSuffix(f.Name)
+	fn := ctx.SymbolTable[cname+ms].(*ir.Func)
+	retType := fn.Sig.RetType
+
 	block := fn.NewBlock("")
 	nctx := NewContext(block, ctx.Compiler)
-	ctx.SymbolTable[cname+ms] = fn
-	for _, stmt := range f.Body {
-		err := nctx.compileStatement(stmt)
-		if err != nil {
-			return err
-		}
-	}
+	nctx.typeParams = ctx.typeParams
+	nctx.scope = ctx.scope
+	nctx.class = cname
+	nctx.spillParams(fn)
+	ok := nctx.compileBody(f.Body)
 	if nctx.Term == nil {
 		if retType.Equal(types.Void) {
 			nctx.NewRet(nil)
-		} else {
-			cli.Exit(color.RedString("Error: Method `%s` of class `%s` does not return a value", f.Name, cname), 1)
+		} else if ok {
+			return posError(f.Pos, "Method `%s` of class `%s` does not return a value", f.Name.Name, cname)
 		}
 	}
 
 	return nil
 }
 
-func (ctx *Context) compileIf(i *parser.If) error {
-	// Compile the condition
-	cond, err := ctx.compileExpression(i.Condition)
-	if err != nil {
-		return err
+// spillParams stores the parameters of fn on the stack, so they can be
+// assigned to like any other variable. this always points to the instance the
+// method was called on, so it is used as it is.
+func (ctx *Context) spillParams(fn *ir.Func) {
+	for _, param := range fn.Params {
+		if param.Name() == "this" {
+			continue
+		}
+		alloc := ctx.NewAlloca(param.Type())
+		ctx.NewStore(param, alloc)
+		ctx.vars[param.Name()] = &Variable{
+			Name:  param.Name(),
+			Type:  param.Type(),
+			Value: alloc,
+		}
 	}
+}
 
-	// Create blocks for the then, else and merge parts
-	thenBlock := ctx.Block.Parent.NewBlock("")
-	elseBlock := ctx.Block.Parent.NewBlock("")
+func (ctx *Context) compileIf(i *parser.If) error {
 	mergeBlock := ctx.Block.Parent.NewBlock("")
 
-	// Create the conditional branch
-	ctx.Block.NewCondBr(cond, thenBlock, elseBlock)
-
-	// Compile the then part
-	ctx.Block = thenBlock
-	for _, stmt := range i.Body {
-		err := ctx.compileStatement(stmt)
+	// Compile the if and else if parts, each condition is evaluated in the
+	// else block of the previous one
+	branches := append([]*parser.ElseIf{{Pos: i.Pos, Condition: i.Condition, Body: i.Body}}, i.ElseIf...)
+	for _, branch := range branches {
+		cond, err := ctx.compileExpression(branch.Condition)
 		if err != nil {
 			return err
 		}
-	}
-	if thenBlock.Term == nil {
-		thenBlock.NewBr(mergeBlock)
-	}
-
-	// Compile the else if parts
-	for _, elseif := range i.ElseIf {
-		cond, err := ctx.compileExpression(elseif.Condition)
-		if err != nil {
-			return err
+		if !isBool(cond.Type()) {
+			return posError(branch.Condition.Pos, "if condition must be a boolean")
 		}
-		newElseBlock := ctx.Block.Parent.NewBlock("")
-		ctx.Block = elseBlock
-		ctx.Block.NewCondBr(cond, thenBlock, newElseBlock)
+
+		thenBlock := ctx.Block.Parent.NewBlock("")
+		elseBlock := ctx.Block.Parent.NewBlock("")
+		ctx.Block.NewCondBr(cond, thenBlock, elseBlock)
+
 		ctx.Block = thenBlock
-		for _, stmt := range elseif.Body {
-			err := ctx.compileStatement(stmt)
-			if err != nil {
-				return err
-			}
+		ctx.compileBody(branch.Body)
+		if ctx.Block.Term == nil {
+			ctx.Block.NewBr(mergeBlock)
 		}
-		if thenBlock.Term == nil {
-			thenBlock.NewBr(mergeBlock)
-		}
-		elseBlock = newElseBlock
+
+		ctx.Block = elseBlock
 	}
 
 	// Compile the else part
-	ctx.Block = elseBlock
-	if i.Else != nil {
-		for _, stmt := range i.Else {
-			err := ctx.compileStatement(stmt)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	if elseBlock.Term == nil {
-		elseBlock.NewBr(mergeBlock)
+	ctx.compileBody(i.Else)
+	if ctx.Block.Term == nil {
+		ctx.Block.NewBr(mergeBlock)
 	}
 
 	// Continue with the merge block
@@ -479,10 +743,15 @@ func (ctx *Context) compileFor(f *parser.For) error {
 		return err
 	}
 
-	// Create the loop and leave blocks
+	// Create the loop, next and leave blocks
 	loopB := ctx.Block.Parent.NewBlock("")
+	nextB := ctx.Block.Parent.NewBlock("")
 	leaveB := ctx.Block.Parent.NewBlock("")
 	loopCtx := ctx.NewContext(loopB)
+	loopCtx.fc.Leave = leaveB
+	loopCtx.fc.Continue = nextB
+	loopCtx.fc.LeaveCleanup = loopCtx.cleanup
+	loopCtx.fc.ContinueCleanup = loopCtx.cleanup
 
 	// Compile the condition
 	cond, err := ctx.compileExpression(f.Condition)
@@ -496,12 +765,12 @@ func (ctx *Context) compileFor(f *parser.For) error {
 	// Set the current block to the loop block
 	ctx.Compiler.Context.Block = loopB
 
-	// Compile the body of the loop
-	for _, stmt := range f.Body {
-		if err := loopCtx.compileStatement(stmt); err != nil {
-			return err
-		}
+	// Compile the body of the loop, continue jumps to the increment
+	loopCtx.compileBody(f.Body)
+	if loopCtx.Term == nil {
+		loopCtx.NewBr(nextB)
 	}
+	loopCtx.Block = nextB
 
 	// Compile the increment expression
 	if err := loopCtx.compileStatement(f.Increment); err != nil {
@@ -530,19 +799,21 @@ func (ctx *Context) compileWhile(w *parser.While) error {
 	}
 
 	loopB := ctx.Block.Parent.NewBlock("")
+	nextB := ctx.Block.Parent.NewBlock("")
 	leaveB := ctx.Block.Parent.NewBlock("")
 	loopCtx := ctx.NewContext(loopB)
 
 	ctx.NewCondBr(cond, loopB, leaveB)
 	loopCtx.fc.Leave = leaveB
-	loopCtx.fc.Continue = loopB
+	loopCtx.fc.Continue = nextB
+	loopCtx.fc.LeaveCleanup = loopCtx.cleanup
+	loopCtx.fc.ContinueCleanup = loopCtx.cleanup
 
-	for _, stmt := range w.Body {
-		err := loopCtx.compileStatement(stmt)
-		if err != nil {
-			return err
-		}
+	loopCtx.compileBody(w.Body)
+	if loopCtx.Term == nil {
+		loopCtx.NewBr(nextB)
 	}
+	loopCtx.Block = nextB
 
 	cond, err = loopCtx.compileExpression(w.Condition)
 	if err != nil {
@@ -561,19 +832,21 @@ func (ctx *Context) compileUntil(u *parser.Until) error {
 	}
 
 	loopB := ctx.Block.Parent.NewBlock("")
+	nextB := ctx.Block.Parent.NewBlock("")
 	leaveB := ctx.Block.Parent.NewBlock("")
 	loopCtx := ctx.NewContext(loopB)
 
 	ctx.NewCondBr(cond, leaveB, loopB)
 	loopCtx.fc.Leave = leaveB
-	loopCtx.fc.Continue = loopB
+	loopCtx.fc.Continue = nextB
+	loopCtx.fc.LeaveCleanup = loopCtx.cleanup
+	loopCtx.fc.ContinueCleanup = loopCtx.cleanup
 
-	for _, stmt := range u.Body {
-		err := loopCtx.compileStatement(stmt)
-		if err != nil {
-			return err
-		}
+	loopCtx.compileBody(u.Body)
+	if loopCtx.Term == nil {
+		loopCtx.NewBr(nextB)
 	}
+	loopCtx.Block = nextB
 
 	cond, err = loopCtx.compileExpression(u.Condition)
 	if err != nil {
@@ -585,14 +858,257 @@ func (ctx *Context) compileUntil(u *parser.Until) error {
 	return nil
 }
 
+func (ctx *Context) compileSwitch(s *parser.Switch) error {
+	// Compile the value being switched on
+	cond, err := ctx.compileExpression(s.Condition)
+	if err != nil {
+		return err
+	}
+
+	// Create blocks for every case, the default branch and the merge point
+	mergeBlock := ctx.Block.Parent.NewBlock("")
+	defaultBlock := mergeBlock
+	if s.Default != nil {
+		defaultBlock = ctx.Block.Parent.NewBlock("")
+	}
+	caseBlocks := make([]*ir.Block, len(s.Cases))
+	for i := range s.Cases {
+		caseBlocks[i] = ctx.Block.Parent.NewBlock("")
+	}
+
+	// Find constant case values and reject duplicates
+	allConstant := true
+	seen := make(map[string]bool)
+	for _, c := range s.Cases {
+		for _, v := range c.Values {
+			key := ""
+			if val, ok := ctx.constantValue(v); ok {
+				if i, ok := val.(*constant.Int); ok {
+					key = i.X.String()
+				} else {
+					allConstant = false
+				}
+			} else if f := v.SingleFactor(); f != nil && f.Value != nil && f.Value.String != nil {
+				key = *f.Value.String
+				allConstant = false
+			} else {
+				allConstant = false
+			}
+
+			if key == "" {
+				continue
+			}
+			if seen[key] {
+				return posError(v.Pos, "Duplicate case value %s in switch statement", key)
+			}
+			seen[key] = true
+		}
+	}
+
+	if _, ok := cond.Type().(*types.IntType); ok && allConstant {
+		// Every case is a constant integer, so a native switch instruction can be used
+		var cases []*ir.Case
+		for i, c := range s.Cases {
+			for _, v := range c.Values {
+				val, _ := ctx.constantValue(v)
+				if !val.Type().Equal(cond.Type()) {
+					return posError(v.Pos, "Case value must be the same type as the switch value (%s != %s)", val.Type(), cond.Type())
+				}
+				cases = append(cases, ir.NewCase(val, caseBlocks[i]))
+			}
+		}
+		ctx.NewSwitch(cond, defaultBlock, cases...)
+	} else {
+		// Otherwise compare against each case value in order
+		for i, c := range s.Cases {
+			for _, v := range c.Values {
+				val, err := ctx.compileExpression(v)
+				if err != nil {
+					return err
+				}
+
+				match, err := ctx.compileCaseMatch(cond, val, v.Pos)
+				if err != nil {
+					return err
+				}
+
+				nextBlock := ctx.Block.Parent.NewBlock("")
+				ctx.NewCondBr(match, caseBlocks[i], nextBlock)
+				ctx.Block = nextBlock
+			}
+		}
+		ctx.NewBr(defaultBlock)
+	}
+
+	// Compile the case bodies, a break leaves the switch
+	for i, c := range s.Cases {
+		if err := ctx.compileSwitchBody(c.Body, caseBlocks[i], mergeBlock); err != nil {
+			return err
+		}
+	}
+	if s.Default != nil {
+		if err := ctx.compileSwitchBody(s.Default, defaultBlock, mergeBlock); err != nil {
+			return err
+		}
+	}
+
+	// Continue with the merge block
+	ctx.Block = mergeBlock
+	return nil
+}
+
+func (ctx *Context) compileSwitchBody(body []*parser.Statement, block *ir.Block, mergeBlock *ir.Block, bindings ...*Variable) error {
+	caseCtx := ctx.NewContext(block)
+	caseCtx.fc.Leave = mergeBlock
+	caseCtx.fc.LeaveCleanup = ctx.cleanup
+	for _, v := range bindings {
+		caseCtx.vars[v.Name] = v
+	}
+
+	caseCtx.compileBody(body)
+	if caseCtx.Term == nil {
+		caseCtx.NewBr(mergeBlock)
+	}
+
+	return nil
+}
+
+func (ctx *Context) compileCaseMatch(cond value.Value, val value.Value, pos lexer.Position) (value.Value, error) {
+	if isString(cond.Type()) && isString(val.Type()) {
+		strcmp, ok := ctx.lookupFunction("strcmp")
+		if !ok {
+			strcmp = ctx.Module.NewFunc("strcmp", types.I32, ir.NewParam("a", types.I8Ptr), ir.NewParam("b", types.I8Ptr))
+		}
+		retType, ok := strcmp.Sig.RetType.(*types.IntType)
+		if !ok {
+			return nil, posError(pos, "strcmp must return an integer")
+		}
+
+		res := ctx.NewCall(strcmp, ctx.NewBitCast(cond, types.I8Ptr), ctx.NewBitCast(val, types.I8Ptr))
+		return ctx.NewICmp(enum.IPredEQ, res, constant.NewInt(retType, 0)), nil
+	}
+
+	if !cond.Type().Equal(val.Type()) {
+		return nil, posError(pos, "Case value must be the same type as the switch value (%s != %s)", val.Type(), cond.Type())
+	}
+
+	switch cond.Type().(type) {
+	case *types.IntType, *types.PointerType:
+		return ctx.NewICmp(enum.IPredEQ, cond, val), nil
+	case *types.FloatType:
+		return ctx.NewFCmp(enum.FPredOEQ, cond, val), nil
+	default:
+		return nil, posError(pos, "Cannot switch on a value of type %s", cond.Type())
+	}
+}
+
+func (ctx *Context) compileTryCatch(t *parser.TryCatch) error {
+	tryBlock := ctx.Block.Parent.NewBlock("")
+	catchBlock := ctx.Block.Parent.NewBlock("")
+	finallyBlock := ctx.Block.Parent.NewBlock("")
+
+	frame := ctx.pushExceptionFrame(tryBlock, catchBlock)
+
+	// Compile the try part, leaving it in any way pops the frame and runs finally
+	tryCtx := ctx.NewContext(tryBlock)
+	tryCtx.cleanup = &Cleanup{
+		parent: ctx.cleanup,
+		emit: func(c *Context) error {
+			c.popExceptionFrame(frame)
+			return c.compileFinally(t.Final, ctx.cleanup)
+		},
+	}
+	tryCtx.compileBody(t.Try)
+	if tryCtx.Term == nil {
+		tryCtx.popExceptionFrame(frame)
+		tryCtx.NewBr(finallyBlock)
+	}
+
+	// Compile the catch part, the frame was already popped by the throw
+	catchCtx := ctx.NewContext(catchBlock)
+	catchCtx.vars[t.Catch.Name] = &Variable{
+		Name:  t.Catch.Name,
+		Type:  types.I8Ptr,
+		Value: catchCtx.thrownValue(frame),
+	}
+	var catchFrame value.Value
+	if t.Final != nil {
+		// An exception escaping the catch part still has to run finally,
+		// so guard it with another frame that rethrows afterwards
+		bodyBlock := ctx.Block.Parent.NewBlock("")
+		rethrowBlock := ctx.Block.Parent.NewBlock("")
+		catchFrame = catchCtx.pushExceptionFrame(bodyBlock, rethrowBlock)
+		catchCtx.Block = bodyBlock
+		catchCtx.cleanup = &Cleanup{
+			parent: ctx.cleanup,
+			emit: func(c *Context) error {
+				c.popExceptionFrame(catchFrame)
+				return c.compileFinally(t.Final, ctx.cleanup)
+			},
+		}
+
+		rethrowCtx := ctx.NewContext(rethrowBlock)
+		thrown := rethrowCtx.thrownValue(catchFrame)
+		if err := rethrowCtx.compileFinally(t.Final, ctx.cleanup); err != nil {
+			return err
+		}
+		if rethrowCtx.Term == nil {
+			rethrowCtx.NewCall(ctx.exceptionRuntime().throw, thrown)
+			rethrowCtx.NewUnreachable()
+		}
+	}
+	catchCtx.compileBody(t.Catch.Body)
+	if catchCtx.Term == nil {
+		if catchFrame != nil {
+			catchCtx.popExceptionFrame(catchFrame)
+		}
+		catchCtx.NewBr(finallyBlock)
+	}
+
+	// Compile the finally part for when the try or catch part completes normally
+	ctx.Block = finallyBlock
+	return ctx.compileFinally(t.Final, ctx.cleanup)
+}
+
+// compileFinally compiles the body of a finally block in place. It is compiled
+// once for every way of leaving the try and catch blocks.
+func (ctx *Context) compileFinally(body []*parser.Statement, cleanup *Cleanup) error {
+	finallyCtx := ctx.NewContext(ctx.Block)
+	finallyCtx.cleanup = cleanup
+	finallyCtx.compileBody(body)
+	ctx.Block = finallyCtx.Block
+	return nil
+}
+
+func (ctx *Context) compileThrow(e *parser.Expression) error {
+	val, err := ctx.compileExpression(e)
+	if err != nil {
+		return err
+	}
+
+	switch val.Type().(type) {
+	case *types.PointerType:
+		val = ctx.NewBitCast(val, types.I8Ptr)
+	case *types.IntType:
+		val = ctx.NewIntToPtr(val, types.I8Ptr)
+	default:
+		return posError(e.Pos, "Cannot throw a value of type %s", val.Type())
+	}
+
+	ctx.NewCall(ctx.exceptionRuntime().throw, val)
+	ctx.NewUnreachable()
+	return nil
+}
+
 func (ctx *Context) compileReturn(r *parser.Return) error {
 	if len(r.Expressions) == 1 {
-		ctx.RequestedType = ctx.Block.Parent.Sig.RetType
 		val, err := ctx.compileExpression(r.Expressions[0])
 		if err != nil {
-			return posError(r.Pos, "Error compiling return expression: %s", err.Error())
+			return err
+		}
+		if err := ctx.emitCleanups(nil); err != nil {
+			return err
 		}
-		ctx.RequestedType = nil
 		ctx.NewRet(val)
 	} else if len(r.Expressions) > 1 {
 		if _, ok := ctx.Block.Parent.Sig.RetType.(*types.StructType); !ok {
@@ -600,12 +1116,10 @@ func (ctx *Context) compileReturn(r *parser.Return) error {
 		}
 
 		var vals []constant.Constant
-		for i, expr := range r.Expressions {
-			ctx.RequestedType = ctx.Block.Parent.Sig.RetType.(*types.StructType).Fields[i]
+		for _, expr := range r.Expressions {
 			val, err := ctx.compileExpression(expr)
-			ctx.RequestedType = nil
 			if err != nil {
-				return posError(r.Pos, "Error compiling return expression: %s", err.Error())
+				return err
 			}
 
 			constVal, ok := val.(constant.Constant)
@@ -616,8 +1130,14 @@ func (ctx *Context) compileReturn(r *parser.Return) error {
 			vals = append(vals, constVal)
 		}
 
+		if err := ctx.emitCleanups(nil); err != nil {
+			return err
+		}
 		ctx.NewRet(constant.NewStruct(ctx.Block.Parent.Sig.RetType.(*types.StructType), vals...))
 	} else {
+		if err := ctx.emitCleanups(nil); err != nil {
+			return err
+		}
 		ctx.NewRet(nil)
 	}
 	return nil
@@ -0,0 +1,80 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSwitch(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// Instructions expected in the IR of f, and instructions it must not contain
+		insts   []string
+		without []string
+	}{
+		{
+			name:    "constant cases",
+			body:    "switch (x) { case 1, 2: return 10; case 3: return 30; default: return 0; }",
+			insts:   []string{"switch i64", "i64 1, label", "i64 2, label", "i64 3, label"},
+			without: []string{"icmp eq"},
+		},
+		{
+			name:    "non constant cases",
+			body:    "var y: i64 = 2; switch (x) { case y: return 1; case 4: return 4; }",
+			insts:   []string{"icmp eq i64"},
+			without: []string{"switch i64"},
+		},
+		{
+			name:    "string cases",
+			body:    `var s: *i8 = "b"; switch (s) { case "a": return 1; case "b": return 2; }`,
+			insts:   []string{"call i32 @strcmp("},
+			without: []string{"switch"},
+		},
+		{
+			name:  "break out of a case",
+			body:  "switch (x) { case 1: if (x > 0) { break; } return 1; default: break; }",
+			insts: []string{"switch i64"},
+		},
+		{
+			name:  "empty default",
+			body:  "switch (x) { case 1: return 1; default: }",
+			insts: []string{"switch i64"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := compileFiles(t, map[string]string{"main.cffc": "package main;\nextern func strcmp(a: *i8, b: *i8): i32;\nfunc f(x: i64): i64 {\n" + tt.body + "\nreturn 0;\n}\nfunc main(): i32 { return 0; }\n"})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			ir := funcIR(t, c, "main.f")
+			for _, inst := range tt.insts {
+				if !strings.Contains(ir, inst) {
+					t.Errorf("expected %q in the IR of f:\n%s", inst, ir)
+				}
+			}
+			for _, inst := range tt.without {
+				if strings.Contains(ir, inst) {
+					t.Errorf("expected no %q in the IR of f:\n%s", inst, ir)
+				}
+			}
+		})
+	}
+}
+
+func TestSwitchDuplicateCase(t *testing.T) {
+	diags := compileErrors(t, `package main;
+func main(): i32 {
+  var x: i64 = 1;
+  switch (x) { case 1: return 1; case 2, 1: return 2; }
+  return 0;
+}
+`)
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "Duplicate case value 1") {
+		t.Fatalf("expected the duplicate case to be reported, got %s", diags)
+	}
+	if diags[0].Span.Line != 4 || diags[0].Span.Column != 42 {
+		t.Errorf("expected the error at the second 1, got %s", diags[0].Span)
+	}
+}
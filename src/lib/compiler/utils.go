@@ -1,32 +1,97 @@
 package compiler
 
 import (
-	"fmt"
+	gconstant "go/constant"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/alecthomas/participle/v2/lexer"
-	"github.com/fatih/color"
+	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/types"
-	"github.com/urfave/cli/v2"
+	"github.com/llir/llvm/ir/value"
+	"github.com/vyPal/CaffeineC/lib/checker"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
 	"github.com/vyPal/CaffeineC/lib/parser"
 )
 
+// LLVM integers carry no signedness, so unsigned integer types are kept as
+// distinct *types.IntType instances of the right size. They compare Equal to
+// the signed types of the same size, but can be told apart by identity. Files
+// are compiled concurrently, so the map is guarded by unsignedTypesMu.
+var (
+	unsignedTypes   = map[uint64]*types.IntType{}
+	unsignedTypesMu sync.Mutex
+)
+
+func unsignedType(size uint64) *types.IntType {
+	unsignedTypesMu.Lock()
+	defer unsignedTypesMu.Unlock()
+	if typ, ok := unsignedTypes[size]; ok {
+		return typ
+	}
+	typ := types.NewInt(size)
+	unsignedTypes[size] = typ
+	return typ
+}
+
+func isUnsigned(t types.Type) bool {
+	intType, ok := t.(*types.IntType)
+	if !ok {
+		return false
+	}
+	unsignedTypesMu.Lock()
+	defer unsignedTypesMu.Unlock()
+	return unsignedTypes[intType.BitSize] == intType
+}
+
+// intTypeFromName parses integer type names such as i32 or u64.
+func intTypeFromName(name string) (types.Type, bool) {
+	if len(name) < 2 || (name[0] != 'i' && name[0] != 'u') {
+		return nil, false
+	}
+	size, err := strconv.ParseUint(name[1:], 10, 32)
+	if err != nil || size == 0 {
+		return nil, false
+	}
+	if name[0] == 'u' {
+		return unsignedType(size), true
+	}
+	return types.NewInt(size), true
+}
+
 func posError(pos lexer.Position, message string, args ...interface{}) error {
-	return cli.Exit(color.RedString("%s at %s:%d:%d", fmt.Sprintf(message, args...), pos.Filename, pos.Line, pos.Column), 1)
+	return diagnostics.Errorf(diagnostics.CodeCompile, diagnostics.At(pos), message, args...)
+}
+
+// undefinedError reports the use of a name that is not defined. Callers
+// suggest similar names that are defined with Suggest.
+func undefinedError(pos lexer.Position, message string, args ...interface{}) *diagnostics.Diagnostic {
+	return diagnostics.Errorf(diagnostics.CodeUndefined, diagnostics.At(pos), message, args...)
 }
 
+// CFTypeToLLType resolves the type t. Types that can not be resolved are
+// reported and replaced with i64, so compilation can go on to find further
+// errors.
 func (ctx *Context) CFTypeToLLType(t *parser.Type) types.Type {
 	pointerCount := strings.Count(t.Ptr, "*")
 	var typ types.Type
 
 	if t.Inner != nil {
 		typ = ctx.CFTypeToLLType(t.Inner)
+	} else if len(t.TypeArgs) > 0 {
+		classType, err := ctx.instantiateClass(t.Pos, t.Name, ctx.typeArgs(t.TypeArgs))
+		if err != nil {
+			ctx.report(err)
+			classType = types.I64
+		}
+		typ = classType
+	} else if param, ok := ctx.typeParams[t.Name]; ok {
+		typ = param
 	} else {
-		if strings.HasPrefix(t.Name, "i") || strings.HasPrefix(t.Name, "u") {
-			size, _ := strconv.Atoi(t.Name[1:])
-			typ = types.NewInt(uint64(size))
+		if intType, ok := intTypeFromName(t.Name); ok {
+			typ = intType
 		} else {
 			switch t.Name {
 			case "void", "":
@@ -40,6 +105,10 @@ func (ctx *Context) CFTypeToLLType(t *parser.Type) types.Type {
 			case "f128":
 				typ = types.FP128
 			default:
+				if e, ok := ctx.enums[t.Name]; ok {
+					typ = e.typ
+					break
+				}
 				for _, ty := range ctx.Module.TypeDefs {
 					if ty.Name() == t.Name {
 						typ = ty
@@ -50,7 +119,8 @@ func (ctx *Context) CFTypeToLLType(t *parser.Type) types.Type {
 		}
 
 		if typ == nil {
-			panic("Unknown type: " + t.Name)
+			ctx.report(diagnostics.Errorf(diagnostics.CodeUnknownType, diagnostics.At(t.Pos), "Unknown type: %s", t.Name).Suggest(t.Name, ctx.typeNames()))
+			typ = types.I64
 		}
 	}
 
@@ -60,14 +130,11 @@ func (ctx *Context) CFTypeToLLType(t *parser.Type) types.Type {
 	}
 
 	if t.Array != nil {
-		array, err := ctx.compileExpression(t.Array)
-		if err != nil {
-			panic(err)
-		}
-
-		arraySize, ok := array.(*constant.Int)
-		if !ok {
-			panic("array size is not a constant integer")
+		array, ok := ctx.constantValue(t.Array)
+		arraySize, isInt := array.(*constant.Int)
+		if !ok || !isInt {
+			ctx.report(posError(t.Array.Pos, "Array size is not a constant integer"))
+			return types.NewArray(0, typ)
 		}
 
 		length := uint64(arraySize.X.Int64())
@@ -78,6 +145,125 @@ func (ctx *Context) CFTypeToLLType(t *parser.Type) types.Type {
 	return typ
 }
 
+// checkedType returns the LLVM type of the type t the checker gave a value.
+// Type parameters are the type arguments the template is compiled for. It
+// reports false for types it can not map.
+func (ctx *Context) checkedType(t checker.Type) (types.Type, bool) {
+	switch t := t.(type) {
+	case *checker.Int:
+		if t.Unsigned {
+			return unsignedType(t.Bits), true
+		}
+		switch t.Bits {
+		case 1:
+			return types.I1, true
+		case 8:
+			return types.I8, true
+		case 16:
+			return types.I16, true
+		case 32:
+			return types.I32, true
+		case 64:
+			return types.I64, true
+		}
+		return types.NewInt(t.Bits), true
+	case *checker.Float:
+		switch t.Bits {
+		case 16:
+			return types.Half, true
+		case 32:
+			return types.Float, true
+		case 64:
+			return types.Double, true
+		case 128:
+			return types.FP128, true
+		}
+	case *checker.Void:
+		return types.Void, true
+	case *checker.Pointer:
+		if elem, ok := ctx.checkedType(t.Elem); ok {
+			return types.NewPointer(elem), true
+		}
+	case *checker.Array:
+		if elem, ok := ctx.checkedType(t.Elem); ok && t.Len >= 0 {
+			return types.NewArray(uint64(t.Len), elem), true
+		}
+	case *checker.Tuple:
+		fields := make([]types.Type, len(t.Types))
+		for i, typ := range t.Types {
+			field, ok := ctx.checkedType(typ)
+			if !ok {
+				return nil, false
+			}
+			fields[i] = field
+		}
+		return types.NewStruct(fields...), true
+	case *checker.TypeParam:
+		typ, ok := ctx.typeParams[t.Name]
+		return typ, ok
+	case *checker.Enum:
+		if e, ok := ctx.enums[ctx.declaredName(t.Def(), t.Name())]; ok {
+			return e.typ, true
+		}
+	case *checker.Interface:
+		return ctx.lookupType(ctx.declaredName(t.Def(), t.Name()))
+	case *checker.Class:
+		name := ctx.declaredName(t.Def(), t.Name())
+		if len(t.Args) == 0 {
+			return ctx.lookupType(name)
+		}
+		args := make([]types.Type, len(t.Args))
+		for i, arg := range t.Args {
+			typ, ok := ctx.checkedType(arg)
+			if !ok {
+				return nil, false
+			}
+			args[i] = typ
+		}
+		typ, err := ctx.instantiateClass(lexer.Position{}, name, args)
+		return typ, err == nil
+	}
+	return nil, false
+}
+
+// foldedConstant returns the value of the constant v as an LLVM constant of
+// type typ, if the checker could evaluate its initializer.
+func (ctx *Context) foldedConstant(v *parser.VariableDefinition, typ types.Type) (constant.Constant, bool) {
+	if ctx.Info == nil {
+		return nil, false
+	}
+	obj := ctx.Info.Defs[v]
+	if obj == nil || obj.Kind != checker.ConstObject || obj.Value == nil {
+		return nil, false
+	}
+
+	val := obj.Value
+	switch t := typ.(type) {
+	case *types.IntType:
+		if val.Kind() == gconstant.Bool && t.BitSize == 1 {
+			return constant.NewBool(gconstant.BoolVal(val)), true
+		}
+		val = gconstant.ToInt(val)
+		if val.Kind() != gconstant.Int {
+			return nil, false
+		}
+		if i, exact := gconstant.Int64Val(val); exact {
+			return constant.NewInt(t, i), true
+		}
+		// Unsigned values beyond the range of i64 keep their bits
+		u, exact := gconstant.Uint64Val(val)
+		return constant.NewInt(t, int64(u)), exact
+	case *types.FloatType:
+		val = gconstant.ToFloat(val)
+		if val.Kind() != gconstant.Float {
+			return nil, false
+		}
+		f, _ := gconstant.Float64Val(val)
+		return constant.NewFloat(t, f), true
+	}
+	return nil, false
+}
+
 func (ctx *Context) CFMultiTypeToLLType(typeArr []*parser.Type) types.Type {
 	if len(typeArr) == 1 {
 		return ctx.CFTypeToLLType(typeArr[0])
@@ -104,14 +290,77 @@ func isNumeric(t types.Type) bool {
 	}
 }
 
-func (ctx *Context) StringToType(name string) types.Type {
+// isStorage reports whether val points to memory holding a value of type typ,
+// as opposed to being such a value itself.
+func isStorage(val value.Value, typ types.Type) bool {
+	ptrType, ok := val.Type().(*types.PointerType)
+	return ok && ptrType.ElemType.Equal(typ)
+}
+
+// isVariableStorage reports whether val is the memory a variable lives in,
+// either on the stack or in a global.
+func isVariableStorage(val value.Value) bool {
+	switch val.(type) {
+	case *ir.InstAlloca, *ir.Global:
+		return true
+	default:
+		return false
+	}
+}
+
+func isBool(t types.Type) bool {
+	return t.Equal(types.I1)
+}
+
+func isString(t types.Type) bool {
+	ptrType, ok := t.(*types.PointerType)
+	if !ok {
+		return false
+	}
+	if arrType, ok := ptrType.ElemType.(*types.ArrayType); ok {
+		return arrType.ElemType.Equal(types.I8)
+	}
+	return ptrType.ElemType.Equal(types.I8)
+}
+
+// constantValue evaluates an expression at compile time without emitting
+// any instructions. Only literals and constants are supported.
+func (ctx *Context) constantValue(e *parser.Expression) (constant.Constant, bool) {
+	f := e.SingleFactor()
+	if f == nil || f.Unpack {
+		return nil, false
+	}
+
+	if f.Value != nil && (f.Value.Int != nil || f.Value.Bool != nil) {
+		val, err := ctx.compileValue(f.Value)
+		if err != nil {
+			return nil, false
+		}
+		return val.(constant.Constant), true
+	} else if name, variant, ok := ctx.enumVariant(f.Identifier); ok {
+		c, err := ctx.enumConstant(f.Identifier.Pos, name, variant)
+		return c, err == nil
+	} else if i := ctx.resolveModule(f.Identifier); i != nil && i.Sub == nil && i.GEP == nil && i.Ref == "" && i.Deref == "" {
+		v := ctx.lookupVariable(i.Name)
+		if v == nil {
+			return nil, false
+		}
+		c, ok := v.Value.(constant.Constant)
+		return c, ok
+	}
+
+	return nil, false
+}
+
+// StringToType resolves a type written as a string, like "*i8". Names that
+// are not types are reported as an error.
+func (ctx *Context) StringToType(name string) (types.Type, error) {
 	pointerCount := strings.Count(name, "*")
 	name = strings.TrimLeft(name, "*")
 
 	var typ types.Type
-	if strings.HasPrefix(name, "i") || strings.HasPrefix(name, "u") {
-		size, _ := strconv.Atoi(name[1:])
-		typ = types.NewInt(uint64(size))
+	if intType, ok := intTypeFromName(name); ok {
+		typ = intType
 	} else {
 		switch name {
 		case "void", "":
@@ -135,7 +384,7 @@ func (ctx *Context) StringToType(name string) types.Type {
 	}
 
 	if typ == nil {
-		panic("Unknown type: " + name)
+		return nil, diagnostics.Errorf(diagnostics.CodeUnknownType, diagnostics.Span{}, "Unknown type: %s", name).Suggest(name, ctx.typeNames())
 	}
 
 	// If the type is a pointer, wrap it in the appropriate number of pointer types
@@ -143,14 +392,23 @@ func (ctx *Context) StringToType(name string) types.Type {
 		typ = types.NewPointer(typ)
 	}
 
-	return typ
+	return typ, nil
 }
 
+// TypeToString returns the name of typ in CaffeineC source code, for use in
+// messages. Types that can not be written in CaffeineC, like function types,
+// are named the way LLVM names them.
 func (ctx *Context) TypeToString(typ types.Type) string {
 	switch typ := typ.(type) {
 	case *types.VoidType:
 		return "void"
 	case *types.IntType:
+		if name, ok := ctx.enumOf(typ); ok {
+			return name
+		}
+		if isUnsigned(typ) {
+			return "u" + strconv.Itoa(int(typ.BitSize))
+		}
 		return "i" + strconv.Itoa(int(typ.BitSize))
 	case *types.FloatType:
 		switch typ.Kind {
@@ -162,14 +420,15 @@ func (ctx *Context) TypeToString(typ types.Type) string {
 			return "f64"
 		case types.FloatKindFP128:
 			return "f128"
-		default:
-			panic("Unknown float type")
 		}
 	case *types.PointerType:
 		return "*" + ctx.TypeToString(typ.ElemType)
+	case *types.ArrayType:
+		return "[" + strconv.FormatUint(typ.Len, 10) + "]" + ctx.TypeToString(typ.ElemType)
 	case *types.StructType:
-		return typ.Name()
-	default:
-		panic("Unknown type")
+		if typ.Name() != "" {
+			return typ.Name()
+		}
 	}
+	return typ.LLString()
 }
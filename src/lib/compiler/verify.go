@@ -0,0 +1,502 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+)
+
+// positionMark is where the code generated for a statement starts: the block
+// it is compiled into, how many instructions that block already has and how
+// many blocks its function has.
+type positionMark struct {
+	block  *ir.Block
+	insts  int
+	blocks int
+}
+
+// codeBlock returns the block code is generated in, which is the module
+// initializer for statements at file scope.
+func (ctx *Context) codeBlock() *ir.Block {
+	if ctx.Block != nil {
+		return ctx.Block
+	}
+	if ctx.Compiler.init != nil {
+		return ctx.Compiler.init.Block
+	}
+	return nil
+}
+
+// markPositions returns where the code of the next statement starts.
+func (ctx *Context) markPositions() positionMark {
+	b := ctx.codeBlock()
+	if b == nil {
+		return positionMark{}
+	}
+	return positionMark{block: b, insts: len(b.Insts), blocks: len(b.Parent.Blocks)}
+}
+
+// recordPositions maps the instructions generated since mark to pos, the
+// position of the statement they were compiled from. Nested statements record
+// their position before the statement containing them, so instructions
+// belong to the innermost statement generating them. Only the code added
+// since mark is visited, the statements of a function are not rescanned.
+func (ctx *Context) recordPositions(mark positionMark, pos lexer.Position) {
+	if mark.block == nil {
+		// The module initializer was created by the statement
+		b := ctx.codeBlock()
+		if b == nil {
+			return
+		}
+		mark.block = b.Parent.Blocks[0]
+	}
+
+	record := func(b *ir.Block, from int) {
+		for _, inst := range b.Insts[from:] {
+			if _, ok := ctx.positions[inst]; !ok {
+				ctx.positions[inst] = pos
+			}
+		}
+		if b.Term != nil {
+			if _, ok := ctx.positions[b.Term]; !ok {
+				ctx.positions[b.Term] = pos
+			}
+		}
+	}
+	record(mark.block, mark.insts)
+	for _, b := range mark.block.Parent.Blocks[mark.blocks:] {
+		record(b, 0)
+	}
+}
+
+// Verify checks that the module is valid LLVM IR: every block ends in a
+// terminator, operands have the types their instructions expect, values are
+// defined before they are used and calls match the signature of the called
+// function. Problems are compiler bugs, they are reported at the statement
+// the broken instruction was generated for.
+func (c *Compiler) Verify() error {
+	var diags diagnostics.List
+	for _, fn := range c.Module.Funcs {
+		if len(fn.Blocks) == 0 {
+			continue
+		}
+		v := &verifier{Compiler: c, fn: fn}
+		v.verify()
+		diags = append(diags, v.diags...)
+	}
+	return diags.Err()
+}
+
+// verifier checks the IR of a single function.
+type verifier struct {
+	*Compiler
+	fn    *ir.Func
+	diags diagnostics.List
+
+	// Index of every block, and of every instruction in its block
+	blocks map[*ir.Block]int
+	defs   map[value.Value]*ir.Block
+	order  map[value.Value]int
+	// Immediate dominator of every block reachable from the entry block, by
+	// index, -1 for unreachable blocks
+	idom []int
+}
+
+func (v *verifier) verify() {
+	defer func() {
+		if r := recover(); r != nil {
+			v.errorf(nil, "%v", r)
+		}
+	}()
+
+	v.blocks = make(map[*ir.Block]int)
+	v.defs = make(map[value.Value]*ir.Block)
+	v.order = make(map[value.Value]int)
+	for i, b := range v.fn.Blocks {
+		v.blocks[b] = i
+		for j, inst := range b.Insts {
+			if val, ok := inst.(value.Value); ok {
+				v.defs[val] = b
+				v.order[val] = j
+			}
+		}
+		if val, ok := b.Term.(value.Value); ok {
+			v.defs[val] = b
+			v.order[val] = len(b.Insts)
+		}
+	}
+
+	// Dominance can only be computed once every block is terminated
+	terminated := true
+	for _, b := range v.fn.Blocks {
+		if b.Term == nil {
+			v.errorf(v.lastUser(b), "block %s is not terminated", blockName(b))
+			terminated = false
+			continue
+		}
+		for _, succ := range b.Term.Succs() {
+			if succ == nil {
+				v.errorf(b.Term, "branch has no target")
+				terminated = false
+			} else if _, ok := v.blocks[succ]; !ok {
+				v.errorf(b.Term, "branch to block %s of another function", blockName(succ))
+				terminated = false
+			}
+		}
+	}
+	if !terminated {
+		return
+	}
+	v.dominators()
+
+	for i, b := range v.fn.Blocks {
+		if v.idom[i] < 0 {
+			// Unreachable blocks are never run
+			continue
+		}
+		for j, inst := range b.Insts {
+			v.operands(inst, b, j)
+			v.instruction(inst)
+		}
+		v.operands(b.Term, b, len(b.Insts))
+		v.terminator(b.Term)
+	}
+}
+
+func (v *verifier) errorf(at value.User, format string, args ...interface{}) {
+	pos, ok := v.positions[at]
+	if !ok {
+		pos = v.functionPosition()
+	}
+	d := diagnostics.Errorf(diagnostics.CodeInternal, diagnostics.At(pos), "Invalid IR in function %s: %s", v.fn.Name(), fmt.Sprintf(format, args...))
+	v.diags = append(v.diags, d.WithHelp("this is a bug in the compiler, the code it generated for this statement is not valid"))
+}
+
+// functionPosition returns the position of the first statement of the
+// function, for problems that can not be tied to an instruction.
+func (v *verifier) functionPosition() lexer.Position {
+	for _, b := range v.fn.Blocks {
+		for _, inst := range b.Insts {
+			if pos, ok := v.positions[inst]; ok {
+				return pos
+			}
+		}
+		if pos, ok := v.positions[b.Term]; ok {
+			return pos
+		}
+	}
+	return lexer.Position{}
+}
+
+// lastUser returns the last instruction of b, to report problems with the
+// end of the block at.
+func (v *verifier) lastUser(b *ir.Block) value.User {
+	if len(b.Insts) == 0 {
+		return nil
+	}
+	return b.Insts[len(b.Insts)-1]
+}
+
+func blockName(b *ir.Block) string {
+	return b.Ident()
+}
+
+// dominators computes the immediate dominator of every block, with the
+// algorithm of Cooper, Harvey and Kennedy.
+func (v *verifier) dominators() {
+	n := len(v.fn.Blocks)
+	preds := make([][]int, n)
+	var rpo []int
+	visited := make([]bool, n)
+	var visit func(i int)
+	visit = func(i int) {
+		visited[i] = true
+		for _, succ := range v.fn.Blocks[i].Term.Succs() {
+			j := v.blocks[succ]
+			preds[j] = append(preds[j], i)
+			if !visited[j] {
+				visit(j)
+			}
+		}
+		rpo = append(rpo, i)
+	}
+	visit(0)
+	for i, j := 0, len(rpo)-1; i < j; i, j = i+1, j-1 {
+		rpo[i], rpo[j] = rpo[j], rpo[i]
+	}
+	index := make([]int, n)
+	for i, b := range rpo {
+		index[b] = i
+	}
+
+	v.idom = make([]int, n)
+	for i := range v.idom {
+		v.idom[i] = -1
+	}
+	v.idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for index[a] > index[b] {
+				a = v.idom[a]
+			}
+			for index[b] > index[a] {
+				b = v.idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range rpo[1:] {
+			idom := -1
+			for _, p := range preds[b] {
+				if v.idom[p] < 0 {
+					continue
+				}
+				if idom < 0 {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if idom != v.idom[b] {
+				v.idom[b] = idom
+				changed = true
+			}
+		}
+	}
+}
+
+// dominates reports whether block a dominates block b.
+func (v *verifier) dominates(a, b int) bool {
+	for {
+		if a == b {
+			return true
+		}
+		if b == 0 || v.idom[b] < 0 {
+			return false
+		}
+		b = v.idom[b]
+	}
+}
+
+// operands checks that the operands of user, the index-th instruction of
+// block b, are defined before they are used.
+func (v *verifier) operands(user value.User, b *ir.Block, index int) {
+	if phi, ok := user.(*ir.InstPhi); ok {
+		for _, inc := range phi.Incs {
+			pred, ok := inc.Pred.(*ir.Block)
+			if !ok {
+				v.errorf(user, "incoming value of phi does not come from a block")
+				continue
+			}
+			// Incoming values are used at the end of the block they come from
+			v.use(user, inc.X, pred, len(pred.Insts))
+		}
+		return
+	}
+	for _, op := range user.Operands() {
+		if op == nil || *op == nil {
+			continue
+		}
+		v.use(user, *op, b, index)
+	}
+}
+
+// use checks that val is defined at the index-th instruction of block b.
+func (v *verifier) use(user value.User, val value.Value, b *ir.Block, index int) {
+	switch val := val.(type) {
+	case *ir.Block:
+		return
+	case *ir.Param:
+		for _, p := range v.fn.Params {
+			if p == val {
+				return
+			}
+		}
+		v.errorf(user, "parameter %s of another function is used", val.Ident())
+		return
+	case ir.Instruction, ir.Terminator:
+		def, ok := v.defs[val]
+		if !ok {
+			v.errorf(user, "value %s is not defined in this function", val.Ident())
+			return
+		}
+		if def == b {
+			if v.order[val] >= index {
+				v.errorf(user, "value %s is used before it is defined", val.Ident())
+			}
+			return
+		}
+		if !v.dominates(v.blocks[def], v.blocks[b]) {
+			v.errorf(user, "value %s does not dominate all of its uses", val.Ident())
+		}
+	}
+}
+
+// instruction checks the types of the operands of inst.
+func (v *verifier) instruction(inst ir.Instruction) {
+	switch inst := inst.(type) {
+	case *ir.InstAdd:
+		v.intOperands(inst, "add", inst.X, inst.Y)
+	case *ir.InstSub:
+		v.intOperands(inst, "sub", inst.X, inst.Y)
+	case *ir.InstMul:
+		v.intOperands(inst, "mul", inst.X, inst.Y)
+	case *ir.InstSDiv:
+		v.intOperands(inst, "sdiv", inst.X, inst.Y)
+	case *ir.InstUDiv:
+		v.intOperands(inst, "udiv", inst.X, inst.Y)
+	case *ir.InstSRem:
+		v.intOperands(inst, "srem", inst.X, inst.Y)
+	case *ir.InstURem:
+		v.intOperands(inst, "urem", inst.X, inst.Y)
+	case *ir.InstShl:
+		v.intOperands(inst, "shl", inst.X, inst.Y)
+	case *ir.InstLShr:
+		v.intOperands(inst, "lshr", inst.X, inst.Y)
+	case *ir.InstAShr:
+		v.intOperands(inst, "ashr", inst.X, inst.Y)
+	case *ir.InstAnd:
+		v.intOperands(inst, "and", inst.X, inst.Y)
+	case *ir.InstOr:
+		v.intOperands(inst, "or", inst.X, inst.Y)
+	case *ir.InstXor:
+		v.intOperands(inst, "xor", inst.X, inst.Y)
+	case *ir.InstFAdd:
+		v.floatOperands(inst, "fadd", inst.X, inst.Y)
+	case *ir.InstFSub:
+		v.floatOperands(inst, "fsub", inst.X, inst.Y)
+	case *ir.InstFMul:
+		v.floatOperands(inst, "fmul", inst.X, inst.Y)
+	case *ir.InstFDiv:
+		v.floatOperands(inst, "fdiv", inst.X, inst.Y)
+	case *ir.InstFRem:
+		v.floatOperands(inst, "frem", inst.X, inst.Y)
+	case *ir.InstICmp:
+		if !v.sameTypes(inst, "icmp", inst.X, inst.Y) {
+			return
+		}
+		switch inst.X.Type().(type) {
+		case *types.IntType, *types.PointerType:
+		default:
+			v.errorf(inst, "icmp operands must be integers or pointers, got %s", inst.X.Type())
+		}
+	case *ir.InstFCmp:
+		v.floatOperands(inst, "fcmp", inst.X, inst.Y)
+	case *ir.InstLoad:
+		ptr, ok := inst.Src.Type().(*types.PointerType)
+		if !ok {
+			v.errorf(inst, "load from non-pointer operand of type %s", inst.Src.Type())
+		} else if !ptr.ElemType.Equal(inst.ElemType) {
+			v.errorf(inst, "load of %s from a pointer of type %s", inst.ElemType, ptr)
+		}
+	case *ir.InstStore:
+		ptr, ok := inst.Dst.Type().(*types.PointerType)
+		if !ok {
+			v.errorf(inst, "store to non-pointer operand of type %s", inst.Dst.Type())
+		} else if !ptr.ElemType.Equal(inst.Src.Type()) {
+			v.errorf(inst, "store of %s to a pointer of type %s", inst.Src.Type(), ptr)
+		}
+	case *ir.InstSelect:
+		if !inst.Cond.Type().Equal(types.I1) {
+			v.errorf(inst, "select condition must be i1, got %s", inst.Cond.Type())
+		}
+		v.sameTypes(inst, "select", inst.ValueTrue, inst.ValueFalse)
+	case *ir.InstPhi:
+		for _, inc := range inst.Incs {
+			if !inc.X.Type().Equal(inst.Typ) {
+				v.errorf(inst, "incoming value of type %s to phi of type %s", inc.X.Type(), inst.Typ)
+			}
+		}
+	case *ir.InstCall:
+		v.call(inst, inst.Callee, inst.Args)
+	}
+}
+
+// terminator checks the types of the operands of term.
+func (v *verifier) terminator(term ir.Terminator) {
+	switch term := term.(type) {
+	case *ir.TermRet:
+		result := v.fn.Sig.RetType
+		switch {
+		case term.X == nil && !result.Equal(types.Void):
+			v.errorf(term, "ret without a value in a function returning %s", result)
+		case term.X != nil && result.Equal(types.Void):
+			v.errorf(term, "ret of %s in a function returning void", term.X.Type())
+		case term.X != nil && !term.X.Type().Equal(result):
+			v.errorf(term, "ret of %s in a function returning %s", term.X.Type(), result)
+		}
+	case *ir.TermCondBr:
+		if !term.Cond.Type().Equal(types.I1) {
+			v.errorf(term, "branch condition must be i1, got %s", term.Cond.Type())
+		}
+	case *ir.TermSwitch:
+		if _, ok := term.X.Type().(*types.IntType); !ok {
+			v.errorf(term, "switch on non-integer operand of type %s", term.X.Type())
+			return
+		}
+		for _, c := range term.Cases {
+			if !c.X.Type().Equal(term.X.Type()) {
+				v.errorf(term, "switch case of type %s for a value of type %s", c.X.Type(), term.X.Type())
+			}
+		}
+	}
+}
+
+// sameTypes checks that the operands of the instruction op have the same
+// type.
+func (v *verifier) sameTypes(inst value.User, op string, x, y value.Value) bool {
+	if !x.Type().Equal(y.Type()) {
+		v.errorf(inst, "%s operands must be the same type (%s != %s)", op, x.Type(), y.Type())
+		return false
+	}
+	return true
+}
+
+func (v *verifier) intOperands(inst value.User, op string, x, y value.Value) {
+	if !v.sameTypes(inst, op, x, y) {
+		return
+	}
+	if _, ok := x.Type().(*types.IntType); !ok {
+		v.errorf(inst, "%s operands must be integers, got %s", op, x.Type())
+	}
+}
+
+func (v *verifier) floatOperands(inst value.User, op string, x, y value.Value) {
+	if !v.sameTypes(inst, op, x, y) {
+		return
+	}
+	if _, ok := x.Type().(*types.FloatType); !ok {
+		v.errorf(inst, "%s operands must be floating-point values, got %s", op, x.Type())
+	}
+}
+
+// call checks that the arguments of a call match the signature of callee.
+func (v *verifier) call(inst value.User, callee value.Value, args []value.Value) {
+	ptr, ok := callee.Type().(*types.PointerType)
+	if !ok {
+		v.errorf(inst, "call of non-function operand of type %s", callee.Type())
+		return
+	}
+	sig, ok := ptr.ElemType.(*types.FuncType)
+	if !ok {
+		v.errorf(inst, "call of non-function operand of type %s", callee.Type())
+		return
+	}
+
+	name := callee.Ident()
+	if len(args) < len(sig.Params) || (!sig.Variadic && len(args) > len(sig.Params)) {
+		v.errorf(inst, "call of %s with %d arguments, it takes %d", name, len(args), len(sig.Params))
+		return
+	}
+	for i, param := range sig.Params {
+		if !args[i].Type().Equal(param) {
+			v.errorf(inst, "argument %d of call to %s is of type %s, expected %s", i+1, name, args[i].Type(), param)
+		}
+	}
+}
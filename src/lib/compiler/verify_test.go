@@ -0,0 +1,178 @@
+package compiler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		// build adds a function to the module, whose problems are expected
+		build   func(m *ir.Module)
+		message string
+	}{
+		{
+			name: "valid",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.I64, ir.NewParam("x", types.I64))
+				entry := fn.NewBlock("")
+				entry.NewRet(entry.NewAdd(fn.Params[0], constant.NewInt(types.I64, 1)))
+			},
+		},
+		{
+			name: "unterminated block",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.Void)
+				fn.NewBlock("")
+			},
+			message: "block %0 is not terminated",
+		},
+		{
+			name: "use before definition",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.I64)
+				entry := fn.NewBlock("")
+				later := ir.NewAdd(constant.NewInt(types.I64, 1), constant.NewInt(types.I64, 2))
+				entry.NewAdd(later, constant.NewInt(types.I64, 3))
+				entry.Insts = append(entry.Insts, later)
+				entry.NewRet(later)
+			},
+			message: "is used before it is defined",
+		},
+		{
+			name: "definition not dominating its use",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.I64, ir.NewParam("c", types.I1))
+				entry, then, done := fn.NewBlock(""), fn.NewBlock(""), fn.NewBlock("")
+				entry.NewCondBr(fn.Params[0], then, done)
+				x := then.NewAdd(constant.NewInt(types.I64, 1), constant.NewInt(types.I64, 2))
+				then.NewBr(done)
+				done.NewRet(x)
+			},
+			message: "does not dominate all of its uses",
+		},
+		{
+			name: "store of the wrong type",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.Void)
+				entry := fn.NewBlock("")
+				// llir checks the types of stores it creates
+				store := entry.NewStore(constant.NewInt(types.I64, 1), entry.NewAlloca(types.I64))
+				store.Src = constant.NewInt(types.I32, 1)
+				entry.NewRet(nil)
+			},
+			message: "store of i32 to a pointer of type i64*",
+		},
+		{
+			name: "operands of different types",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.I64)
+				entry := fn.NewBlock("")
+				entry.NewRet(entry.NewAdd(constant.NewInt(types.I64, 1), constant.NewInt(types.I32, 2)))
+			},
+			message: "operands must be the same type (i64 != i32)",
+		},
+		{
+			name: "return of the wrong type",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.I32)
+				fn.NewBlock("").NewRet(constant.NewInt(types.I64, 0))
+			},
+			message: "ret of i64 in a function returning i32",
+		},
+		{
+			name: "branch condition",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.Void)
+				entry, done := fn.NewBlock(""), fn.NewBlock("")
+				entry.NewCondBr(constant.NewInt(types.I8, 1), done, done)
+				done.NewRet(nil)
+			},
+			message: "branch condition must be i1, got i8",
+		},
+		{
+			name: "unreachable blocks are not checked",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.Void)
+				fn.NewBlock("").NewRet(nil)
+				fn.NewBlock("").NewRet(constant.NewInt(types.I64, 0))
+			},
+		},
+		{
+			name: "declarations are not checked",
+			build: func(m *ir.Module) {
+				fn := m.NewFunc("f", types.Void)
+				fn.Linkage = enum.LinkageExternal
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCompiler()
+			tt.build(c.Module)
+			err := c.Verify()
+			if tt.message == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			var diags diagnostics.List
+			if !errors.As(err, &diags) || len(diags) != 1 {
+				t.Fatalf("expected a single error, got %v", err)
+			}
+			if !strings.Contains(diags[0].Message, tt.message) {
+				t.Errorf("expected an error containing %q, got %q", tt.message, diags[0].Message)
+			}
+		})
+	}
+}
+
+// Problems are reported at the statement the broken instruction was
+// generated for, even when it is nested in other statements.
+func TestVerifyPositions(t *testing.T) {
+	c, err := compileFiles(t, map[string]string{"main.cffc": `package main;
+func main(): i32 {
+  var x: i64 = 1;
+  if (x > 0) {
+    x = x + 1;
+    return 1;
+  }
+  return 0;
+}`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var main *ir.Func
+	for _, fn := range c.Module.Funcs {
+		if fn.Name() == "main" {
+			main = fn
+		}
+	}
+	// Break the return nested in the if, and the last one
+	for _, b := range main.Blocks {
+		if ret, ok := b.Term.(*ir.TermRet); ok {
+			ret.X = constant.NewInt(types.I64, 0)
+		}
+	}
+
+	var diags diagnostics.List
+	if !errors.As(c.Verify(), &diags) || len(diags) != 2 {
+		t.Fatalf("expected 2 errors, got %v", diags)
+	}
+	diags.Sort()
+	for i, line := range []int{6, 8} {
+		if diags[i].Span.Line != line {
+			t.Errorf("error %d is at line %d, expected %d", i+1, diags[i].Span.Line, line)
+		}
+	}
+}
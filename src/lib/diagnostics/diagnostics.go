@@ -0,0 +1,192 @@
+// Package diagnostics describes the errors and warnings reported about
+// CaffeineC source code. Diagnostics are collected while a file is parsed
+// and compiled, so every problem in it can be reported at once.
+package diagnostics
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Info
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Info:
+		return "info"
+	default:
+		return "error"
+	}
+}
+
+// Codes identify the kind of a diagnostic independently of its message.
+const (
+	CodeInternal    = "E0000"
+	CodeSyntax      = "E0001"
+	CodeUnknownType = "E0002"
+	CodeUndefined   = "E0003"
+	CodeImport      = "E0004"
+	CodeType        = "E0005"
+	CodeCompile     = "E0100"
+)
+
+// Span is a range of source code. Lines and columns start at 1, the end is
+// exclusive. A span ending where it starts marks a single position.
+type Span struct {
+	Filename  string
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+}
+
+// At returns the span marking pos.
+func At(pos lexer.Position) Span {
+	return Span{Filename: pos.Filename, Line: pos.Line, Column: pos.Column, EndLine: pos.Line, EndColumn: pos.Column}
+}
+
+// IsZero reports whether the span points nowhere, like for errors that are
+// not caused by a specific piece of code.
+func (s Span) IsZero() bool {
+	return s.Filename == "" && s.Line == 0
+}
+
+func (s Span) String() string {
+	if s.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d:%d", s.Filename, s.Line, s.Column)
+}
+
+// A Note adds information to a diagnostic, optionally about another span.
+type Note struct {
+	Span    Span
+	Message string
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Span     Span
+	Notes    []Note
+	// Help suggests how to fix the problem
+	Help []string
+}
+
+// Errorf returns an error diagnostic about span.
+func Errorf(code string, span Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Error, Code: code, Message: fmt.Sprintf(format, args...), Span: span}
+}
+
+// WithNote adds a note about span to d.
+func (d *Diagnostic) WithNote(span Span, format string, args ...interface{}) *Diagnostic {
+	d.Notes = append(d.Notes, Note{Span: span, Message: fmt.Sprintf(format, args...)})
+	return d
+}
+
+// WithHelp adds a suggestion on how to fix the problem to d.
+func (d *Diagnostic) WithHelp(format string, args ...interface{}) *Diagnostic {
+	d.Help = append(d.Help, fmt.Sprintf(format, args...))
+	return d
+}
+
+// Suggest adds a "did you mean" help to d naming the candidate closest to
+// name, if any of them is close enough to be a likely typo.
+func (d *Diagnostic) Suggest(name string, candidates []string) *Diagnostic {
+	if s, ok := Closest(name, candidates); ok {
+		d.WithHelp("did you mean `%s`?", s)
+	}
+	return d
+}
+
+func (d *Diagnostic) Error() string {
+	var b strings.Builder
+	if !d.Span.IsZero() {
+		b.WriteString(d.Span.String() + ": ")
+	}
+	fmt.Fprintf(&b, "%s[%s]: %s", d.Severity, d.Code, d.Message)
+	for _, note := range d.Notes {
+		b.WriteString("\n  note: ")
+		if !note.Span.IsZero() {
+			b.WriteString(note.Span.String() + ": ")
+		}
+		b.WriteString(note.Message)
+	}
+	for _, help := range d.Help {
+		b.WriteString("\n  help: " + help)
+	}
+	return b.String()
+}
+
+// List collects the diagnostics of one or more files.
+type List []*Diagnostic
+
+// Report adds the diagnostics carried by err to the list. Errors that are not
+// diagnostics are added as errors without a position.
+func (l *List) Report(err error) {
+	if err == nil {
+		return
+	}
+	var list List
+	var d *Diagnostic
+	if errors.As(err, &list) {
+		*l = append(*l, list...)
+	} else if errors.As(err, &d) {
+		*l = append(*l, d)
+	} else {
+		*l = append(*l, &Diagnostic{Severity: Error, Code: CodeInternal, Message: err.Error()})
+	}
+}
+
+// HasErrors reports whether any of the diagnostics is an error.
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the list as an error if it holds any errors, or nil otherwise.
+func (l List) Err() error {
+	if !l.HasErrors() {
+		return nil
+	}
+	return l
+}
+
+func (l List) Error() string {
+	messages := make([]string, len(l))
+	for i, d := range l {
+		messages[i] = d.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Sort orders the diagnostics by file and position.
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i].Span, l[j].Span
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
@@ -0,0 +1,272 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Formats diagnostics can be written in.
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+)
+
+// ValidFormat reports whether format is one of the supported formats.
+func ValidFormat(format string) bool {
+	return format == FormatText || format == FormatJSON || format == FormatSARIF
+}
+
+// WriteFormat writes the diagnostics to w in format. The structured formats
+// are written even without diagnostics, so tools can always parse the output.
+// Files in SARIF logs are given relative to root, the root of the project.
+func (l List) WriteFormat(w io.Writer, format string, root string) error {
+	switch format {
+	case FormatText, "":
+		l.Write(w)
+		return nil
+	case FormatJSON:
+		return l.WriteJSON(w)
+	case FormatSARIF:
+		return l.WriteSARIF(w, root)
+	default:
+		return fmt.Errorf("unknown diagnostics format %q", format)
+	}
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type jsonRange struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonNote struct {
+	File    string     `json:"file,omitempty"`
+	Range   *jsonRange `json:"range,omitempty"`
+	Message string     `json:"message"`
+}
+
+type jsonDiagnostic struct {
+	File     string     `json:"file,omitempty"`
+	Range    *jsonRange `json:"range,omitempty"`
+	Severity string     `json:"severity"`
+	Code     string     `json:"code"`
+	Message  string     `json:"message"`
+	Notes    []jsonNote `json:"notes,omitempty"`
+	Help     []string   `json:"help,omitempty"`
+}
+
+func newJSONRange(span Span) *jsonRange {
+	if span.IsZero() {
+		return nil
+	}
+	return &jsonRange{
+		Start: jsonPosition{Line: span.Line, Column: span.Column},
+		End:   jsonPosition{Line: span.EndLine, Column: span.EndColumn},
+	}
+}
+
+// WriteJSON writes the diagnostics to w as a JSON array.
+func (l List) WriteJSON(w io.Writer) error {
+	var sources Sources
+	out := make([]jsonDiagnostic, 0, len(l))
+	for _, d := range l {
+		span := sources.Range(d.Span)
+		jd := jsonDiagnostic{
+			File:     span.Filename,
+			Range:    newJSONRange(span),
+			Severity: d.Severity.String(),
+			Code:     d.Code,
+			Message:  d.Message,
+			Help:     d.Help,
+		}
+		for _, note := range d.Notes {
+			span := sources.Range(note.Span)
+			jd.Notes = append(jd.Notes, jsonNote{File: span.Filename, Range: newJSONRange(span), Message: note.Message})
+		}
+		out = append(out, jd)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// The subset of SARIF 2.1.0 needed to report diagnostics, see
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations,omitempty"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+// sarifRoot is the base files of the project are given relative to.
+const sarifRoot = "%SRCROOT%"
+
+// fileURI returns the file URI of the absolute path.
+func fileURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// Windows paths start with a drive letter
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// newSARIFArtifactLocation returns the location of filename, relative to
+// root if the file is in the project.
+func newSARIFArtifactLocation(filename string, root string) sarifArtifactLocation {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return sarifArtifactLocation{URI: filepath.ToSlash(filename)}
+	}
+	if root != "" {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return sarifArtifactLocation{URI: (&url.URL{Path: filepath.ToSlash(rel)}).String(), URIBaseID: sarifRoot}
+		}
+	}
+	return sarifArtifactLocation{URI: fileURI(path)}
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+func newSARIFLocation(span Span, root string) sarifLocation {
+	return sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: newSARIFArtifactLocation(span.Filename, root),
+			Region: sarifRegion{
+				StartLine:   span.Line,
+				StartColumn: span.Column,
+				EndLine:     span.EndLine,
+				EndColumn:   span.EndColumn,
+			},
+		},
+	}
+}
+
+func sarifLevel(s Severity) string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Info:
+		return "note"
+	default:
+		return "error"
+	}
+}
+
+// WriteSARIF writes the diagnostics to w as a SARIF log, the format code
+// scanning services accept. Files in root are given relative to it, so the
+// log does not depend on where the project is checked out.
+func (l List) WriteSARIF(w io.Writer, root string) error {
+	var sources Sources
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "CaffeineC",
+			InformationURI: "https://github.com/vyPal/CaffeineC",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	if root != "" {
+		if abs, err := filepath.Abs(root); err == nil {
+			root = abs
+			run.OriginalURIBaseIDs = map[string]sarifArtifactLocation{sarifRoot: {URI: strings.TrimSuffix(fileURI(root), "/") + "/"}}
+		}
+	}
+	rules := make(map[string]bool)
+	for _, d := range l {
+		if !rules[d.Code] {
+			rules[d.Code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Code})
+		}
+
+		message := d.Message
+		for _, help := range d.Help {
+			message += "\nhelp: " + help
+		}
+		result := sarifResult{RuleID: d.Code, Level: sarifLevel(d.Severity), Message: sarifMessage{Text: message}}
+		if !d.Span.IsZero() {
+			result.Locations = append(result.Locations, newSARIFLocation(sources.Range(d.Span), root))
+		}
+		for _, note := range d.Notes {
+			if note.Span.IsZero() {
+				result.Message.Text += "\nnote: " + note.Message
+				continue
+			}
+			location := newSARIFLocation(sources.Range(note.Span), root)
+			location.Message = &sarifMessage{Text: note.Message}
+			result.RelatedLocations = append(result.RelatedLocations, location)
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
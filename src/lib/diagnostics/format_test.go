@@ -0,0 +1,94 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidFormat(t *testing.T) {
+	for format, valid := range map[string]bool{"text": true, "json": true, "sarif": true, "xml": false, "": false} {
+		if ValidFormat(format) != valid {
+			t.Errorf("ValidFormat(%q) = %t, expected %t", format, !valid, valid)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	l := List{
+		Errorf(CodeType, Span{Filename: "main.cffc", Line: 3, Column: 5, EndLine: 3, EndColumn: 9}, "Cannot use a value").
+			WithNote(Span{}, "a note").
+			WithHelp("a help"),
+		Errorf(CodeInternal, Span{}, "Internal compiler error"),
+	}
+	var b strings.Builder
+	if err := l.WriteFormat(&b, FormatJSON, ""); err != nil {
+		t.Fatal(err)
+	}
+	var out []jsonDiagnostic
+	if err := json.Unmarshal([]byte(b.String()), &out); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, b.String())
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %d", len(out))
+	}
+	first := out[0]
+	if first.File != "main.cffc" || first.Range == nil || first.Range.Start != (jsonPosition{3, 5}) || first.Range.End != (jsonPosition{3, 9}) {
+		t.Errorf("wrong location %q %+v", first.File, first.Range)
+	}
+	if first.Severity != "error" || first.Code != CodeType || len(first.Notes) != 1 || len(first.Help) != 1 {
+		t.Errorf("wrong diagnostic %+v", first)
+	}
+	if out[1].File != "" || out[1].Range != nil {
+		t.Errorf("a diagnostic without a span has a location: %+v", out[1])
+	}
+
+	// Tools always get an array
+	b.Reset()
+	List{}.WriteFormat(&b, FormatJSON, "")
+	if strings.TrimSpace(b.String()) != "[]" {
+		t.Errorf("expected an empty array, got %s", b.String())
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		name      string
+		filename  string
+		uri       string
+		uriBaseID string
+	}{
+		{"file in the project", filepath.Join(root, "main.cffc"), "main.cffc", "%SRCROOT%"},
+		{"nested file", filepath.Join(root, "lib", "my lib.cffc"), "lib/my%20lib.cffc", "%SRCROOT%"},
+		{"file outside of the project", filepath.Join(filepath.Dir(root), "other.cffc"), "file://" + filepath.ToSlash(filepath.Join(filepath.Dir(root), "other.cffc")), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := List{Errorf(CodeUndefined, Span{Filename: tt.filename, Line: 1, Column: 2, EndLine: 1, EndColumn: 4}, "Variable x not found")}
+			var b strings.Builder
+			if err := l.WriteFormat(&b, FormatSARIF, root); err != nil {
+				t.Fatal(err)
+			}
+			var log sarifLog
+			if err := json.Unmarshal([]byte(b.String()), &log); err != nil {
+				t.Fatalf("invalid JSON: %s\n%s", err, b.String())
+			}
+			run := log.Runs[0]
+			if base := run.OriginalURIBaseIDs["%SRCROOT%"].URI; base != "file://"+filepath.ToSlash(root)+"/" {
+				t.Errorf("wrong %%SRCROOT%% %q", base)
+			}
+			if len(run.Tool.Driver.Rules) != 1 || run.Tool.Driver.Rules[0].ID != CodeUndefined {
+				t.Errorf("wrong rules %+v", run.Tool.Driver.Rules)
+			}
+			location := run.Results[0].Locations[0].PhysicalLocation
+			if location.ArtifactLocation.URI != tt.uri || location.ArtifactLocation.URIBaseID != tt.uriBaseID {
+				t.Errorf("expected uri %q with base %q, got %+v", tt.uri, tt.uriBaseID, location.ArtifactLocation)
+			}
+			if location.Region != (sarifRegion{StartLine: 1, StartColumn: 2, EndLine: 1, EndColumn: 4}) {
+				t.Errorf("wrong region %+v", location.Region)
+			}
+		})
+	}
+}
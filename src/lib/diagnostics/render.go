@@ -0,0 +1,212 @@
+package diagnostics
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/fatih/color"
+)
+
+// Diagnostics are rendered like this:
+//
+//	error[E0003]: Variable countr not found
+//	  --> main.cffc:6:12
+//	   |
+//	 6 |     return countr;
+//	   |            ^^^^^^
+//	   |
+//	 3 |     var counter: i64 = 0;
+//	   |     --- variable counter declared here
+//	   = help: did you mean `counter`?
+//
+// The primary span is underlined with carets, the spans of notes with dashes
+// followed by the message of the note.
+
+const tabWidth = 4
+
+// Sources reads the lines of the files diagnostics point into, every file is
+// read once. Files that can not be read are rendered without snippets.
+type Sources struct {
+	files map[string][]string
+}
+
+// Add sets the contents of filename, for code that was not read from disk.
+func (s *Sources) Add(filename string, contents string) {
+	if s.files == nil {
+		s.files = make(map[string][]string)
+	}
+	s.files[filename] = strings.Split(strings.ReplaceAll(contents, "\r\n", "\n"), "\n")
+}
+
+// Line returns the line of filename with the number line, starting at 1.
+func (s *Sources) Line(filename string, line int) (string, bool) {
+	lines, ok := s.files[filename]
+	if !ok {
+		contents, err := os.ReadFile(filename)
+		if err == nil {
+			s.Add(filename, string(contents))
+		} else if s.files == nil {
+			s.files = make(map[string][]string)
+		}
+		lines = s.files[filename]
+	}
+	if line < 1 || line > len(lines) {
+		return "", false
+	}
+	return lines[line-1], true
+}
+
+// Range returns span extended over the token it starts at, for spans that
+// only mark a position.
+func (s *Sources) Range(span Span) Span {
+	if span.EndLine != span.Line || span.EndColumn > span.Column {
+		return span
+	}
+	line, ok := s.Line(span.Filename, span.Line)
+	if !ok {
+		return span
+	}
+	runes := []rune(line)
+	start := min(max(span.Column-1, 0), len(runes))
+	span.EndColumn = span.Column + underlineLength(runes, start)
+	return span
+}
+
+// Write renders the diagnostics to w with the source code they point to.
+func (l List) Write(w io.Writer) {
+	var sources Sources
+	for _, d := range l {
+		d.Render(w, &sources)
+	}
+}
+
+func severityColor(s Severity) *color.Color {
+	switch s {
+	case Warning:
+		return color.New(color.FgYellow, color.Bold)
+	case Info:
+		return color.New(color.FgCyan, color.Bold)
+	default:
+		return color.New(color.FgRed, color.Bold)
+	}
+}
+
+// Render writes d to w, taking the source code from sources.
+func (d *Diagnostic) Render(w io.Writer, sources *Sources) {
+	primary := severityColor(d.Severity)
+	secondary := color.New(color.FgBlue, color.Bold)
+	bold := color.New(color.Bold)
+
+	primary.Fprintf(w, "%s[%s]", d.Severity, d.Code)
+	bold.Fprintf(w, ": %s\n", d.Message)
+
+	// Every snippet shares the gutter, sized for the largest line number
+	width := len(strconv.Itoa(d.Span.Line))
+	for _, note := range d.Notes {
+		if n := len(strconv.Itoa(note.Span.Line)); n > width {
+			width = n
+		}
+	}
+	gutter := strings.Repeat(" ", width)
+
+	if !d.Span.IsZero() {
+		secondary.Fprintf(w, "%s--> ", gutter)
+		fmt.Fprintln(w, d.Span)
+		renderSnippet(w, sources, d.Span, gutter, "^", "", primary, secondary)
+	}
+	for _, note := range d.Notes {
+		if note.Span.IsZero() {
+			continue
+		}
+		if note.Span.Filename != d.Span.Filename {
+			secondary.Fprintf(w, "%s::: ", gutter)
+			fmt.Fprintln(w, note.Span)
+		}
+		renderSnippet(w, sources, note.Span, gutter, "-", note.Message, secondary, secondary)
+	}
+	for _, note := range d.Notes {
+		if note.Span.IsZero() {
+			secondary.Fprintf(w, "%s = ", gutter)
+			bold.Fprint(w, "note")
+			fmt.Fprintf(w, ": %s\n", note.Message)
+		}
+	}
+	for _, help := range d.Help {
+		secondary.Fprintf(w, "%s = ", gutter)
+		bold.Fprint(w, "help")
+		fmt.Fprintf(w, ": %s\n", help)
+	}
+}
+
+// renderSnippet writes the line span starts on, underlined from the start of
+// span with mark, followed by label.
+func renderSnippet(w io.Writer, sources *Sources, span Span, gutter string, mark string, label string, markColor *color.Color, gutterColor *color.Color) {
+	line, ok := sources.Line(span.Filename, span.Line)
+	if !ok {
+		if label != "" {
+			gutterColor.Fprintf(w, "%s = ", gutter)
+			fmt.Fprintf(w, "%s: %s\n", span, label)
+		}
+		return
+	}
+
+	span = sources.Range(span)
+	runes := []rune(line)
+	start := min(max(span.Column-1, 0), len(runes))
+	length := max(span.EndColumn-span.Column, 1)
+	if span.EndLine != span.Line {
+		length = max(len(runes)-start, 1)
+	}
+
+	// Tabs are expanded, so the underline lines up in any terminal
+	expanded := strings.ReplaceAll(line, "\t", strings.Repeat(" ", tabWidth))
+	offset := len([]rune(strings.ReplaceAll(string(runes[:start]), "\t", strings.Repeat(" ", tabWidth))))
+
+	gutterColor.Fprintf(w, "%s |\n", gutter)
+	gutterColor.Fprintf(w, "%*d | ", len(gutter), span.Line)
+	fmt.Fprintln(w, expanded)
+	gutterColor.Fprintf(w, "%s | ", gutter)
+	fmt.Fprint(w, strings.Repeat(" ", offset))
+	markColor.Fprint(w, strings.Repeat(mark, length))
+	if label != "" {
+		markColor.Fprintf(w, " %s", label)
+	}
+	fmt.Fprintln(w)
+}
+
+// underlineLength returns the length of the identifier, number or string
+// starting at start in line, or 1 for any other token.
+func underlineLength(line []rune, start int) int {
+	if start >= len(line) {
+		return 1
+	}
+	end := start + 1
+	switch r := line[start]; {
+	case r == '"':
+		for end < len(line) && line[end] != '"' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end < len(line) {
+			end++
+		}
+	case unicode.IsDigit(r):
+		for end < len(line) && (line[end] == '.' || line[end] == '_' || unicode.IsLetter(line[end]) || unicode.IsDigit(line[end])) {
+			end++
+		}
+	case r == '_' || unicode.IsLetter(r):
+		for end < len(line) && (line[end] == '_' || unicode.IsLetter(line[end]) || unicode.IsDigit(line[end])) {
+			end++
+		}
+	}
+	if end > len(line) {
+		end = len(line)
+	}
+	return end - start
+}
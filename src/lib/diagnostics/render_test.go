@@ -0,0 +1,101 @@
+package diagnostics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestRender(t *testing.T) {
+	color.NoColor = true
+	source := "package main;\nfunc main(): i32 {\n\tvar counter: i64 = 0;\n\treturn countr;\n}\n"
+	tests := []struct {
+		name     string
+		d        *Diagnostic
+		expected string
+	}{
+		{
+			name: "identifier",
+			d:    Errorf(CodeUndefined, Span{Filename: "main.cffc", Line: 4, Column: 9, EndLine: 4, EndColumn: 9}, "Variable countr not found"),
+			expected: `error[E0003]: Variable countr not found
+ --> main.cffc:4:9
+  |
+4 |     return countr;
+  |            ^^^^^^
+`,
+		},
+		{
+			name: "note and help",
+			d: Errorf(CodeUndefined, Span{Filename: "main.cffc", Line: 4, Column: 9, EndLine: 4, EndColumn: 9}, "Variable countr not found").
+				WithNote(Span{Filename: "main.cffc", Line: 3, Column: 2, EndLine: 3, EndColumn: 2}, "variable counter declared here").
+				WithHelp("did you mean `counter`?"),
+			expected: `error[E0003]: Variable countr not found
+ --> main.cffc:4:9
+  |
+4 |     return countr;
+  |            ^^^^^^
+  |
+3 |     var counter: i64 = 0;
+  |     --- variable counter declared here
+  = help: did you mean ` + "`counter`" + `?
+`,
+		},
+		{
+			name: "punctuation",
+			d:    Errorf(CodeSyntax, Span{Filename: "main.cffc", Line: 1, Column: 13, EndLine: 1, EndColumn: 13}, "unexpected token"),
+			expected: `error[E0001]: unexpected token
+ --> main.cffc:1:13
+  |
+1 | package main;
+  |             ^
+`,
+		},
+		{
+			name: "note without a span",
+			d:    Errorf(CodeInternal, Span{}, "Internal compiler error").WithNote(Span{}, "this is a bug"),
+			expected: `error[E0000]: Internal compiler error
+  = note: this is a bug
+`,
+		},
+		{
+			name: "file that can not be read",
+			d:    Errorf(CodeImport, Span{Filename: "missing.cffc", Line: 2, Column: 1, EndLine: 2, EndColumn: 1}, "Unable to import"),
+			expected: `error[E0004]: Unable to import
+ --> missing.cffc:2:1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sources Sources
+			sources.Add("main.cffc", source)
+			var b strings.Builder
+			tt.d.Render(&b, &sources)
+			if b.String() != tt.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, b.String())
+			}
+		})
+	}
+}
+
+func TestSourcesRange(t *testing.T) {
+	var sources Sources
+	sources.Add("main.cffc", "var s = \"a \\\" b\" + 1.5e3;")
+	tests := []struct {
+		column    int
+		endColumn int
+	}{
+		{1, 4},   // var
+		{5, 6},   // s
+		{9, 17},  // the string, with its escaped quote
+		{18, 19}, // +
+		{20, 25}, // 1.5e3
+	}
+	for _, tt := range tests {
+		span := sources.Range(Span{Filename: "main.cffc", Line: 1, Column: tt.column, EndLine: 1, EndColumn: tt.column})
+		if span.EndColumn != tt.endColumn {
+			t.Errorf("span at column %d ends at %d, expected %d", tt.column, span.EndColumn, tt.endColumn)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package diagnostics
+
+// Closest returns the candidate closest to name by edit distance. Candidates
+// needing more edits than about a third of the length of name are not
+// considered similar, so unrelated names are never suggested. Names of two or
+// more characters may always be one edit off.
+func Closest(name string, candidates []string) (string, bool) {
+	best, bestDistance := "", len(name)/3+1
+	if len(name) >= 2 && bestDistance < 2 {
+		bestDistance = 2
+	}
+	for _, candidate := range candidates {
+		if candidate == name || candidate == "" {
+			continue
+		}
+		if d := distance(name, candidate); d < bestDistance || (d == bestDistance && best != "" && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best, best != ""
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
@@ -0,0 +1,46 @@
+package diagnostics
+
+import "testing"
+
+func TestClosest(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []string
+		closest    string
+		ok         bool
+	}{
+		{"countr", []string{"counter", "count", "main"}, "count", true},
+		{"prnitf", []string{"printf", "puts"}, "printf", true},
+		{"x", []string{"y", "z"}, "", false},
+		{"xx", []string{"x", "y"}, "x", true},
+		{"value", []string{"other", "thing"}, "", false},
+		{"main", []string{"main", ""}, "", false},
+		{"ab", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closest, ok := Closest(tt.name, tt.candidates)
+			if closest != tt.closest || ok != tt.ok {
+				t.Errorf("Closest(%q, %v) = %q, %t, expected %q, %t", tt.name, tt.candidates, closest, ok, tt.closest, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"counter", "countr", 1},
+		{"zlutý", "žlutý", 1},
+	}
+	for _, tt := range tests {
+		if d := distance(tt.a, tt.b); d != tt.distance {
+			t.Errorf("distance(%q, %q) = %d, expected %d", tt.a, tt.b, d, tt.distance)
+		}
+	}
+}
@@ -22,13 +22,14 @@ func (b *Bool) Capture(values []string) error {
 }
 
 type Value struct {
-	Pos    lexer.Position
-	Float  *float64      `parser:"  @('-'? Float)"`
-	Int    *int64        `parser:"| @('-'? Int)"`
-	Bool   *Bool         `parser:"| @('true' | 'True' | 'false' | 'False')"`
-	String *string       `parser:"| @String"`
-	Null   bool          `parser:"| @'null'"`
-	Array  []*Expression `parser:"| '[' ( @@ ( ',' @@ )* )? ']'"`
+	Pos     lexer.Position
+	Float   *float64      `parser:"  @('-'? Float)"`
+	Int     *int64        `parser:"| @('-'? Int)"`
+	Bool    *Bool         `parser:"| @('true' | 'True' | 'false' | 'False')"`
+	String  *string       `parser:"| @String"`
+	Null    bool          `parser:"| @'null'"`
+	IsArray bool          `parser:"| ( @'['"`
+	Array   []*Expression `parser:"( @@ ( ',' @@ )* )? ']' )"`
 }
 
 type Identifier struct {
@@ -42,18 +43,20 @@ type Identifier struct {
 
 type ArgumentList struct {
 	Pos       lexer.Position
-	Arguments []*Expression `parser:"( @@ ( ',' @@ )* )?"`
+	Arguments []*Expression `parser:"(@@ ( ',' @@ )* )?"`
 }
 
 type ClassInitializer struct {
 	Pos       lexer.Position
-	ClassName string       `parser:"@Ident"`
+	ClassName string       `parser:"@( Ident ( '.' Ident )* )"`
+	TypeArgs  []*Type      `parser:"( '<' @@ ( ',' @@ )* '>' )?"`
 	Args      ArgumentList `parser:"'(' @@ ')'"`
 }
 
 type FunctionCall struct {
 	Pos          lexer.Position
 	FunctionName string       `parser:"@( Ident | String )"`
+	TypeArgs     []*Type      `parser:"( '<' @@ ( ',' @@ )* '>' )?"`
 	Args         ArgumentList `parser:"'(' @@ ')'"`
 }
 
@@ -61,10 +64,10 @@ type Factor struct {
 	Pos              lexer.Position
 	Unpack           bool              `parser:"@'...'?"`
 	Value            *Value            `parser:"  @@"`
-	FunctionCall     *FunctionCall     `parser:"| (?= ( Ident | String ) '(') @@"`
+	FunctionCall     *FunctionCall     `parser:"| (?= ( Ident | String ) ( '<' ( Ident | '*' | ',' | '[' | ']' | Int | '<' ( Ident | '*' | ',' | '[' | ']' | Int )* '>' )* '>' )? '(') @@"`
 	BitCast          *BitCast          `parser:"| '(' @@"`
 	ClassInitializer *ClassInitializer `parser:"| 'new' @@"`
-	ClassMethod      *ClassMethod      `parser:"| (?= Ident ( '.' Ident)+ '(') @@"`
+	ClassMethod      *ClassMethod      `parser:"| (?= Ident ( '.' Ident)+ ( '<' ( Ident | '*' | ',' | '[' | ']' | Int | '<' ( Ident | '*' | ',' | '[' | ']' | Int )* '>' )* '>' )? '(') @@"`
 	Identifier       *Identifier       `parser:"| @@"`
 }
 
@@ -84,64 +87,95 @@ type Assignment struct {
 type Expression struct {
 	Pos       lexer.Position
 	Condition *LogicalOr  `parser:"@@"`
-	True      *Expression `parser:"('?' @@ ':')?"`
-	False     *Expression `parser:"@@?"`
+	True      *Expression `parser:"( '?' @@"`
+	False     *Expression `parser:"':' @@ )?"`
+}
+
+// SingleFactor returns the factor of an expression that consists of nothing
+// but that factor, or nil if any operator is applied to it.
+func (e *Expression) SingleFactor() *Factor {
+	if e == nil || e.True != nil || e.False != nil {
+		return nil
+	}
+
+	or := e.Condition
+	if len(or.Right) != 0 || len(or.Left.Right) != 0 {
+		return nil
+	}
+	bor := or.Left.Left
+	if len(bor.Right) != 0 || len(bor.Left.Right) != 0 || len(bor.Left.Left.Right) != 0 {
+		return nil
+	}
+	eq := bor.Left.Left.Left
+	if len(eq.Right) != 0 || len(eq.Left.Right) != 0 || len(eq.Left.Left.Right) != 0 {
+		return nil
+	}
+	add := eq.Left.Left.Left
+	if len(add.Right) != 0 || len(add.Left.Right) != 0 {
+		return nil
+	}
+	not := add.Left.Left
+	if not.Op != "" || not.Right.Op != "" || not.Right.Right.Op != "" || not.Right.Right.Right.Op != "" {
+		return nil
+	}
+
+	return not.Right.Right.Right.Left
 }
 
 type LogicalOr struct {
 	Pos   lexer.Position
 	Left  *LogicalAnd  `parser:"@@"`
-	Op    string       `parser:"@( '|' '|' | 'or' )?"`
-	Right []*LogicalOr `parser:"@@?"`
+	Op    string       `parser:"(@( '|' '|' | 'or' )"`
+	Right []*LogicalOr `parser:"@@)?"`
 }
 
 type LogicalAnd struct {
 	Pos   lexer.Position
 	Left  *BitwiseOr    `parser:"@@"`
-	Op    string        `parser:"@( '&' '&' | 'and' )?"`
-	Right []*LogicalAnd `parser:"@@?"`
+	Op    string        `parser:"(@( '&' '&' | 'and' )"`
+	Right []*LogicalAnd `parser:"@@)?"`
 }
 
 type BitwiseOr struct {
 	Pos   lexer.Position
 	Left  *BitwiseXor  `parser:"@@"`
-	Op    string       `parser:"@'|'?"`
-	Right []*BitwiseOr `parser:"@@?"`
+	Op    string       `parser:"(@( '|' (?! '|') )"`
+	Right []*BitwiseOr `parser:"@@)?"`
 }
 
 type BitwiseXor struct {
 	Pos   lexer.Position
 	Left  *BitwiseAnd   `parser:"@@"`
-	Op    string        `parser:"@'^'?"`
-	Right []*BitwiseXor `parser:"@@?"`
+	Op    string        `parser:"(@'^'"`
+	Right []*BitwiseXor `parser:"@@)?"`
 }
 
 type BitwiseAnd struct {
 	Pos   lexer.Position
 	Left  *Equality     `parser:"@@"`
-	Op    string        `parser:"@'&'?"`
-	Right []*BitwiseAnd `parser:"@@?"`
+	Op    string        `parser:"(@( '&' (?! '&') )"`
+	Right []*BitwiseAnd `parser:"@@)?"`
 }
 
 type Equality struct {
 	Pos   lexer.Position
 	Left  *Relational `parser:"@@"`
-	Op    string      `parser:"@( '=' '=' | '!' '=' )?"`
-	Right []*Equality `parser:"@@?"`
+	Op    string      `parser:"(@( '=' '=' | '!' '=' )"`
+	Right []*Equality `parser:"@@)?"`
 }
 
 type Relational struct {
 	Pos   lexer.Position
 	Left  *Shift        `parser:"@@"`
-	Op    string        `parser:"@( '<' '=' | '>' '=' | '<' | '>' )?"`
-	Right []*Relational `parser:"@@?"`
+	Op    string        `parser:"(@( '<' '=' | '>' '=' | '<' | '>' )"`
+	Right []*Relational `parser:"@@)?"`
 }
 
 type Shift struct {
 	Pos   lexer.Position
 	Left  *Additive `parser:"@@"`
-	Op    string    `parser:"@( '<' '<' | '>' '>' | '>' '>' '>' )?"`
-	Right []*Shift  `parser:"@@?"`
+	Op    string    `parser:"(@( '<' '<' | '>' '>' | '>' '>' '>' )"`
+	Right []*Shift  `parser:"@@)?"`
 }
 
 type Additive struct {
@@ -186,15 +220,17 @@ type VariableDefinition struct {
 	Pos        lexer.Position
 	Constant   string      `parser:"@('const' | 'var')"`
 	Name       string      `parser:"@Ident"`
-	Type       *Type       `parser:"':' @@"`
+	Type       *Type       `parser:"( ':' @@ )?"`
 	Assignment *Expression `parser:"( '=' @@ )?"`
 }
 
 type FieldDefinition struct {
-	Pos     lexer.Position
-	Private bool   `parser:"@'private'?"`
-	Name    string `parser:"@Ident"`
-	Type    *Type  `parser:"':' @@ ';'"`
+	Pos        lexer.Position
+	Private    bool        `parser:"@'private'?"`
+	Static     bool        `parser:"@'static'?"`
+	Name       string      `parser:"@Ident"`
+	Type       *Type       `parser:"':' @@"`
+	Assignment *Expression `parser:"( '=' @@ )? ';'"`
 }
 
 type ArgumentDefinition struct {
@@ -205,17 +241,19 @@ type ArgumentDefinition struct {
 
 type FuncName struct {
 	Dummy string `parser:"'func'"`
-	Op    bool   `parser:"@'op'?"`
-	Get   bool   `parser:"@'get'?"`
-	Set   bool   `parser:"@'set'?"`
+	Op    bool   `parser:"( @'op' (?= Ident | String) )?"`
+	Get   bool   `parser:"( @'get' (?= Ident | String) )?"`
+	Set   bool   `parser:"( @'set' (?= Ident | String) )?"`
 	Name  string `parser:"@(Ident | String)"`
 }
 
 type FunctionDefinition struct {
 	Pos        lexer.Position
+	Extern     bool                  `parser:"@'extern'?"`
 	Private    bool                  `parser:"@'private'?"`
 	Static     bool                  `parser:"@'static'?"`
 	Name       FuncName              `parser:"@@"`
+	TypeParams []string              `parser:"( '<' @Ident ( ',' @Ident )* '>' )?"`
 	Parameters []*ArgumentDefinition `parser:"'(' ( @@ ( ',' @@ )* )?"`
 	Variadic   string                `parser:"(',' '.' '.' '.' @Ident)?"`
 	ReturnType []*Type               `parser:"')' ( ':' @@ ( ',' @@ )* )?"`
@@ -223,14 +261,43 @@ type FunctionDefinition struct {
 }
 
 type ClassDefinition struct {
-	Pos  lexer.Position
-	Name string       `parser:"@Ident"`
-	Body []*Statement `parser:"'{' @@* '}'"`
+	Pos        lexer.Position
+	Name       string       `parser:"@Ident"`
+	TypeParams []string     `parser:"( '<' @Ident ( ',' @Ident )* '>' )?"`
+	Extends    string       `parser:"( 'extends' @Ident )?"`
+	Implements []string     `parser:"( 'implements' @Ident ( ',' @Ident )* )?"`
+	Body       []*Statement `parser:"'{' @@* '}'"`
+}
+
+type InterfaceDefinition struct {
+	Pos     lexer.Position
+	Name    string             `parser:"@Ident"`
+	Methods []*InterfaceMethod `parser:"'{' @@* '}'"`
+}
+
+type InterfaceMethod struct {
+	Pos        lexer.Position
+	Name       string                `parser:"'func' @Ident"`
+	Parameters []*ArgumentDefinition `parser:"'(' ( @@ ( ',' @@ )* )? ')'"`
+	ReturnType []*Type               `parser:"( ':' @@ ( ',' @@ )* )? ';'"`
+}
+
+type EnumDefinition struct {
+	Pos      lexer.Position
+	Name     string         `parser:"@Ident"`
+	Variants []*EnumVariant `parser:"'{' ( @@ ( ',' @@ )* ','? )? '}'"`
+}
+
+type EnumVariant struct {
+	Pos     lexer.Position
+	Name    string  `parser:"@Ident"`
+	Payload []*Type `parser:"( '(' @@ ( ',' @@ )* ')' )?"`
 }
 
 type ClassMethod struct {
 	Pos        lexer.Position
 	Identifier *Identifier   `parser:"@@"`
+	TypeArgs   []*Type       `parser:"( '<' @@ ( ',' @@ )* '>' )?"`
 	Args       *ArgumentList `parser:"'(' @@ ')'"`
 }
 
@@ -278,7 +345,28 @@ type Switch struct {
 type Case struct {
 	Pos    lexer.Position
 	Values []*Expression `parser:"'case' @@ ( ',' @@ )* ':'"`
-	Body   []*Statement  `parser:"@@*"`
+	Body   []*Statement  `parser:"( (?! 'case' | 'default' ) @@ )*"`
+}
+
+type Match struct {
+	Pos        lexer.Position
+	Value      *Expression  `parser:"'(' @@ ')'"`
+	Arms       []*MatchArm  `parser:"'{' @@*"`
+	HasDefault bool         `parser:"( @'default' ':'"`
+	Default    []*Statement `parser:"@@* )? '}'"`
+}
+
+type MatchArm struct {
+	Pos      lexer.Position
+	Patterns []*Pattern   `parser:"'case' @@ ( ',' @@ )* ':'"`
+	Body     []*Statement `parser:"( (?! 'case' | 'default' ) @@ )*"`
+}
+
+type Pattern struct {
+	Pos      lexer.Position
+	Enum     string   `parser:"( (?= Ident '.') @Ident '.' )?"`
+	Variant  string   `parser:"@Ident"`
+	Bindings []string `parser:"( '(' ( @Ident ( ',' @Ident )* )? ')' )?"`
 }
 
 type Return struct {
@@ -296,7 +384,7 @@ type ExternalFunctionDefinition struct {
 
 type TryCatch struct {
 	Pos   lexer.Position
-	Try   []*Statement `parser:"'try' '{' @@* '}'"`
+	Try   []*Statement `parser:"'{' @@* '}'"`
 	Catch *Catch       `parser:"'catch' @@"`
 	Final []*Statement `parser:"('finally' '{' @@* '}')?"`
 }
@@ -308,11 +396,12 @@ type Catch struct {
 }
 
 type Type struct {
-	Pos   lexer.Position
-	Array *Expression `parser:"('[' @@ ']')?"`
-	Ptr   string      `parser:"@'*'*"`
-	Name  string      `parser:"@Ident"`
-	Inner *Type       `parser:"| @@"`
+	Pos      lexer.Position
+	Array    *Expression `parser:"('[' @@ ']')?"`
+	Ptr      string      `parser:"@'*'*"`
+	Name     string      `parser:"(@( Ident ( '.' Ident )* )"`
+	TypeArgs []*Type     `parser:"( (?= '<' ( Ident | '.' | '*' | ',' | '[' | ']' | Int | '<' ( Ident | '.' | '*' | ',' | '[' | ']' | Int )* '>' )* '>' ) '<' @@ ( ',' @@ )* '>' )?"`
+	Inner    *Type       `parser:"| @@ )"`
 }
 
 type Import struct {
@@ -340,18 +429,22 @@ type Statement struct {
 	Pos                lexer.Position
 	VariableDefinition *VariableDefinition         `parser:"(?= ('const' | 'var') Ident) @@? (';' | '\\n')?"`
 	Assignment         *Assignment                 `parser:"| (?= '*'*'&'*Ident ('['~']'']')?('.''*'*'&'*Ident('['~']'']')?)*(',''*'*'&'*Ident('['~']'']')?('.''*'*'&'*Ident('['~']'']')?)*)*('+'|'-'|'*'|'/'|'%'|'&'|'|'|'^'|'<''<'|'>''>'|'>''>''>'|'?''?')?'=')@@?(';' | '\\n')?"`
-	External           *ExternalFunctionDefinition `parser:"| 'extern' @@ ';'"`
+	External           *ExternalFunctionDefinition `parser:"| (?= 'extern' 'func' ~('{' | ';')* ';') 'extern' @@ ';'"`
 	Export             *Statement                  `parser:"| 'export' @@"`
-	FunctionDefinition *FunctionDefinition         `parser:"| (?= 'private'? 'static'? 'func') @@?"`
+	FunctionDefinition *FunctionDefinition         `parser:"| (?= 'extern'? 'private'? 'static'? 'func') @@?"`
 	TryCatch           *TryCatch                   `parser:"| 'try' @@"`
+	Throw              *Expression                 `parser:"| 'throw' @@ ';'"`
 	Switch             *Switch                     `parser:"| 'switch' @@"`
 	ClassDefinition    *ClassDefinition            `parser:"| 'class' @@?"`
+	Interface          *InterfaceDefinition        `parser:"| 'interface' @@"`
+	Enum               *EnumDefinition             `parser:"| 'enum' @@"`
+	Match              *Match                      `parser:"| 'match' @@"`
 	If                 *If                         `parser:"| 'if' @@?"`
 	For                *For                        `parser:"| 'for' @@?"`
 	While              *While                      `parser:"| 'while' @@?"`
 	Until              *Until                      `parser:"| 'until' @@?"`
 	Return             *Return                     `parser:"| 'return' @@?"`
-	FieldDefinition    *FieldDefinition            `parser:"| (?= 'private'? Ident ':' ('[' ~']' ']')? '*'* Ident) @@?"`
+	FieldDefinition    *FieldDefinition            `parser:"| (?= 'private'? 'static'? Ident ':' ('[' ~']' ']')* '*'* Ident) @@?"`
 	Import             *Import                     `parser:"| 'import' @@?"`
 	FromImportMultiple *FromImportMultiple         `parser:"| (?= 'from' String 'import' '{') @@?"`
 	FromImport         *FromImport                 `parser:"| (?= 'from' String 'import') @@?"`
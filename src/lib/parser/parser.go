@@ -1,39 +1,37 @@
 package parser
 
 import (
+	"errors"
 	"os"
 
 	"github.com/alecthomas/participle/v2"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
 	cflex "github.com/vyPal/CaffeineC/lib/lexer"
 )
 
 var parser *participle.Parser[Program]
 var parsed map[string]*Program
 
-func ParseFile(filename string) *Program {
+func ParseFile(filename string) (*Program, error) {
 	if parsed == nil {
 		parsed = make(map[string]*Program)
 	}
 
 	if parsed[filename] != nil {
-		return parsed[filename]
-	}
-
-	if parser == nil {
-		parser = participle.MustBuild[Program](participle.Lexer(cflex.DefaultDefinition))
+		return parsed[filename], nil
 	}
 
 	file, err := os.ReadFile(filename)
 	if err != nil {
-		panic(err)
+		return nil, diagnostics.Errorf(diagnostics.CodeImport, diagnostics.Span{}, "Unable to read %s: %s", filename, err)
 	}
 
-	ast, err := parser.ParseString(filename, string(file))
+	ast, err := Parser().ParseString(filename, string(file))
 	if err != nil {
-		panic(err)
+		return recoverProgram(filename, string(file), err)
 	}
 	parsed[filename] = ast
-	return ast
+	return ast, nil
 }
 
 func Parser() *participle.Parser[Program] {
@@ -43,14 +41,19 @@ func Parser() *participle.Parser[Program] {
 	return parser
 }
 
-func ParseString(code string) *Program {
-	if parser == nil {
-		parser = participle.MustBuild[Program](participle.Lexer(cflex.DefaultDefinition))
+func ParseString(code string) (*Program, error) {
+	ast, err := Parser().ParseString("", code)
+	if err != nil {
+		return recoverProgram("", code, err)
 	}
+	return ast, nil
+}
 
-	ast, err := parser.ParseString("", code)
-	if err != nil {
-		panic(err)
+// syntaxError turns an error returned by participle into a diagnostic.
+func syntaxError(err error) *diagnostics.Diagnostic {
+	var perr participle.Error
+	if !errors.As(err, &perr) {
+		return diagnostics.Errorf(diagnostics.CodeSyntax, diagnostics.Span{}, "%s", err)
 	}
-	return ast
+	return diagnostics.Errorf(diagnostics.CodeSyntax, diagnostics.At(perr.Position()), "%s", perr.Message())
 }
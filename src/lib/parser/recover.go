@@ -0,0 +1,284 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"text/scanner"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+)
+
+// Files that fail to parse are parsed again one statement at a time, so a
+// single parse reports every syntax error. A statement that fails is skipped
+// up to the next ';' or the end of its block, or to the keyword starting the
+// next statement. Blocks of a statement that fails are parsed on their own,
+// so errors inside of them still leave the statement in the partial AST with
+// the statements of its blocks that did parse.
+
+// Keywords that start a statement, parsing resumes at them after an error.
+var statementKeywords = map[string]bool{
+	"var": true, "const": true, "func": true, "class": true, "interface": true,
+	"enum": true, "if": true, "for": true, "while": true, "until": true,
+	"switch": true, "match": true, "try": true, "throw": true, "return": true,
+	"break": true, "continue": true, "import": true, "from": true,
+	"export": true, "extern": true,
+}
+
+// Keywords that can precede a statement keyword within the same statement.
+var statementPrefixes = map[string]bool{
+	"else": true, "export": true, "extern": true, "private": true, "static": true,
+}
+
+// sliceLexer replays tokens that were already lexed, ending with an EOF at eof.
+type sliceLexer struct {
+	tokens []lexer.Token
+	eof    lexer.Position
+}
+
+func (l *sliceLexer) Next() (lexer.Token, error) {
+	if len(l.tokens) == 0 {
+		return lexer.EOFToken(l.eof), nil
+	}
+	t := l.tokens[0]
+	l.tokens = l.tokens[1:]
+	return t, nil
+}
+
+// is reports whether t is the punctuation token r.
+func is(t lexer.Token, r rune) bool {
+	return t.Type == lexer.TokenType(r)
+}
+
+// keyword returns the identifier t, or "" if t is not an identifier.
+func keyword(t lexer.Token) string {
+	if t.Type != scanner.Ident {
+		return ""
+	}
+	return t.Value
+}
+
+type recovery struct {
+	parser *participle.Parser[Statement]
+	tokens []lexer.Token
+	errs   diagnostics.List
+}
+
+// recoverProgram parses the tokens of a file that failed to parse with err,
+// returning the statements that could be parsed and the syntax errors.
+func recoverProgram(filename string, contents string, err error) (*Program, error) {
+	tokens, lexErr := Parser().Lex(filename, strings.NewReader(contents))
+	if lexErr != nil {
+		return nil, syntaxError(lexErr)
+	}
+	stmtParser, err := participle.ParserForProduction[Statement](Parser())
+	if err != nil {
+		panic(err)
+	}
+	r := &recovery{parser: stmtParser, tokens: tokens}
+
+	program := &Program{Pos: tokens[0].Pos}
+	if program.Pos.Line == 0 {
+		// The EOF of an empty file is not on any line
+		program.Pos.Line, program.Pos.Column = 1, 1
+	}
+	start := 0
+	switch {
+	case len(tokens) > 2 && keyword(tokens[0]) == "package" && keyword(tokens[1]) != "" && is(tokens[2], ';'):
+		program.Package = tokens[1].Value
+		start = 3
+	case len(tokens) > 2 && keyword(tokens[0]) == "package" && keyword(tokens[1]) != "":
+		// Only the ';' is missing, the statements after it are parsed
+		program.Package = tokens[1].Value
+		start = 2
+		end := tokens[1].Pos
+		end.Offset += len(tokens[1].Value)
+		end.Column += len(tokens[1].Value)
+		r.errorf(end, "expected \";\" after the package name")
+	default:
+		r.errorf(program.Pos, "expected a package declaration like \"package main;\"")
+	}
+	program.Statements = r.statements(start, len(tokens)-1)
+	if len(r.errs) == 0 {
+		// Every statement parses on its own, report the original error
+		r.errs = append(r.errs, syntaxError(err))
+	}
+	return program, r.errs
+}
+
+func (r *recovery) errorf(pos lexer.Position, format string, args ...interface{}) {
+	r.errs = append(r.errs, diagnostics.Errorf(diagnostics.CodeSyntax, diagnostics.At(pos), format, args...))
+}
+
+// parse parses a statement from tokens, followed by an EOF at eof. It returns
+// the number of tokens the statement spans.
+func (r *recovery) parse(tokens []lexer.Token, eof lexer.Position, trailing bool) (*Statement, int, error) {
+	lex, err := lexer.Upgrade(&sliceLexer{tokens: tokens, eof: eof})
+	if err != nil {
+		return nil, 0, err
+	}
+	stmt, err := r.parser.ParseFromLexer(lex, participle.AllowTrailing(trailing))
+	if err != nil {
+		return nil, 0, err
+	}
+	return stmt, int(lex.RawCursor()), nil
+}
+
+// statements parses the statements in tokens[start:end], where tokens[end]
+// is the '}' closing the block or the EOF of the file.
+func (r *recovery) statements(start int, end int) []*Statement {
+	var stmts []*Statement
+	for i := start; i < end; {
+		stmt, n, err := r.parse(r.tokens[i:end+1], r.tokens[end].Pos, true)
+		if err == nil && n > 0 {
+			stmts = append(stmts, stmt)
+			i += n
+			continue
+		}
+
+		extent := r.extent(i, end)
+		if stmt := r.recoverBlocks(i, extent, err); stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+		i = extent
+	}
+	return stmts
+}
+
+// extent returns the index of the token after the statement starting at
+// tokens[start], which failed to parse.
+func (r *recovery) extent(start int, end int) int {
+	braces, parens := 0, 0
+	for i := start; i < end; i++ {
+		t := r.tokens[i]
+		if i > start && braces == 0 && parens == 0 && statementKeywords[keyword(t)] && !statementPrefixes[keyword(r.tokens[i-1])] {
+			return i
+		}
+		switch {
+		case is(t, '('):
+			parens++
+		case is(t, ')'):
+			parens--
+		case is(t, '{'):
+			braces++
+		case is(t, '}'):
+			braces--
+			if braces < 0 {
+				// A stray '}' is skipped on its own
+				return max(i, start+1)
+			}
+			if braces == 0 {
+				next := keyword(r.tokens[i+1])
+				if next != "else" && next != "catch" && next != "finally" && !is(r.tokens[i+1], ';') {
+					return i + 1
+				}
+			}
+		case is(t, ';'):
+			if braces == 0 && parens <= 0 {
+				return i + 1
+			}
+		}
+	}
+	return end
+}
+
+// blocks returns the bodies of stmt, in the order they appear in the source.
+// Only blocks holding statements are returned, count is the number of blocks
+// stmt was parsed from, needed to tell whether an if has an else block.
+func blocks(stmt *Statement, count int) []*[]*Statement {
+	switch {
+	case stmt.Export != nil:
+		return blocks(stmt.Export, count)
+	case stmt.FunctionDefinition != nil:
+		return []*[]*Statement{&stmt.FunctionDefinition.Body}
+	case stmt.ClassDefinition != nil:
+		return []*[]*Statement{&stmt.ClassDefinition.Body}
+	case stmt.If != nil:
+		bodies := []*[]*Statement{&stmt.If.Body}
+		for _, elseIf := range stmt.If.ElseIf {
+			bodies = append(bodies, &elseIf.Body)
+		}
+		if count > len(bodies) {
+			bodies = append(bodies, &stmt.If.Else)
+		}
+		return bodies
+	case stmt.For != nil:
+		return []*[]*Statement{&stmt.For.Body}
+	case stmt.While != nil:
+		return []*[]*Statement{&stmt.While.Body}
+	case stmt.Until != nil:
+		return []*[]*Statement{&stmt.Until.Body}
+	case stmt.TryCatch != nil:
+		bodies := []*[]*Statement{&stmt.TryCatch.Try}
+		if stmt.TryCatch.Catch != nil {
+			bodies = append(bodies, &stmt.TryCatch.Catch.Body)
+		}
+		if count > len(bodies) {
+			bodies = append(bodies, &stmt.TryCatch.Final)
+		}
+		return bodies
+	}
+	return nil
+}
+
+// recoverBlocks handles the statement in tokens[start:end] that failed to
+// parse with err. If the statement parses with its blocks left empty, the
+// error is inside of them, so they are parsed on their own and the statement
+// is returned with them. Otherwise err is reported and nil is returned.
+func (r *recovery) recoverBlocks(start int, end int, err error) *Statement {
+	// Empty the blocks at the top level of the statement
+	var emptied []lexer.Token
+	var ranges [][2]int
+	depth := 0
+	for i := start; i < end; i++ {
+		t := r.tokens[i]
+		if is(t, '}') {
+			depth--
+			if depth == 0 {
+				ranges[len(ranges)-1][1] = i
+			}
+		}
+		if depth == 0 {
+			emptied = append(emptied, t)
+		}
+		if is(t, '{') {
+			if depth == 0 {
+				ranges = append(ranges, [2]int{i + 1, end})
+			}
+			depth++
+		}
+	}
+
+	if len(ranges) > 0 && depth == 0 {
+		stmt, n, emptyErr := r.parse(emptied, r.tokens[end].Pos, false)
+		if emptyErr == nil && n == len(emptied) {
+			bodies := blocks(stmt, len(ranges))
+			if len(bodies) == len(ranges) {
+				reported := len(r.errs)
+				for i, body := range bodies {
+					*body = r.statements(ranges[i][0], ranges[i][1])
+				}
+				if len(r.errs) == reported {
+					r.errs = append(r.errs, syntaxError(err))
+				}
+				return stmt
+			}
+			// Blocks that do not hold statements are left empty
+			r.errs = append(r.errs, syntaxError(err))
+			return stmt
+		}
+	}
+
+	// Parsing just the statement keeps the error from pointing past its end
+	var localErr participle.Error
+	if _, _, e := r.parse(r.tokens[start:end], r.tokens[end].Pos, false); errors.As(e, &localErr) && localErr.Position().Offset < r.tokens[end].Pos.Offset {
+		err = localErr
+	}
+	if err == nil {
+		r.errorf(r.tokens[start].Pos, "unexpected token %q", r.tokens[start].String())
+	} else {
+		r.errs = append(r.errs, syntaxError(err))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vyPal/CaffeineC/lib/diagnostics"
+)
+
+func TestRecoverProgram(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// Positions and messages of the syntax errors
+		errors []string
+		// Names of the top level statements recovered, and the number of
+		// statements in the body of main
+		functions []string
+		body      int
+	}{
+		{
+			name:      "missing semicolon after the package",
+			src:       "package main\nfunc main(): i32 {\n  return 0;\n}\n",
+			errors:    []string{`1:13: expected ";" after the package name`},
+			functions: []string{"main"},
+			body:      1,
+		},
+		{
+			name:   "empty file",
+			src:    "",
+			errors: []string{`1:1: expected a package declaration like "package main;"`},
+		},
+		{
+			name:   "missing package",
+			src:    "func main(): i32 {\n  return 0;\n}\n",
+			errors: []string{`1:1: expected a package declaration like "package main;"`},
+			// The statements are still parsed
+			functions: []string{"main"},
+			body:      1,
+		},
+		{
+			name:      "errors in a body",
+			src:       "package main;\nfunc main(): i32 {\n  var x: i64 = ;\n  var y: i64 = 1;\n  var z = (;\n  return 0;\n}\n",
+			errors:    []string{"3:", "5:"},
+			functions: []string{"main"},
+			body:      3,
+		},
+		{
+			name:      "errors in separate functions",
+			src:       "package main;\nfunc f() {\n  1 +;\n}\nfunc g() { }\nfunc main(): i32 {\n  return;;\n}\n",
+			errors:    []string{"3:", "7:"},
+			functions: []string{"f", "g", "main"},
+			body:      1,
+		},
+		{
+			name:      "stray closing brace",
+			src:       "package main;\n}\nfunc main(): i32 {\n  return 0;\n}\n",
+			errors:    []string{"2:1:"},
+			functions: []string{"main"},
+			body:      1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := ParseString(tt.src)
+			var diags diagnostics.List
+			if !errors.As(err, &diags) {
+				t.Fatalf("expected a list of syntax errors, got %v", err)
+			}
+			if len(diags) != len(tt.errors) {
+				t.Fatalf("expected %d errors, got %d:\n%s", len(tt.errors), len(diags), diags)
+			}
+			for i, expected := range tt.errors {
+				got := fmt.Sprintf("%d:%d: %s", diags[i].Span.Line, diags[i].Span.Column, diags[i].Message)
+				if diags[i].Code != diagnostics.CodeSyntax || !strings.HasPrefix(got, expected) {
+					t.Errorf("expected error %d to start with %q, got %q", i+1, expected, got)
+				}
+			}
+
+			var functions []string
+			body := -1
+			for _, s := range program.Statements {
+				if s.FunctionDefinition == nil {
+					continue
+				}
+				functions = append(functions, s.FunctionDefinition.Name.Name)
+				if s.FunctionDefinition.Name.Name == "main" {
+					body = len(s.FunctionDefinition.Body)
+				}
+			}
+			if strings.Join(functions, ",") != strings.Join(tt.functions, ",") {
+				t.Errorf("expected functions %v, got %v", tt.functions, functions)
+			}
+			if len(tt.functions) > 0 && body != tt.body {
+				t.Errorf("expected main to have %d statements, got %d", tt.body, body)
+			}
+		})
+	}
+}